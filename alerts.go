@@ -0,0 +1,493 @@
+package openminder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// The comparisons that an alert rule can make
+const (
+	OpAbove     = ">"
+	OpBelow     = "<"
+	OpOutside   = "outside"
+	OpUnchanged = "unchanged"
+)
+
+// The severities an alert rule can have
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlertRule is a rule that raises an alert when a reading meets a condition for
+// a minimum amount of time.  The threshold is the value plus the reading of the
+// ref field if one is given, so runoff_ec > irrig_ec + 1.0 is field: runoff_ec,
+// op: >, ref: irrig_ec, value: 1.0.  No irrigation tips in 4 hours is field:
+// irrig_tips, op: unchanged, for: 14400.
+type AlertRule struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Field      string  `json:"field"`
+	Op         string  `json:"op"`
+	Value      float64 `json:"value"`
+	Ref        string  `json:"ref,omitempty"`
+	Min        float64 `json:"min,omitempty"`
+	Max        float64 `json:"max,omitempty"`
+	Severity   string  `json:"severity"`
+	Hysteresis float64 `json:"hysteresis"`
+
+	// For is how many seconds the condition must be met before the alert is raised
+	For int `json:"for"`
+}
+
+// Validate checks that the rule makes sense, filling in the default severity
+func (r *AlertRule) Validate() error {
+	if err := checkReadingFields([]string{r.Field}); err != nil {
+		return err
+	}
+
+	if r.Ref != "" {
+		if err := checkReadingFields([]string{r.Ref}); err != nil {
+			return err
+		}
+	}
+
+	switch r.Op {
+	case OpAbove, OpBelow, OpUnchanged:
+	case OpOutside:
+		if r.Min >= r.Max {
+			return fmt.Errorf("min must be less than max")
+		}
+
+		// the value has to come back inside the range past the hysteresis on
+		// both sides for the alert to clear
+		if r.Hysteresis*2 >= r.Max-r.Min {
+			return fmt.Errorf("hysteresis must be less than half of the range or the alert can never clear")
+		}
+	default:
+		return fmt.Errorf("op must be one of %s, %s, %s or %s", OpAbove, OpBelow, OpOutside, OpUnchanged)
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("severity must be one of %s, %s or %s", SeverityInfo, SeverityWarning, SeverityCritical)
+	}
+
+	if r.Hysteresis < 0 || r.For < 0 {
+		return fmt.Errorf("hysteresis and for can't be negative")
+	}
+
+	if r.Name == "" {
+		r.Name = r.describe()
+	}
+
+	return nil
+}
+
+func (r AlertRule) threshold(values map[string]float64) (float64, bool) {
+	if r.Ref == "" {
+		return r.Value, true
+	}
+
+	ref, ok := values[r.Ref]
+	return ref + r.Value, ok
+}
+
+// met returns true if the value meets the condition to raise an alert
+func (r AlertRule) met(v, threshold float64, st *ruleState) bool {
+	switch r.Op {
+	case OpAbove:
+		return v > threshold
+	case OpBelow:
+		return v < threshold
+	case OpOutside:
+		return v < r.Min || v > r.Max
+	case OpUnchanged:
+		return st.seen && v == st.last
+	}
+
+	return false
+}
+
+// cleared returns true if the value has come back past the hysteresis so an
+// active alert can be cleared
+func (r AlertRule) cleared(v, threshold float64, st *ruleState) bool {
+	switch r.Op {
+	case OpAbove:
+		return v <= threshold-r.Hysteresis
+	case OpBelow:
+		return v >= threshold+r.Hysteresis
+	case OpOutside:
+		return v >= r.Min+r.Hysteresis && v <= r.Max-r.Hysteresis
+	case OpUnchanged:
+		return v != st.last
+	}
+
+	return true
+}
+
+func (r AlertRule) describe() string {
+	var s string
+	switch r.Op {
+	case OpOutside:
+		s = fmt.Sprintf("%s outside %g-%g", r.Field, r.Min, r.Max)
+	case OpUnchanged:
+		s = fmt.Sprintf("%s unchanged", r.Field)
+	default:
+		s = fmt.Sprintf("%s %s %g", r.Field, r.Op, r.Value)
+		if r.Ref != "" {
+			s = fmt.Sprintf("%s %s %s + %g", r.Field, r.Op, r.Ref, r.Value)
+		}
+	}
+
+	if r.For > 0 {
+		s += fmt.Sprintf(" for %s", time.Duration(r.For)*time.Second)
+	}
+
+	return s
+}
+
+// Alert is raised when the condition of a rule has been met
+type Alert struct {
+	RuleID   string    `json:"rule_id"`
+	Name     string    `json:"name"`
+	Severity string    `json:"severity"`
+	Field    string    `json:"field"`
+	Value    float64   `json:"value"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Active   bool      `json:"active"`
+}
+
+func (a Alert) key() []byte {
+	return append(timeKey(a.Start), []byte(a.RuleID)...)
+}
+
+type ruleState struct {
+	since   time.Time
+	last    float64
+	changed time.Time
+	seen    bool
+	active  *Alert
+}
+
+// alertEngine evaluates the alert rules against the readings, keeping the rules
+// and the alerts they raise in bolt buckets
+type alertEngine struct {
+	db          *bolt.DB
+	rulesBucket []byte
+	bucket      []byte
+	rules       map[string]AlertRule
+	states      map[string]*ruleState
+	onAlertCB   func(Alert)
+	mu          *sync.Mutex
+}
+
+func newAlertEngine(db *bolt.DB) (*alertEngine, error) {
+	ae := &alertEngine{
+		db:          db,
+		rulesBucket: []byte("alert_rules"),
+		bucket:      []byte("alerts"),
+		rules:       map[string]AlertRule{},
+		states:      map[string]*ruleState{},
+		onAlertCB:   func(Alert) {},
+		mu:          new(sync.Mutex),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		rb, err := tx.CreateBucketIfNotExists(ae.rulesBucket)
+		if err != nil {
+			return err
+		}
+
+		err = rb.ForEach(func(k, v []byte) error {
+			r := AlertRule{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			ae.rules[r.ID] = r
+			ae.states[r.ID] = &ruleState{}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucketIfNotExists(ae.bucket)
+		if err != nil {
+			return err
+		}
+
+		// carry on with the alerts that were active before a restart, and end
+		// the ones whose rule has gone
+		ended := []Alert{}
+		err = b.ForEach(func(k, v []byte) error {
+			a := Alert{}
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+
+			if !a.Active {
+				return nil
+			}
+
+			// the value the alert was raised with is the last one seen, so an
+			// unchanged alert isn't cleared by the first reading
+			if st, ok := ae.states[a.RuleID]; ok {
+				st.active = &a
+				st.since = a.Start
+				st.last = a.Value
+				st.seen = true
+				st.changed = a.Start
+				return nil
+			}
+
+			a.Active = false
+			a.End = time.Now()
+			ended = append(ended, a)
+			return nil
+		})
+
+		for _, a := range ended {
+			data, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(a.key(), data); err != nil {
+				return err
+			}
+		}
+
+		return err
+	})
+
+	return ae, err
+}
+
+// OnAlert registers a function to be called when an alert is raised or cleared
+func (ae *alertEngine) OnAlert(cb func(Alert)) {
+	ae.onAlertCB = cb
+}
+
+// Rules returns the alert rules sorted by ID
+func (ae *alertEngine) Rules() []AlertRule {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	rules := []AlertRule{}
+	for _, r := range ae.rules {
+		rules = append(rules, r)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// SetRule validates and saves the rule, giving it an ID if it doesn't have one
+func (ae *alertEngine) SetRule(r AlertRule) (AlertRule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+
+	if r.ID == "" {
+		r.ID = fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	err = ae.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ae.rulesBucket).Put([]byte(r.ID), data)
+	})
+
+	if err != nil {
+		return r, err
+	}
+
+	// a changed rule starts again, clearing any alert it had raised
+	if st := ae.states[r.ID]; st != nil && st.active != nil {
+		err = ae.clear(st, time.Now())
+	}
+
+	ae.rules[r.ID] = r
+	ae.states[r.ID] = &ruleState{}
+	return r, err
+}
+
+// DeleteRule removes the rule, clearing any alert it had raised
+func (ae *alertEngine) DeleteRule(id string) (bool, error) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	if _, ok := ae.rules[id]; !ok {
+		return false, nil
+	}
+
+	err := ae.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ae.rulesBucket).Delete([]byte(id))
+	})
+
+	if err != nil {
+		return true, err
+	}
+
+	if st := ae.states[id]; st.active != nil {
+		err = ae.clear(st, time.Now())
+	}
+
+	delete(ae.rules, id)
+	delete(ae.states, id)
+	return true, err
+}
+
+// Evaluate checks the rules against the given values, raising and clearing alerts
+func (ae *alertEngine) Evaluate(now time.Time, values map[string]float64) error {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	var lastErr error
+	for id, r := range ae.rules {
+		st := ae.states[id]
+
+		v, ok := values[r.Field]
+		if !ok {
+			continue
+		}
+
+		threshold, ok := r.threshold(values)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case st.active != nil:
+			if r.cleared(v, threshold, st) {
+				lastErr = ae.clear(st, now)
+			}
+
+		case r.met(v, threshold, st):
+			if st.since.IsZero() {
+				st.since = now
+			}
+
+			// unchanged is met from when the value last changed
+			if r.Op == OpUnchanged {
+				st.since = st.changed
+			}
+
+			if now.Sub(st.since) >= time.Duration(r.For)*time.Second {
+				lastErr = ae.raise(r, st, v)
+			}
+
+		default:
+			st.since = time.Time{}
+		}
+
+		if !st.seen || v != st.last {
+			st.changed = now
+		}
+
+		st.last = v
+		st.seen = true
+	}
+
+	return lastErr
+}
+
+func (ae *alertEngine) raise(r AlertRule, st *ruleState, v float64) error {
+	st.active = &Alert{
+		RuleID:   r.ID,
+		Name:     r.Name,
+		Severity: r.Severity,
+		Field:    r.Field,
+		Value:    v,
+		Start:    st.since,
+		Active:   true,
+	}
+
+	return ae.save(*st.active)
+}
+
+func (ae *alertEngine) clear(st *ruleState, now time.Time) error {
+	a := *st.active
+	a.Active = false
+	a.End = now
+	st.active = nil
+	st.since = time.Time{}
+	return ae.save(a)
+}
+
+func (ae *alertEngine) save(a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	err = ae.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ae.bucket).Put(a.key(), data)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// called in line so the alerts are handed on in the order they happen
+	ae.onAlertCB(a)
+	return nil
+}
+
+// Active returns the alerts that are currently active
+func (ae *alertEngine) Active() []Alert {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	alerts := []Alert{}
+	for _, st := range ae.states {
+		if st.active != nil {
+			alerts = append(alerts, *st.active)
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Start.Before(alerts[j].Start) })
+	return alerts
+}
+
+// History returns the alerts that started between from and to (inclusive)
+func (ae *alertEngine) History(from, to time.Time) ([]Alert, error) {
+	alerts := []Alert{}
+
+	err := ae.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(ae.bucket).Cursor()
+		end := timeKey(to.Add(time.Nanosecond))
+
+		for k, v := c.Seek(timeKey(from)); k != nil && string(k) < string(end); k, v = c.Next() {
+			a := Alert{}
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+
+			alerts = append(alerts, a)
+		}
+
+		return nil
+	})
+
+	return alerts, err
+}
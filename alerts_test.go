@@ -0,0 +1,182 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlertRuleValidate(t *testing.T) {
+	Convey("given some alert rules", t, func() {
+		Convey("a rule on a reading should be valid and get a default severity and name", func() {
+			r := AlertRule{Field: "runoff_ec", Op: OpAbove, Ref: "irrig_ec", Value: 1, For: 600}
+			So(r.Validate(), ShouldBeNil)
+			So(r.Severity, ShouldEqual, SeverityWarning)
+			So(r.Name, ShouldEqual, "runoff_ec > irrig_ec + 1 for 10m0s")
+		})
+
+		Convey("a rule on an unknown field should not be valid", func() {
+			r := AlertRule{Field: "nope", Op: OpAbove}
+			So(r.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("a rule with an unknown op should not be valid", func() {
+			r := AlertRule{Field: "irrig_ph", Op: "~"}
+			So(r.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("an outside rule with the wrong range should not be valid", func() {
+			r := AlertRule{Field: "irrig_ph", Op: OpOutside, Min: 6.5, Max: 5.5}
+			So(r.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("an outside rule with a hysteresis that could never clear should not be valid", func() {
+			r := AlertRule{Field: "irrig_ph", Op: OpOutside, Min: 5.5, Max: 6.5, Hysteresis: 0.5}
+			So(r.Validate(), ShouldNotBeNil)
+
+			r.Hysteresis = 0.4
+			So(r.Validate(), ShouldBeNil)
+		})
+	})
+}
+
+func TestAlertEngine(t *testing.T) {
+	Convey("given an alert engine", t, func() {
+		jdb := newTestDB(t)
+
+		ae, err := newAlertEngine(jdb.db)
+		So(err, ShouldBeNil)
+
+		start := time.Now()
+		at := func(mins int) time.Time { return start.Add(time.Duration(mins) * time.Minute) }
+
+		Convey("with a rule for runoff EC above irrig EC + 1 for 10 minutes with hysteresis", func() {
+			r, err := ae.SetRule(AlertRule{Field: "runoff_ec", Op: OpAbove, Ref: "irrig_ec", Value: 1, Hysteresis: 0.2, For: 600})
+			So(err, ShouldBeNil)
+			So(r.ID, ShouldNotBeEmpty)
+
+			Convey("when the condition is met for less than 10 minutes", func() {
+				So(ae.Evaluate(at(0), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+				So(ae.Evaluate(at(5), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+				So(ae.Evaluate(at(6), map[string]float64{"irrig_ec": 2, "runoff_ec": 2.5}), ShouldBeNil)
+				So(ae.Evaluate(at(12), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+
+				Convey("it should not raise an alert", func() {
+					So(ae.Active(), ShouldBeEmpty)
+				})
+			})
+
+			Convey("when the condition is met for 10 minutes", func() {
+				So(ae.Evaluate(at(0), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+				So(ae.Evaluate(at(10), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+
+				Convey("it should raise an alert from when the condition started", func() {
+					alerts := ae.Active()
+					So(alerts, ShouldHaveLength, 1)
+					So(alerts[0].RuleID, ShouldEqual, r.ID)
+					So(alerts[0].Value, ShouldEqual, 3.5)
+					So(alerts[0].Start.Equal(at(0)), ShouldBeTrue)
+				})
+
+				Convey("and the reading drops within the hysteresis", func() {
+					So(ae.Evaluate(at(11), map[string]float64{"irrig_ec": 2, "runoff_ec": 2.9}), ShouldBeNil)
+
+					Convey("the alert should still be active", func() {
+						So(ae.Active(), ShouldHaveLength, 1)
+					})
+				})
+
+				Convey("and the reading drops past the hysteresis", func() {
+					var seen []Alert
+					ae.OnAlert(func(a Alert) { seen = append(seen, a) })
+					So(ae.Evaluate(at(11), map[string]float64{"irrig_ec": 2, "runoff_ec": 2.7}), ShouldBeNil)
+
+					Convey("it should be handed on before an alert raised after it", func() {
+						So(ae.Evaluate(at(20), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+						So(ae.Evaluate(at(30), map[string]float64{"irrig_ec": 2, "runoff_ec": 3.5}), ShouldBeNil)
+						So(seen, ShouldHaveLength, 2)
+						So(seen[0].Active, ShouldBeFalse)
+						So(seen[1].Active, ShouldBeTrue)
+					})
+
+					Convey("the alert should be cleared and kept in the history", func() {
+						So(ae.Active(), ShouldBeEmpty)
+
+						history, err := ae.History(at(-1), at(20))
+						So(err, ShouldBeNil)
+						So(history, ShouldHaveLength, 1)
+						So(history[0].Active, ShouldBeFalse)
+						So(history[0].End.Equal(at(11)), ShouldBeTrue)
+					})
+				})
+
+				Convey("and the engine is restarted", func() {
+					ae, err = newAlertEngine(jdb.db)
+					So(err, ShouldBeNil)
+
+					Convey("it should still have the rule and the active alert", func() {
+						So(ae.Rules(), ShouldHaveLength, 1)
+						So(ae.Active(), ShouldHaveLength, 1)
+					})
+				})
+
+				Convey("and the rule is deleted", func() {
+					found, err := ae.DeleteRule(r.ID)
+					So(err, ShouldBeNil)
+					So(found, ShouldBeTrue)
+
+					Convey("the alert should be cleared", func() {
+						So(ae.Active(), ShouldBeEmpty)
+						So(ae.Rules(), ShouldBeEmpty)
+					})
+				})
+			})
+		})
+
+		Convey("with a rule for irrig pH outside 5.5-6.5", func() {
+			_, err := ae.SetRule(AlertRule{Field: "irrig_ph", Op: OpOutside, Min: 5.5, Max: 6.5, Hysteresis: 0.1})
+			So(err, ShouldBeNil)
+
+			Convey("when the pH goes outside the range it should raise an alert straight away", func() {
+				So(ae.Evaluate(at(0), map[string]float64{"irrig_ph": 6.8}), ShouldBeNil)
+				So(ae.Active(), ShouldHaveLength, 1)
+
+				Convey("and it should clear once the pH is back inside the hysteresis", func() {
+					So(ae.Evaluate(at(1), map[string]float64{"irrig_ph": 6.45}), ShouldBeNil)
+					So(ae.Active(), ShouldHaveLength, 1)
+					So(ae.Evaluate(at(2), map[string]float64{"irrig_ph": 6.3}), ShouldBeNil)
+					So(ae.Active(), ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("with a rule for no irrigation tips in 4 hours", func() {
+			_, err := ae.SetRule(AlertRule{Field: "irrig_tips", Op: OpUnchanged, For: 4 * 60 * 60})
+			So(err, ShouldBeNil)
+
+			Convey("when the tips don't change for 4 hours it should raise an alert", func() {
+				So(ae.Evaluate(at(0), map[string]float64{"irrig_tips": 10}), ShouldBeNil)
+				So(ae.Evaluate(at(60), map[string]float64{"irrig_tips": 10}), ShouldBeNil)
+				So(ae.Active(), ShouldBeEmpty)
+				So(ae.Evaluate(at(241), map[string]float64{"irrig_tips": 10}), ShouldBeNil)
+				So(ae.Active(), ShouldHaveLength, 1)
+
+				Convey("and it should clear on the next tip", func() {
+					So(ae.Evaluate(at(242), map[string]float64{"irrig_tips": 11}), ShouldBeNil)
+					So(ae.Active(), ShouldBeEmpty)
+				})
+
+				Convey("and the engine is restarted it should stay active until the next tip", func() {
+					ae, err = newAlertEngine(jdb.db)
+					So(err, ShouldBeNil)
+
+					So(ae.Evaluate(at(242), map[string]float64{"irrig_tips": 10}), ShouldBeNil)
+					So(ae.Active(), ShouldHaveLength, 1)
+					So(ae.Evaluate(at(243), map[string]float64{"irrig_tips": 11}), ShouldBeNil)
+					So(ae.Active(), ShouldBeEmpty)
+				})
+			})
+		})
+	})
+}
@@ -2,9 +2,11 @@ package openminder
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // AttachAPI will attach an api to the minder so it can setup
@@ -13,9 +15,33 @@ func (mdr *Minder) AttachAPI(api gin.IRouter) {
 	api.GET("/errors", mdr.errorsHandler())
 	api.GET("/calibrations", mdr.calibrationsHandler())
 	api.PUT("/calibrations/:field/:scale/:offset", mdr.calibrateHandler())
+	api.POST("/calibrations/:field", mdr.saveCalibrationHandler())
+	api.POST("/calibrations/:field/points", mdr.calibratePointsHandler())
+	api.GET("/calibrations/:field/history", mdr.calibrationHistoryHandler())
+	api.POST("/calibrations/:field/rollback/:id", mdr.rollbackCalibrationHandler())
+	api.GET("/calibration-sessions", mdr.calibrationSessionsHandler())
+	api.POST("/calibration-sessions", mdr.startCalibrationSessionHandler())
+	api.GET("/calibration-sessions/:id", mdr.calibrationSessionHandler())
+	api.POST("/calibration-sessions/:id/ready", mdr.readyCalibrationStepHandler())
+	api.POST("/calibration-sessions/:id/capture", mdr.captureCalibrationPointHandler())
+	api.POST("/calibration-sessions/:id/commit", mdr.commitCalibrationSessionHandler())
+	api.DELETE("/calibration-sessions/:id", mdr.cancelCalibrationSessionHandler())
 	api.GET("/config", mdr.configHandler())
+	api.GET("/export", mdr.exportHandler())
+	api.POST("/import", mdr.importHandler())
 	api.GET("/readings", mdr.readingsHandler())
+	api.GET("/readings/history", mdr.historyHandler())
+	api.GET("/readings/stream", mdr.readingsStreamHandler())
 	api.PUT("/readings/calibrate/:field/:scale/:offset", mdr.calibrateHandler())
+	api.GET("/alerts", mdr.alertsHandler())
+	api.GET("/alerts/rules", mdr.alertRulesHandler())
+	api.POST("/alerts/rules", mdr.setAlertRuleHandler())
+	api.PUT("/alerts/rules/:id", mdr.setAlertRuleHandler())
+	api.DELETE("/alerts/rules/:id", mdr.deleteAlertRuleHandler())
+	api.GET("/tips", mdr.tipsHandler())
+	api.GET("/irrigations", mdr.irrigationsHandler())
+	api.GET("/reports/daily", mdr.dailyReportHandler())
+	api.PUT("/counters/reset", mdr.countersResetHandler())
 	api.GET("/bus", mdr.busHandler())
 	api.PUT("/bus/scan", mdr.busScanHandler())
 	api.PUT("/bus/swap", mdr.busSwapHandler())
@@ -56,6 +82,151 @@ func (mdr *Minder) busHandler() func(*gin.Context) {
 			},
 			"last_scan_start": scanstart,
 			"last_scan_done":  scandone,
+			"stats":           mdr.bus.Stats(),
+		}
+
+		c.JSON(200, data)
+	}
+}
+
+func (mdr *Minder) countersResetHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		counters, err := mdr.ResetCounters()
+		if err != nil {
+			c.AbortWithStatusJSON(500, errmsg("failed to save the reset counters: "+err.Error()))
+			return
+		}
+
+		c.JSON(200, counters)
+	}
+}
+
+func (mdr *Minder) dailyReportHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if err != nil || days < 1 || days > maxReportDays {
+			c.AbortWithStatusJSON(400, errmsg("days must be from 1 to "+strconv.Itoa(maxReportDays)))
+			return
+		}
+
+		reports, err := mdr.DailyReport(days)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(200, reports)
+	}
+}
+
+func (mdr *Minder) irrigationsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		to, err := parseTime(c.Query("to"), time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("to must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		from, err := parseTime(c.Query("from"), to.Add(-7*24*time.Hour))
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("from must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		events, err := mdr.irrigations.Irrigations(from, to)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(200, events)
+	}
+}
+
+func (mdr *Minder) alertsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		to, err := parseTime(c.Query("to"), time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("to must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		from, err := parseTime(c.Query("from"), to.Add(-7*24*time.Hour))
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("from must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		history, err := mdr.alerts.History(from, to)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(200, map[string][]Alert{
+			"active":  mdr.alerts.Active(),
+			"history": history,
+		})
+	}
+}
+
+func (mdr *Minder) alertRulesHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		c.JSON(200, mdr.alerts.Rules())
+	}
+}
+
+func (mdr *Minder) setAlertRuleHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		rule := AlertRule{}
+		if err := c.ShouldBindWith(&rule, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid rule: "+err.Error()))
+			return
+		}
+
+		status := 201
+		if id := c.Param("id"); id != "" {
+			rule.ID = id
+			status = 200
+		}
+
+		rule, err := mdr.alerts.SetRule(rule)
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
+		c.JSON(status, rule)
+	}
+}
+
+func (mdr *Minder) deleteAlertRuleHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		found, err := mdr.alerts.DeleteRule(c.Param("id"))
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		if !found {
+			c.AbortWithStatusJSON(404, errmsg("no such rule"))
+			return
+		}
+
+		c.Status(204)
+	}
+}
+
+func (mdr *Minder) tipsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		data := map[string][]Closure{}
+
+		if mdr.irrigTB != nil {
+			data["irrig"] = mdr.irrigTB.Tips()
+		}
+
+		if mdr.runoffTB != nil {
+			data["runoff"] = mdr.runoffTB.Tips()
 		}
 
 		c.JSON(200, data)
@@ -72,7 +243,7 @@ func (mdr *Minder) calibrationsHandler() func(*gin.Context) {
 	return func(c *gin.Context) {
 		data := map[string]calibration{}
 		for _, f := range translatableFields {
-			c, _ := mdr.tr.getCalibration(f)
+			c, _ := mdr.tr.getCalibration(mdr.calibrationKey(f))
 			data[f] = c
 		}
 
@@ -86,12 +257,115 @@ func (mdr *Minder) configHandler() func(*gin.Context) {
 	}
 }
 
+func (mdr *Minder) exportHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		b, err := mdr.Export()
+		if err != nil {
+			c.AbortWithStatusJSON(500, errmsg("failed to export: "+err.Error()))
+			return
+		}
+
+		c.JSON(200, b)
+	}
+}
+
+func (mdr *Minder) importHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		b := Bundle{}
+		if err := c.ShouldBindWith(&b, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid bundle: "+err.Error()))
+			return
+		}
+
+		if err := b.Validate(); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid bundle: "+err.Error()))
+			return
+		}
+
+		res, err := mdr.Import(b, ImportOptions{
+			DryRun:   c.Query("dry_run") == "true",
+			Identity: c.Query("identity") == "true",
+		})
+		if err != nil {
+			c.AbortWithStatusJSON(500, errmsg("failed to import: "+err.Error()))
+			return
+		}
+
+		c.JSON(200, res)
+	}
+}
+
 func (mdr *Minder) readingsHandler() func(*gin.Context) {
 	return func(c *gin.Context) {
 		c.JSON(200, mdr.Readings)
 	}
 }
 
+func (mdr *Minder) historyHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		var fields []string
+		if f := c.Query("fields"); f != "" {
+			fields = strings.Split(f, ",")
+		}
+
+		if err := checkReadingFields(fields); err != nil {
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
+		to, err := parseTime(c.Query("to"), time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("to must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		from, err := parseTime(c.Query("from"), to.Add(-24*time.Hour))
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("from must be an RFC3339 time or unix timestamp"))
+			return
+		}
+
+		if from.After(to) {
+			c.AbortWithStatusJSON(400, errmsg("from must be before to"))
+			return
+		}
+
+		var step time.Duration
+		if s := c.Query("step"); s != "" {
+			if step, err = time.ParseDuration(s); err != nil || step < 0 {
+				c.AbortWithStatusJSON(400, errmsg("step must be a duration such as 5m or 1h"))
+				return
+			}
+		}
+
+		if agg := c.Query("agg"); agg != "" {
+			if step != 0 {
+				c.AbortWithStatusJSON(400, errmsg("step can't be used with agg"))
+				return
+			}
+
+			rollups, err := mdr.history.Rollups(agg, fields, from, to)
+			switch err {
+			case nil:
+				c.JSON(200, rollups)
+			case ErrUnknownAggregation:
+				c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			default:
+				c.AbortWithError(500, err)
+			}
+			return
+		}
+
+		samples, err := mdr.history.Query(fields, from, to, step)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(200, samples)
+	}
+}
+
 func (mdr *Minder) calibrateHandler() func(*gin.Context) {
 	return func(c *gin.Context) {
 		field := c.Param("field")
@@ -112,13 +386,18 @@ func (mdr *Minder) calibrateHandler() func(*gin.Context) {
 			return
 		}
 
-		err = mdr.tr.SetCalibration(field, scale, offset)
+		_, err = mdr.Calibrate(CalibrationRecord{Field: field, Scale: scale, Offset: offset, Note: c.Query("note")})
 
 		if err == ErrNotTranslatable {
 			c.AbortWithStatusJSON(400, errmsg("that field is not translatable"))
 			return
 		}
 
+		if _, ok := err.(InvalidCalibrationError); ok {
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
 		if err != nil {
 			c.AbortWithError(500, err)
 			return
@@ -128,6 +407,229 @@ func (mdr *Minder) calibrateHandler() func(*gin.Context) {
 	}
 }
 
+func (mdr *Minder) saveCalibrationHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		rec := CalibrationRecord{}
+		if err := c.ShouldBindWith(&rec, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid calibration: "+err.Error()))
+			return
+		}
+
+		rec.ID = 0
+		rec.Time = time.Time{}
+		rec.RollbackOf = 0
+		rec.Field = c.Param("field")
+
+		rec, err := mdr.Calibrate(rec)
+		if err == ErrNotTranslatable {
+			c.AbortWithStatusJSON(400, errmsg("that field is not translatable"))
+			return
+		}
+
+		if _, ok := err.(InvalidCalibrationError); ok {
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(201, rec)
+	}
+}
+
+func (mdr *Minder) calibratePointsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		req := struct {
+			Points []CalibrationPoint `json:"points"`
+			Source string             `json:"source"`
+			Note   string             `json:"note"`
+		}{}
+
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid points: "+err.Error()))
+			return
+		}
+
+		rec, err := calibrationFromPoints(c.Param("field"), req.Points)
+		switch err {
+		case nil:
+		case ErrNotTranslatable:
+			c.AbortWithStatusJSON(400, errmsg("that field is not translatable"))
+			return
+		default:
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
+		rec.Source = req.Source
+		rec.Note = req.Note
+
+		// let the calibration be checked before it is saved
+		if c.Query("dry_run") == "true" {
+			c.JSON(200, rec)
+			return
+		}
+
+		rec, err = mdr.Calibrate(rec)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(201, rec)
+	}
+}
+
+func (mdr *Minder) calibrationHistoryHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		recs, err := mdr.CalibrationHistory(c.Param("field"))
+		if err == ErrNotTranslatable {
+			c.AbortWithStatusJSON(400, errmsg("that field is not translatable"))
+			return
+		}
+
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		c.JSON(200, recs)
+	}
+}
+
+func (mdr *Minder) rollbackCalibrationHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg("id must be a number"))
+			return
+		}
+
+		rec, err := mdr.RollbackCalibration(c.Param("field"), id, c.Query("note"))
+		switch err {
+		case nil:
+			c.JSON(200, rec)
+		case ErrNotTranslatable:
+			c.AbortWithStatusJSON(400, errmsg("that field is not translatable"))
+		case ErrCalibrationNotFound:
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+		default:
+			c.AbortWithError(500, err)
+		}
+	}
+}
+
+func (mdr *Minder) calibrationSessionsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		c.JSON(200, mdr.sessions.Sessions())
+	}
+}
+
+func (mdr *Minder) startCalibrationSessionHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		req := struct {
+			Probe   string    `json:"probe"`
+			Side    string    `json:"side"`
+			Buffers []float64 `json:"buffers"`
+			Note    string    `json:"note"`
+		}{}
+
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.AbortWithStatusJSON(400, errmsg("invalid session: "+err.Error()))
+			return
+		}
+
+		s, err := mdr.sessions.Start(req.Probe, req.Side, req.Buffers, req.Note)
+		if err != nil {
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+			return
+		}
+
+		c.JSON(201, s)
+	}
+}
+
+func (mdr *Minder) calibrationSessionHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		s, err := mdr.sessions.Get(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+			return
+		}
+
+		c.JSON(200, s)
+	}
+}
+
+func (mdr *Minder) readyCalibrationStepHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		s, err := mdr.sessions.Ready(c.Param("id"))
+		switch err {
+		case nil:
+			c.JSON(200, s)
+		case ErrSessionNotFound:
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+		default:
+			c.AbortWithStatusJSON(409, errmsg(err.Error()))
+		}
+	}
+}
+
+func (mdr *Minder) captureCalibrationPointHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		s, err := mdr.sessions.Capture(c.Param("id"), c.Query("force") == "true")
+		switch err {
+		case nil:
+			c.JSON(200, s)
+		case ErrSessionNotFound:
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+		default:
+			c.AbortWithStatusJSON(409, errmsg(err.Error()))
+		}
+	}
+}
+
+func (mdr *Minder) commitCalibrationSessionHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		rec, err := mdr.CommitCalibrationSession(c.Param("id"), c.Query("source"))
+		switch err {
+		case nil:
+			c.JSON(201, rec)
+		case ErrSessionNotFound:
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+		default:
+			c.AbortWithStatusJSON(400, errmsg(err.Error()))
+		}
+	}
+}
+
+func (mdr *Minder) cancelCalibrationSessionHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		if err := mdr.sessions.Cancel(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(404, errmsg(err.Error()))
+			return
+		}
+
+		c.Status(204)
+	}
+}
+
+// parseTime parses an RFC3339 time or unix timestamp, returning the default if s is empty
+func parseTime(s string, dflt time.Time) (time.Time, error) {
+	if s == "" {
+		return dflt, nil
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
 func errmsg(msg string) interface{} {
 	return struct {
 		Msg string `json:"error"`
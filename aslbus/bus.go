@@ -5,6 +5,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
@@ -21,10 +22,21 @@ type Bus struct {
 	onProbesClearedCB func()
 	probes            []Probe
 	running           bool
+	stats             *busStats
+
+	// mu guards the probes, the packet callbacks and running, which the packets
+	// are handed to from their own goroutines
+	mu sync.Mutex
 }
 
 // New creates a new serial port master based on the config supplied
 func New(tty string) *Bus {
+	return NewWithOpener(tty, SerialOpener)
+}
+
+// NewWithOpener creates a new bus that uses the given opener to open the
+// master and slave ports instead of opening the serial port directly
+func NewWithOpener(tty string, open Opener) *Bus {
 	opts := serial.OpenOptions{
 		PortName:        tty,
 		BaudRate:        19200,
@@ -35,10 +47,13 @@ func New(tty string) *Bus {
 	}
 
 	bus := new(Bus)
+	bus.stats = newBusStats()
 	bus.master = NewMaster(opts)
+	bus.master.port.opener = open
 	rxChan := make(chan string)
 	bus.ReadingsChan = rxChan
 	bus.slave = NewSlave(opts, rxChan)
+	bus.slave.port.opener = open
 
 	// blank out all the callbacks
 	bus.onErrorCB = func(err error) {}
@@ -48,6 +63,13 @@ func New(tty string) *Bus {
 	return bus
 }
 
+// SetCapture will record the frames read and written by the bus to the given
+// capture, a nil capture stops the recording
+func (bus *Bus) SetCapture(c *Capture) {
+	bus.master.capture = c
+	bus.slave.capture = c
+}
+
 // Transmit - used to transmit data out on the bus
 func (bus *Bus) Transmit(addr, serial, cmd, data string) {
 	bus.master.TransmitPacket(addr, serial, cmd, data)
@@ -90,8 +112,8 @@ func (bus *Bus) Run() error {
 	go bus.slave.Listen()
 	go bus.master.Run()
 
-	bus.running = true
-	defer func() { bus.running = false }()
+	bus.setRunning(true)
+	defer bus.setRunning(false)
 	go bus.onConnectCB()
 
 	// Maintain open port
@@ -114,8 +136,21 @@ func (bus *Bus) Run() error {
 	}
 }
 
+func (bus *Bus) setRunning(running bool) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.running = running
+}
+
+func (bus *Bus) isRunning() bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return bus.running
+}
+
 func (bus *Bus) processPacket(newPkt string) error {
 	pkt, err := NewRxPkt(newPkt)
+	bus.stats.received(err)
 
 	if err != nil {
 		if strings.Contains(err.Error(), "from a master") { // ignore tx packets
@@ -136,8 +171,14 @@ func (bus *Bus) processPacket(newPkt string) error {
 
 func (bus *Bus) sendPacket(pkt *Packet) {
 	var sent = true
+	bus.stats.seen(pkt.serial, time.Unix(pkt.timestamp, 0))
 
-	for _, p := range bus.probes {
+	bus.mu.Lock()
+	probes := append([]Probe{}, bus.probes...)
+	cbs := append([]func(*Packet){}, bus.onPacketCBs...)
+	bus.mu.Unlock()
+
+	for _, p := range probes {
 		if p.SN() == pkt.serial {
 			err := p.Update(pkt)
 			if err != nil {
@@ -147,7 +188,7 @@ func (bus *Bus) sendPacket(pkt *Packet) {
 		}
 	}
 
-	for _, cb := range bus.onPacketCBs {
+	for _, cb := range cbs {
 		if cb != nil {
 			go cb(pkt)
 		}
@@ -160,11 +201,16 @@ func (bus *Bus) sendPacket(pkt *Packet) {
 
 // Probes returns the probes registered to the this bus
 func (bus *Bus) Probes() []Probe {
-	return bus.probes
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return append([]Probe{}, bus.probes...)
 }
 
 // Serials will return the serial numbers of all registered probes
 func (bus *Bus) Serials() []string {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
 	var sns []string
 	for _, p := range bus.probes {
 		sns = append(sns, p.SN())
@@ -175,16 +221,25 @@ func (bus *Bus) Serials() []string {
 
 // ClearProbes will clear all probes by calling their DetachBus method
 func (bus *Bus) ClearProbes() {
-	for _, p := range bus.probes {
+	// detaching unregisters the probe, so work from a copy of the probes
+	for _, p := range bus.Probes() {
 		p.DetachBus() // this stops the probe and unregisters it from the bus
 	}
 
+	bus.mu.Lock()
 	bus.probes = []Probe{}
+	bus.mu.Unlock()
 	bus.onProbesClearedCB()
 }
 
 // HasProbe will return true if the probe with the given serial has been registered
 func (bus *Bus) HasProbe(serial string) bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return bus.hasProbe(serial)
+}
+
+func (bus *Bus) hasProbe(serial string) bool {
 	var have bool
 	for _, p := range bus.probes {
 		if p.SN() == serial {
@@ -196,21 +251,27 @@ func (bus *Bus) HasProbe(serial string) bool {
 }
 
 func (bus *Bus) unregisterProbe(serial string) {
-	log.Println("unregisterProbe", serial, len(bus.probes))
-	for i, _p := range bus.probes {
-		log.Printf("i: %d/%d  p %v", i, len(bus.probes), _p)
-		if _p == nil || serial == _p.SN() {
-			log.Println("got him!", len(bus.probes))
-
-			bus.probes[i] = bus.probes[len(bus.probes)-1]
-			bus.probes[len(bus.probes)-1] = nil
-			bus.probes = bus.probes[:len(bus.probes)-1]
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	probes := []Probe{}
+	for _, p := range bus.probes {
+		if p == nil || serial == p.SN() {
+			log.Printf("unregistered probe %s", serial)
+			continue
 		}
+
+		probes = append(probes, p)
 	}
+
+	bus.probes = probes
 }
 
 func (bus *Bus) registerProbe(p Probe) {
-	if bus.HasProbe(p.SN()) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.hasProbe(p.SN()) {
 		return
 	}
 
@@ -236,11 +297,16 @@ func (bus *Bus) OnError(cb func(error)) {
 
 // OnPacket registers a func to call when a packet is received
 func (bus *Bus) OnPacket(cb func(*Packet)) int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
 	bus.onPacketCBs = append(bus.onPacketCBs, cb)
 	return len(bus.onPacketCBs) - 1
 }
 
 // UnregisterOnPacket will unregister an on packet callback by the given index
 func (bus *Bus) UnregisterOnPacket(i int) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
 	bus.onPacketCBs[i] = nil
 }
@@ -41,8 +41,56 @@ func TestBus(t *testing.T) {
 					bus.registerProbe(probe1)
 					So(len(bus.probes), ShouldEqual, 2)
 				})
+
+				Convey("when the probes are cleared", func() {
+					for _, p := range bus.probes {
+						p.(*ECProbe).bus = bus
+						p.(*ECProbe).quit = make(chan bool)
+					}
+
+					bus.ClearProbes()
+
+					Convey("they should all be removed from the bus", func() {
+						So(bus.probes, ShouldBeEmpty)
+					})
+				})
 			})
 
 		})
 	})
 }
+
+func TestBusStats(t *testing.T) {
+	Convey("given a new bus with a registered probe", t, func() {
+		bus := New("/dev/ttyUSB0")
+		bus.registerProbe(mockECProbe("ASL1805180001"))
+
+		Convey("when packets are processed", func() {
+			good := NewTxPkt(ecProbeAddress, "ASL1805180001", pingCommand, "").raw
+			bus.processPacket(good)
+			bus.processPacket(":xASL1805180001$001002344F3")
+			bus.processPacket(":xASL1805180001$00001")
+			bus.processPacket(":!ASL1805180001r001002341B7")
+			wait(50)
+
+			Convey("the stats should count them", func() {
+				stats := bus.Stats()
+				So(stats.RxPackets, ShouldEqual, 4)
+				So(stats.CRCFailures, ShouldEqual, 1)
+				So(stats.ParseErrors, ShouldEqual, 1)
+				So(stats.LastSeen, ShouldContainKey, "ASL1805180001")
+			})
+		})
+
+		Convey("when packets are transmitted", func() {
+			bus.Transmit(ecProbeAddress, "ASL1805180001", readingCommand, "")
+
+			Convey("the requests should be counted", func() {
+				stats := bus.Stats()
+				So(stats.TxRequests, ShouldEqual, 1)
+				So(stats.TxSent, ShouldEqual, 0)
+				So(stats.TxPackets, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,194 @@
+package aslbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// The directions of the frames in a capture
+const (
+	// CaptureRx is a frame read from the bus by the slave
+	CaptureRx = "rx"
+	// CaptureTx is a frame written to the bus by the master
+	CaptureTx = "tx"
+)
+
+// Frame is a raw frame captured from the bus
+type Frame struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Raw  string    `json:"raw"` // hex encoded bytes
+}
+
+// Bytes returns the raw bytes of the frame
+func (f Frame) Bytes() []byte {
+	data, _ := hex.DecodeString(f.Raw)
+	return data
+}
+
+// Capture records the raw frames seen on the bus as JSON lines, one per frame
+type Capture struct {
+	enc *json.Encoder
+	mu  *sync.Mutex
+	c   io.Closer
+}
+
+// NewCapture returns a capture that writes the frames to the given writer
+func NewCapture(w io.Writer) *Capture {
+	return &Capture{enc: json.NewEncoder(w), mu: new(sync.Mutex)}
+}
+
+// CreateCapture creates a capture that writes the frames to the given file
+func CreateCapture(fn string) (*Capture, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCapture(f)
+	c.c = f
+	return c, nil
+}
+
+// Record records the raw bytes seen on the bus in the given direction
+func (c *Capture) Record(dir string, raw []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(Frame{time.Now(), dir, hex.EncodeToString(raw)})
+}
+
+// Close closes the file the capture is written to
+func (c *Capture) Close() error {
+	if c == nil || c.c == nil {
+		return nil
+	}
+
+	return c.c.Close()
+}
+
+// ReadCapture reads the frames of a capture from the given reader
+func ReadCapture(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var f Frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return frames, fmt.Errorf("bad frame on line %d: %s", line, err)
+		}
+
+		if _, err := hex.DecodeString(f.Raw); err != nil {
+			return frames, fmt.Errorf("bad frame on line %d: %s", line, err)
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames, scanner.Err()
+}
+
+// LoadCapture reads the frames of a capture from the given file
+func LoadCapture(fn string) ([]Frame, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCapture(f)
+}
+
+// Replay plays back the frames received in a capture as if they were being
+// read from the bus.  Anything written to the bus during a replay is discarded.
+type Replay struct {
+	frames []Frame
+	once   *sync.Once
+	r      *io.PipeReader
+	w      *io.PipeWriter
+	onDone func()
+
+	// Speed is how many times faster than the capture to play back the frames
+	Speed float64
+}
+
+// NewReplay returns a new replay of the given frames
+func NewReplay(frames []Frame) *Replay {
+	r, w := io.Pipe()
+	return &Replay{
+		frames: frames,
+		once:   new(sync.Once),
+		r:      r,
+		w:      w,
+		onDone: func() {},
+		Speed:  1,
+	}
+}
+
+// OnDone registers a func to call when all the frames have been played back
+func (rp *Replay) OnDone(cb func()) {
+	rp.onDone = cb
+}
+
+// Opener returns an Opener that connects a bus to the replay, the playback
+// starts when the bus first opens a port
+func (rp *Replay) Opener() Opener {
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		rp.once.Do(func() { go rp.play() })
+		return &replayConn{rp}, nil
+	}
+}
+
+func (rp *Replay) play() {
+	var last time.Time
+	for _, f := range rp.frames {
+		if f.Dir != CaptureRx {
+			continue
+		}
+
+		if !last.IsZero() && rp.Speed > 0 {
+			time.Sleep(time.Duration(float64(f.Time.Sub(last)) / rp.Speed))
+		}
+		last = f.Time
+
+		rp.w.Write(f.Bytes())
+	}
+
+	rp.onDone()
+}
+
+// replayConn is a connection to a replay
+type replayConn struct {
+	rp *Replay
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.rp.r.Read(p)
+}
+
+// Write discards the bytes as there is nothing on the other end of a replay
+func (c *replayConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close does nothing as the replay is shared by the master and slave connections
+func (c *replayConn) Close() error {
+	return nil
+}
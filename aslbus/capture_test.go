@@ -0,0 +1,76 @@
+package aslbus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapture(t *testing.T) {
+	Convey("given a capture", t, func() {
+		buf := new(bytes.Buffer)
+		c := NewCapture(buf)
+
+		Convey("when frames are recorded", func() {
+			tx := NewTxPkt(ecProbeAddress, "ASL1805180000", readingCommand, "").Bytes()
+			rx := []byte(testPacket() + string(pktEOF))
+			So(c.Record(CaptureTx, tx), ShouldBeNil)
+			So(c.Record(CaptureRx, rx), ShouldBeNil)
+
+			Convey("they should be read back in order", func() {
+				frames, err := ReadCapture(buf)
+				So(err, ShouldBeNil)
+				So(len(frames), ShouldEqual, 2)
+				So(frames[0].Dir, ShouldEqual, CaptureTx)
+				So(frames[0].Bytes(), ShouldResemble, tx)
+				So(frames[1].Dir, ShouldEqual, CaptureRx)
+				So(frames[1].Bytes(), ShouldResemble, rx)
+				So(frames[1].Time, ShouldHappenOnOrAfter, frames[0].Time)
+			})
+		})
+
+		Convey("a bad frame should fail to be read", func() {
+			_, err := ReadCapture(bytes.NewBufferString(`{"dir":"rx","raw":"zz"}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("a nil capture should not record anything", t, func() {
+		var c *Capture
+		So(c.Record(CaptureRx, []byte("UU")), ShouldBeNil)
+	})
+}
+
+func TestReplay(t *testing.T) {
+	Convey("given a replay of a readings packet", t, func() {
+		now := time.Now()
+		frames := []Frame{
+			{now, CaptureTx, "00"},
+			{now, CaptureRx, hex.EncodeToString([]byte(testPacket() + string(pktEOF)))},
+		}
+
+		rp := NewReplay(frames)
+		done := make(chan bool, 1)
+		rp.OnDone(func() { done <- true })
+
+		Convey("when it is played into a bus with the probe registered", func() {
+			bus := NewWithOpener("replay", rp.Opener())
+			probe := NewECProbe("ASL1805180000")
+			bus.registerProbe(probe)
+			go bus.Run()
+			<-done
+
+			Convey("the probe should be updated", func() {
+				for i := 0; i < 30 && probe.EC == 0; i++ {
+					wait(100)
+				}
+
+				So(probe.EC, ShouldEqual, 2.77)
+				So(probe.Temp, ShouldEqual, 25.5)
+			})
+		})
+	})
+}
@@ -3,6 +3,7 @@ package aslbus
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -39,6 +40,10 @@ type ECProbe struct {
 	Temp            float64 `json:"temp"`
 	TempReal        float64 `json:"temp_real"`
 	FirmwareVersion string  `json:"firmware_version"`
+
+	// mu guards the readings, running and quit, as the packets update the
+	// probe from the bus while it is being read
+	mu sync.Mutex
 }
 
 // NewECProbe - returns a pointer for the device with the serial number specified
@@ -56,11 +61,15 @@ func (d *ECProbe) attachMaster(master *Master) *ECProbe {
 
 // GetTemp will return the current temperature of the probe
 func (d *ECProbe) GetTemp() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.Temp
 }
 
 // GetEC will return the current EC of the probe
 func (d *ECProbe) GetEC() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.EC
 }
 
@@ -68,7 +77,7 @@ func (d *ECProbe) GetEC() float64 {
 func (d *ECProbe) DetachBus() {
 	d.Stop()
 	for {
-		if !d.running {
+		if !d.isRunning() {
 			break
 		}
 
@@ -104,7 +113,9 @@ func (d *ECProbe) Update(pkt *Packet) error {
 		return nil // ignore packets not for this device
 	}
 
+	d.mu.Lock()
 	d.LastSeen = pkt.timestamp
+	d.mu.Unlock()
 
 	if pkt.cmd != readingCommand {
 		return nil
@@ -135,16 +146,35 @@ func (d *ECProbe) disablePings() {
 // Start will setup the quit chan and start the interrogation loop.  An error will be
 // returned if there were problems starting the loop
 func (d *ECProbe) Start() error {
+	d.mu.Lock()
 	d.quit = make(chan bool, 1)
+	d.mu.Unlock()
 	return d.interrogate(5, 1)
 }
 
 // Stop will close the quit chan triggering the interrogation loop to bail
 func (d *ECProbe) Stop() {
-	close(d.quit)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.quit != nil {
+		close(d.quit)
+	}
 	d.quit = nil
 }
 
+func (d *ECProbe) setRunning(running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = running
+}
+
+func (d *ECProbe) isRunning() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running
+}
+
 // SN returns the serial number of the device
 func (d *ECProbe) SN() string {
 	return d.Serial
@@ -166,8 +196,12 @@ func (d *ECProbe) interrogate(every, retryCnt int) error {
 	}
 
 	ticker := time.NewTicker(time.Duration(every) * time.Second)
-	d.running = true
-	defer func() { d.running = false }()
+	d.setRunning(true)
+	defer d.setRunning(false)
+
+	d.mu.Lock()
+	quit := d.quit
+	d.mu.Unlock()
 
 	for {
 		select {
@@ -176,7 +210,7 @@ func (d *ECProbe) interrogate(every, retryCnt int) error {
 				return err
 			}
 
-		case _, _ = <-d.quit:
+		case _, _ = <-quit:
 			return nil
 		}
 	}
@@ -185,6 +219,9 @@ func (d *ECProbe) interrogate(every, retryCnt int) error {
 // IsValid returns true if the probe has been seen in the
 // last 2 minutes
 func (d *ECProbe) IsValid() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if (time.Now().Unix() - d.LastSeen) > 120 {
 		return false
 	}
@@ -230,6 +267,9 @@ var packetPayloadFormat = []byteDef{
 func (d *ECProbe) process(data string) {
 	raw := d.convertData(data)
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	// Firmware Version
 	fw := raw["firmware_version_lo"] + (raw["firmware_version_hi"] << 8)
 	d.FirmwareVersion = fmt.Sprintf("V%.2f", float64(fw)/100)
@@ -247,6 +287,18 @@ func (d *ECProbe) process(data string) {
 	d.TempReal = float64(tempReal)
 }
 
+// EncodePayload lays out the given values as the payload of a readings packet, it
+// is the reverse of what a probe does when it processes a readings packet.  Any
+// values missing from the map will be zero in the payload.
+func EncodePayload(values map[string]int) string {
+	data := ""
+	for _, bytedef := range packetPayloadFormat {
+		mask := (1 << uint(4*bytedef.l)) - 1
+		data += fmt.Sprintf("%0*X", bytedef.l, values[bytedef.n]&mask)
+	}
+	return data
+}
+
 func (d *ECProbe) convertData(data string) map[string]int {
 	index := 0
 	out := make(map[string]int)
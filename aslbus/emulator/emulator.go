@@ -0,0 +1,205 @@
+// Package emulator emulates Autogrow Intelligent EC probes on an ASL bus so
+// that the bus can be used without any probes or RS-485 hardware
+package emulator
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/aslbus"
+	"github.com/jacobsa/go-serial/serial"
+)
+
+const (
+	probeAddress = "x"
+	frameSOF     = ":"
+	frameEOF     = '\x04'
+
+	// the size of the header up to and including the data count, and of the CRC
+	headerSize = 21
+	crcSize    = 4
+)
+
+// Bus is an emulated ASL bus that the emulated probes are attached to
+type Bus struct {
+	probes  []*Probe
+	mu      *sync.Mutex
+	outMu   *sync.Mutex
+	buf     []byte
+	out     io.Writer
+	verbose bool
+}
+
+// New returns a new emulated bus with the given probes attached
+func New(probes ...*Probe) *Bus {
+	return &Bus{
+		probes: probes,
+		mu:     new(sync.Mutex),
+		outMu:  new(sync.Mutex),
+		out:    ioutil.Discard,
+	}
+}
+
+// Verbose turns on logging of the frames seen on the bus
+func (b *Bus) Verbose(v bool) {
+	b.verbose = v
+}
+
+// Probes returns the probes attached to the bus
+func (b *Bus) Probes() []*Probe {
+	return b.probes
+}
+
+// Serve reads frames from the master on the given port and writes the probe
+// replies back to it until reading from the port fails
+func (b *Bus) Serve(rw io.ReadWriter) error {
+	b.setOut(rw)
+	buf := make([]byte, 256)
+	for {
+		n, err := rw.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		b.Write(buf[:n])
+	}
+}
+
+// Opener returns an aslbus.Opener that connects the master and slave of an
+// aslbus.Bus to this emulated bus in process
+func (b *Bus) Opener() aslbus.Opener {
+	r, w := io.Pipe()
+	b.setOut(w)
+
+	return func(serial.OpenOptions) (io.ReadWriteCloser, error) {
+		return &conn{b, r}, nil
+	}
+}
+
+// setOut sets where the replies are written, taking the lock the replies are
+// written under
+func (b *Bus) setOut(w io.Writer) {
+	b.outMu.Lock()
+	defer b.outMu.Unlock()
+	b.out = w
+}
+
+// Write takes bytes sent by the master and handles any complete frames
+func (b *Bus) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	for {
+		i := bytes.IndexByte(b.buf, frameEOF)
+		if i == -1 {
+			return len(p), nil
+		}
+
+		b.handle(string(b.buf[:i]))
+		b.buf = b.buf[i+1:]
+	}
+}
+
+// handle a frame from the master, frames with a bad CRC are ignored like a real probe would
+func (b *Bus) handle(frame string) {
+	i := strings.Index(frame, frameSOF)
+	if i == -1 {
+		return
+	}
+
+	frame = frame[i:]
+	if len(frame) < headerSize+crcSize {
+		return
+	}
+
+	body, crc := frame[:len(frame)-crcSize], frame[len(frame)-crcSize:]
+	if aslbus.CRC(body) != crc {
+		if b.verbose {
+			log.Printf("ignoring frame with bad CRC: %q", frame)
+		}
+		return
+	}
+
+	serial := frame[2:15]
+	cmd := frame[15:17]
+
+	if b.verbose {
+		log.Printf("master sent %s to %s", cmd, serial)
+	}
+
+	for _, p := range b.probes {
+		if !p.Matches(serial) || p.Offline() {
+			continue
+		}
+
+		switch cmd {
+		case "$0":
+			if p.PingsEnabled() {
+				b.reply(p, cmd, "")
+			}
+		case "F0":
+			p.setPings(true)
+		case "N0":
+			p.setPings(false)
+		case "r0":
+			b.reply(p, cmd, p.Payload())
+		}
+	}
+}
+
+func (b *Bus) reply(p *Probe, cmd, data string) {
+	frame := aslbus.NewTxPkt(probeAddress, p.Serial, cmd, data).Bytes()
+
+	if p.CorruptRate > 0 && rand.Float64() < p.CorruptRate {
+		corrupt(frame)
+	}
+
+	go func() {
+		time.Sleep(p.Delay)
+
+		b.outMu.Lock()
+		defer b.outMu.Unlock()
+
+		if b.verbose {
+			log.Printf("%s replied to %s", p.Serial, cmd)
+		}
+
+		b.out.Write(frame)
+	}()
+}
+
+// corrupt the CRC of the given frame
+func corrupt(frame []byte) {
+	i := len(frame) - 2 // last CRC character, before the EOF
+	if frame[i] == '0' {
+		frame[i] = '1'
+	} else {
+		frame[i] = '0'
+	}
+}
+
+// conn is an in process connection to the emulated bus
+type conn struct {
+	b *Bus
+	r *io.PipeReader
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.b.Write(p)
+}
+
+// Close does nothing as the bus is shared by the master and slave connections
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,144 @@
+package emulator
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/autogrow/openminder/aslbus"
+	"github.com/jacobsa/go-serial/serial"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func readings() (float64, float64) {
+	return 2.77, 25.5
+}
+
+func readFrame(r io.Reader) string {
+	buf := make([]byte, 256)
+	n, _ := r.Read(buf)
+	return strings.TrimLeft(string(buf[:n]), "U")
+}
+
+func TestProbe(t *testing.T) {
+	Convey("given an emulated probe", t, func() {
+		p := NewProbe("ASL1805180001", readings)
+
+		Convey("it should match its serial and wildcards", func() {
+			So(p.Matches("ASL1805180001"), ShouldBeTrue)
+			So(p.Matches("ASL!!!!!!!!!1"), ShouldBeTrue)
+			So(p.Matches("ASL!!!!!!!!01"), ShouldBeTrue)
+			So(p.Matches("ASL!!!!!!!!!2"), ShouldBeFalse)
+			So(p.Matches("ASL18051800"), ShouldBeFalse)
+		})
+
+		Convey("its payload should be read by an EC probe", func() {
+			pkt, err := aslbus.NewRxPkt(string(aslbus.NewTxPkt(probeAddress, p.Serial, "r0", p.Payload()).Bytes()))
+			So(err, ShouldBeNil)
+
+			ecp := aslbus.NewECProbe(p.Serial)
+			So(ecp.Update(pkt), ShouldBeNil)
+			So(ecp.EC, ShouldEqual, 2.77)
+			So(ecp.Temp, ShouldEqual, 25.5)
+			So(ecp.FirmwareVersion, ShouldEqual, "V2.59")
+		})
+	})
+}
+
+func TestBus(t *testing.T) {
+	Convey("given an emulated bus with a probe", t, func() {
+		p := NewProbe("ASL1805180001", readings)
+		eb := New(p)
+		conn, _ := eb.Opener()(serial.OpenOptions{})
+
+		Convey("it should reply to a wildcard ping", func() {
+			conn.Write(aslbus.NewTxPkt("!", "ASL!!!!!!!!!1", "$0", "").Bytes())
+			So(readFrame(conn), ShouldStartWith, ":xASL1805180001$0")
+		})
+
+		Convey("it should reply to a readings request with a valid packet", func() {
+			conn.Write(aslbus.NewTxPkt("x", "ASL1805180001", "r0", "").Bytes())
+			pkt, err := aslbus.NewRxPkt(readFrame(conn))
+			So(err, ShouldBeNil)
+			So(aslbus.NewECProbe(p.Serial).Update(pkt), ShouldBeNil)
+		})
+
+		Convey("it should stop replying to pings when they are disabled", func() {
+			conn.Write(aslbus.NewTxPkt("!", "ASL1805180001", "N0", "").Bytes())
+			So(p.PingsEnabled(), ShouldBeFalse)
+
+			Convey("and reply again when they are enabled", func() {
+				conn.Write(aslbus.NewTxPkt("!", "ASL!!!!!!!!!!", "F0", "").Bytes())
+				So(p.PingsEnabled(), ShouldBeTrue)
+			})
+		})
+
+		Convey("it should ignore frames with a bad CRC", func() {
+			frame := aslbus.NewTxPkt("!", "ASL1805180001", "N0", "").Bytes()
+			corrupt(frame)
+			conn.Write(frame)
+			So(p.PingsEnabled(), ShouldBeTrue)
+		})
+
+		Convey("when the probe sends corrupt frames", func() {
+			p.CorruptRate = 1
+
+			Convey("the reply should fail the CRC check", func() {
+				conn.Write(aslbus.NewTxPkt("x", "ASL1805180001", "r0", "").Bytes())
+				_, err := aslbus.NewRxPkt(readFrame(conn))
+				So(err.Error(), ShouldContainSubstring, "CRC Failed")
+			})
+		})
+
+		Convey("when the probe replies late", func() {
+			p.Delay = 100 * time.Millisecond
+
+			Convey("the reply should be delayed", func() {
+				start := time.Now()
+				conn.Write(aslbus.NewTxPkt("!", "ASL1805180001", "$0", "").Bytes())
+				readFrame(conn)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, p.Delay)
+			})
+		})
+	})
+}
+
+func TestScan(t *testing.T) {
+	Convey("given an ASL bus connected to two emulated probes", t, func() {
+		eb := New(NewProbe("ASL0000000001", readings), NewProbe("ASL0000000002", readings))
+		bus := aslbus.NewWithOpener("emulated", eb.Opener())
+
+		type result struct {
+			sns []string
+			err error
+		}
+
+		done := make(chan result)
+		bus.OnConnect(func() {
+			sns, _, err := aslbus.Scan(bus, 2, 30)
+			done <- result{sns, err}
+		})
+
+		go bus.Run()
+
+		Convey("a scan should find both probes", func() {
+			res := <-done
+			So(res.err, ShouldBeNil)
+			So(res.sns, ShouldContain, "ASL0000000001")
+			So(res.sns, ShouldContain, "ASL0000000002")
+
+			Convey("and an attached probe should get readings", func() {
+				probe := aslbus.NewECProbe("ASL0000000001").AttachBus(bus)
+				defer probe.Stop()
+
+				for i := 0; i < 100 && !probe.IsValid(); i++ {
+					time.Sleep(100 * time.Millisecond)
+				}
+
+				So(probe.IsValid(), ShouldBeTrue)
+				So(probe.GetEC(), ShouldEqual, 2.77)
+			})
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package emulator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/aslbus"
+)
+
+const (
+	wildcard = '!'
+	firmware = 259
+)
+
+// Probe is an emulated Autogrow Intelligent EC probe
+type Probe struct {
+	// Serial is the serial number of the probe
+	Serial string
+
+	// Readings returns the EC and temperature that the probe should report
+	Readings func() (ec, temp float64)
+
+	// Delay is how long the probe waits before replying to the master
+	Delay time.Duration
+
+	// CorruptRate is the fraction (0 to 1) of replies that are sent with a bad CRC
+	CorruptRate float64
+
+	mu      *sync.Mutex
+	pings   bool
+	offline bool
+}
+
+// NewProbe returns a new emulated probe with pings enabled
+func NewProbe(serial string, readings func() (float64, float64)) *Probe {
+	return &Probe{
+		Serial:   serial,
+		Readings: readings,
+		mu:       new(sync.Mutex),
+		pings:    true,
+	}
+}
+
+// SetOffline makes the probe stop (or start) replying, as if it dropped off the bus
+func (p *Probe) SetOffline(offline bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offline = offline
+}
+
+// Offline returns true if the probe has dropped off the bus
+func (p *Probe) Offline() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offline
+}
+
+// PingsEnabled returns true if the probe will reply to pings
+func (p *Probe) PingsEnabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pings
+}
+
+func (p *Probe) setPings(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pings = enabled
+}
+
+// Matches returns true if the given serial, which may contain wildcards, is
+// addressed to this probe
+func (p *Probe) Matches(serial string) bool {
+	if len(serial) != len(p.Serial) {
+		return false
+	}
+
+	for i := range serial {
+		if serial[i] != wildcard && serial[i] != p.Serial[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Payload returns the readings payload that the probe sends in reply to a
+// readings request
+func (p *Probe) Payload() string {
+	ec, temp := p.Readings()
+	ecx100 := int(math.Round(ec * 100))
+	tempx100 := int(math.Round(temp * 100))
+	ecReal := int(math.Round(ec * 1000))
+	tempReal := int(math.Round(temp * 1000))
+
+	return aslbus.EncodePayload(map[string]int{
+		"firmware_version_lo": firmware & 0xff,
+		"firmware_version_hi": firmware >> 8,
+		"ec_lo":               ecx100 & 0xff,
+		"ec_hi":               ecx100 >> 8,
+		"temp_lo":             tempx100 & 0xff,
+		"temp_hi":             tempx100 >> 8,
+		"ec_real_0":           ecReal & 0xff,
+		"ec_real_1":           (ecReal >> 8) & 0xff,
+		"ec_real_2":           (ecReal >> 16) & 0xff,
+		"ec_real_3":           (ecReal >> 24) & 0xff,
+		"temp_real_0":         tempReal & 0xff,
+		"temp_real_1":         (tempReal >> 8) & 0xff,
+		"temp_real_2":         (tempReal >> 16) & 0xff,
+		"temp_real_3":         (tempReal >> 24) & 0xff,
+		"sig_pc":              100,
+	})
+}
@@ -0,0 +1,85 @@
+package emulator
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// PTY is a pseudo-terminal that the emulated bus can be served on.  The bus
+// should be opened on the slave side given by Name.
+type PTY struct {
+	master *os.File
+	slave  *os.File
+	Name   string
+}
+
+// OpenPTY opens a new pseudo-terminal.  The slave side is kept open so that
+// reading from the master does not fail when the bus closes its port.
+func OpenPTY() (*PTY, error) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlock int32
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, m.Fd(), unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		m.Close()
+		return nil, fmt.Errorf("failed to unlock pty: %s", errno)
+	}
+
+	n, err := unix.IoctlGetInt(int(m.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("failed to get pty number: %s", err)
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	s, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	if err := makeRaw(int(s.Fd())); err != nil {
+		m.Close()
+		s.Close()
+		return nil, err
+	}
+
+	return &PTY{m, s, name}, nil
+}
+
+// makeRaw turns off the line discipline so the frames are passed through untouched
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+// Read reads what the master of the ASL bus wrote to the slave side
+func (pty *PTY) Read(p []byte) (int, error) {
+	return pty.master.Read(p)
+}
+
+// Write writes to the slave side so the ASL bus can read it
+func (pty *PTY) Write(p []byte) (int, error) {
+	return pty.master.Write(p)
+}
+
+// Close closes both sides of the pseudo-terminal
+func (pty *PTY) Close() error {
+	pty.slave.Close()
+	return pty.master.Close()
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package emulator
+
+import "fmt"
+
+// PTY is a pseudo-terminal that the emulated bus can be served on
+type PTY struct {
+	Name string
+}
+
+// OpenPTY is only supported on linux
+func OpenPTY() (*PTY, error) {
+	return nil, fmt.Errorf("pty emulation is only supported on linux")
+}
+
+// Read is not supported
+func (pty *PTY) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("pty emulation is only supported on linux")
+}
+
+// Write is not supported
+func (pty *PTY) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("pty emulation is only supported on linux")
+}
+
+// Close does nothing
+func (pty *PTY) Close() error {
+	return nil
+}
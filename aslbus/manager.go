@@ -16,13 +16,20 @@ type Manager struct {
 	LastScanDone  time.Time
 	scanTimeout   int
 	scanner       *Scanner
+	onScanStartCB func()
+	onDetectCB    func(string)
 }
 
 // NewManager creates a new ASL Bus manager that handles bus scanning and
 // provides readings
 func NewManager(tty string, scanTimeout int, cfgSerials ...string) *Manager {
-	mgr := &Manager{}
-	mgr.bus = New(tty)
+	return NewBusManager(New(tty), scanTimeout, cfgSerials...)
+}
+
+// NewBusManager creates a new ASL Bus manager for the given bus
+func NewBusManager(bus *Bus, scanTimeout int, cfgSerials ...string) *Manager {
+	mgr := &Manager{onScanStartCB: func() {}, onDetectCB: func(string) {}}
+	mgr.bus = bus
 	mgr.scanner = NewScanner(mgr.bus, 2, scanTimeout)
 
 	// don't use empty strings for serials
@@ -38,6 +45,7 @@ func NewManager(tty string, scanTimeout int, cfgSerials ...string) *Manager {
 	// watch for probe detections
 	mgr.scanner.OnDetect(func(serial string) {
 		log.Printf("detected new probe: %s", serial)
+		mgr.onDetectCB(serial)
 	})
 
 	// when the bus is connected, create known probes
@@ -68,12 +76,22 @@ func (mgr *Manager) OnScanDone(cb func([]string, error)) {
 	mgr.scanner.OnScanDone(cb)
 }
 
+// OnScanStart registers a func to call when a bus scan is started
+func (mgr *Manager) OnScanStart(cb func()) {
+	mgr.onScanStartCB = cb
+}
+
+// OnDetect registers a func to call when a scan detects a probe
+func (mgr *Manager) OnDetect(cb func(string)) {
+	mgr.onDetectCB = cb
+}
+
 // ProbeReadings returns the readings for the probes
 func (mgr *Manager) ProbeReadings(sn string) (*types.NullFloat, *types.NullFloat) {
 	ec := &types.NullFloat{}
 	temp := &types.NullFloat{}
 
-	for _, p := range mgr.bus.probes {
+	for _, p := range mgr.bus.Probes() {
 		if p.SN() == sn && p.IsValid() {
 			temp.SetValue(p.GetTemp())
 			ec.SetValue(p.GetEC())
@@ -83,6 +101,11 @@ func (mgr *Manager) ProbeReadings(sn string) (*types.NullFloat, *types.NullFloat
 	return ec, temp
 }
 
+// Stats returns the health counters of the bus
+func (mgr *Manager) Stats() Stats {
+	return mgr.bus.Stats()
+}
+
 // Run starts the bus loop
 func (mgr *Manager) Run() {
 	mgr.bus.Run()
@@ -97,6 +120,7 @@ func (mgr *Manager) OnError(cb func(error)) {
 func (mgr *Manager) Scan() []string {
 	mgr.LastScanStart = time.Now()
 	log.Println("starting a bus scan")
+	mgr.onScanStartCB()
 	serials, scanned, err := mgr.scanner.Scan()
 	mgr.LastScanDone = time.Now()
 
@@ -2,6 +2,7 @@ package aslbus
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
@@ -9,31 +10,39 @@ import (
 
 // Master - object struct for an ASL bus master
 type Master struct {
-	running    bool
+	running    int32 // accessed atomically, 1 while the transmit loop runs
 	port       *SerialPort
 	TxChannel  chan *Packet
 	quit       chan bool
 	pingActive bool
-	txRequests int
-	txSent     int
+	txRequests int64
+	txSent     int64
+	txPackets  int64
+	capture    *Capture
 }
 
 // NewMaster - returns a new asl bus master object
 func NewMaster(options serial.OpenOptions) *Master {
 	return &Master{
-		false,
+		0,
 		NewPort(options),
 		make(chan *Packet),
 		make(chan bool),
 		false,
 		0,
 		0,
+		0,
+		nil,
 	}
 }
 
 // Quit - closes the current slave
 func (m *Master) Quit() {
-	m.running = false
+	atomic.StoreInt32(&m.running, 0)
+}
+
+func (m *Master) isRunning() bool {
+	return atomic.LoadInt32(&m.running) == 1
 }
 
 // Stop - stops the master transmit queue, run will need to be called again to start it
@@ -47,11 +56,11 @@ func (m *Master) stop() {
 func (m *Master) TransmitPacket(address, serial, command, payload string) {
 	tx := NewTxPkt(address, serial, command, payload)
 	// fmt.Println("Transmit this: ", serial, " : ", address, " : ", command, " : ", payload)
-	m.txRequests++
-	if m.running {
+	atomic.AddInt64(&m.txRequests, 1)
+	if m.isRunning() {
 		go func() {
 			m.TxChannel <- tx
-			m.txSent++
+			atomic.AddInt64(&m.txSent, 1)
 		}()
 	}
 }
@@ -60,13 +69,13 @@ func (m *Master) TransmitPacket(address, serial, command, payload string) {
 func (m *Master) Run() {
 	// Maintain open port
 	txTicker := time.NewTicker(1 * time.Second)
-	m.running = true
+	atomic.StoreInt32(&m.running, 1)
 
 	defer m.stop()
 	txBuffer := NewFIFO(100)
 
-	m.txRequests = 0
-	m.txSent = 0
+	atomic.StoreInt64(&m.txRequests, 0)
+	atomic.StoreInt64(&m.txSent, 0)
 
 	for {
 		select {
@@ -82,7 +91,7 @@ func (m *Master) Run() {
 				txPkt := txBuffer.Pop().(*Packet)
 				m.transmit(txPkt)
 			}
-			if !m.running {
+			if !m.isRunning() {
 				return
 			}
 		}
@@ -105,5 +114,8 @@ func (m *Master) transmit(packet *Packet) error {
 		m.port.Close()
 		return fmt.Errorf("No bytes written to port")
 	}
+
+	atomic.AddInt64(&m.txPackets, 1)
+	m.capture.Record(CaptureTx, packet.Bytes())
 	return nil
 }
@@ -22,21 +22,21 @@ func TestMaster(t *testing.T) {
 		master := NewMaster(opts)
 
 		So(master, ShouldNotBeNil)
-		So(master.running, ShouldNotBeNil)
+		So(master.isRunning(), ShouldBeFalse)
 		So(master.port.Options.PortName, ShouldEqual, "/dev/ttyUSB0")
 		Convey("test starting and stopping the port", func() {
 			go master.Run()
 			time.Sleep(time.Second)
-			So(master.running, ShouldBeTrue)
-			master.TransmitPacket(string(0xff), "ASL1805180000", "$0", "")
+			So(master.isRunning(), ShouldBeTrue)
+			master.TransmitPacket(string(rune(0xff)), "ASL1805180000", "$0", "")
 			time.Sleep(time.Second)
 			master.Quit()
 		})
 		Convey("test recovering from closing channel", func() {
 			go master.Run()
 			time.Sleep(time.Second)
-			So(master.running, ShouldBeTrue)
-			master.TransmitPacket(string(0xff), "ASL1805180000", "$0", "")
+			So(master.isRunning(), ShouldBeTrue)
+			master.TransmitPacket(string(rune(0xff)), "ASL1805180000", "$0", "")
 			time.Sleep(time.Second)
 			close(master.TxChannel)
 			time.Sleep(time.Second)
@@ -53,7 +53,7 @@ func TestMaster(t *testing.T) {
 				MinimumReadSize: 9,
 			}
 			master2 := NewMaster(opts)
-			pkt := NewTxPkt(string(0xff), "ASL1805180001", "$0", "")
+			pkt := NewTxPkt(string(rune(0xff)), "ASL1805180001", "$0", "")
 			err := master2.transmit(pkt)
 			So(err, ShouldNotBeNil)
 			pkt.raw = ""
@@ -62,6 +62,17 @@ var (
 	ErrInvalidChar = fmt.Errorf("packet serial contains ! this is only issued by masters")
 )
 
+// CRCError is returned when the CRC received with a packet doesn't match the
+// CRC calculated from its contents
+type CRCError struct {
+	Rx   uint16
+	Calc uint16
+}
+
+func (e CRCError) Error() string {
+	return fmt.Sprintf("Packet CRC Failed - Rx: 0x%04X, Calc: 0x%04X", e.Rx, e.Calc)
+}
+
 // byteDef a slice of data must represent a reading in byte, with name n and lenght l
 type byteDef struct {
 	n string
@@ -138,6 +149,11 @@ func calculateCRC(pkt string) (CRC string) {
 	return actCRC
 }
 
+// CRC returns the CRC of the given packet string as it appears at the end of a packet
+func CRC(pkt string) string {
+	return calculateCRC(pkt)
+}
+
 // Packet - object containing the information rx/tx inside an ASL packet
 type Packet struct {
 	timestamp int64
@@ -256,7 +272,7 @@ func (p *Packet) parse() error {
 	calcCRCval := uint16(Hextobin(calcCRC))
 
 	if calcCRCval != rxCRCval {
-		return fmt.Errorf("Packet CRC Failed - Rx: 0x%04X, Calc: 0x%04X", rxCRCval, calcCRCval)
+		return CRCError{rxCRCval, calcCRCval}
 	}
 
 	if (cmd == readingCommand) && (dataLength == 0) {
@@ -11,11 +11,21 @@ const (
 	portOpen   = 1
 )
 
+// Opener is a func that opens the underlying port for the given options, it
+// allows something other than a real serial port to be used for the bus
+type Opener func(serial.OpenOptions) (io.ReadWriteCloser, error)
+
+// SerialOpener opens a real serial port using the jacobsa go-serial package
+func SerialOpener(options serial.OpenOptions) (io.ReadWriteCloser, error) {
+	return serial.Open(options)
+}
+
 // SerialPort wrapper for the jacobsa go-serial so the port can handle open and close better
 type SerialPort struct {
 	Options serial.OpenOptions
 	Port    io.ReadWriteCloser
 	State   int
+	opener  Opener
 }
 
 // NewPort returns a serial port with the parameters specified
@@ -23,6 +33,7 @@ func NewPort(options serial.OpenOptions) *SerialPort {
 	port := &SerialPort{
 		Options: options,
 		State:   portClosed,
+		opener:  SerialOpener,
 	}
 
 	return port
@@ -31,7 +42,7 @@ func NewPort(options serial.OpenOptions) *SerialPort {
 // Open - issued to open a serial port
 func (s *SerialPort) Open() error {
 	var err error
-	s.Port, err = serial.Open(s.Options)
+	s.Port, err = s.opener(s.Options)
 	if err != nil {
 		s.State = portClosed
 		return err
@@ -17,6 +17,10 @@ type Scanner struct {
 	scan    chan string
 	lmscan  chan bool
 
+	// mu guards the serials and done, as the packets are listened for in their
+	// own goroutines
+	mu sync.Mutex
+
 	onScanDone func([]string, error)
 	onDetectCB func(string)
 }
@@ -54,9 +58,30 @@ func (scnr *Scanner) OnDetect(cb func(string)) {
 }
 
 func (scnr *Scanner) allFound() bool {
+	scnr.mu.Lock()
+	defer scnr.mu.Unlock()
 	return len(scnr.serials) >= scnr.count
 }
 
+// found returns a copy of the serials found so far
+func (scnr *Scanner) found() []string {
+	scnr.mu.Lock()
+	defer scnr.mu.Unlock()
+	return append([]string{}, scnr.serials...)
+}
+
+func (scnr *Scanner) setDone(done bool) {
+	scnr.mu.Lock()
+	defer scnr.mu.Unlock()
+	scnr.done = done
+}
+
+func (scnr *Scanner) isDone() bool {
+	scnr.mu.Lock()
+	defer scnr.mu.Unlock()
+	return scnr.done
+}
+
 func (scnr *Scanner) lastManScanner() {
 	for {
 		if scnr.lastManStanding() {
@@ -68,19 +93,21 @@ func (scnr *Scanner) lastManScanner() {
 }
 
 func (scnr *Scanner) lastManStanding() bool {
+	scnr.mu.Lock()
+	defer scnr.mu.Unlock()
 	return scnr.count-len(scnr.serials) == 1
 }
 
 func (scnr *Scanner) feedSerialNumbers() {
 	for maskSize := 1; maskSize <= 5; maskSize++ { // start with lowest mask
-		if scnr.done || scnr.lastManStanding() {
+		if scnr.isDone() || scnr.lastManStanding() {
 			return
 		}
 
 		// get the possible serial numbers for the mask
 		wildcardSNs := wildcardSerials(maskSize)
 		for _, sn := range wildcardSNs {
-			if scnr.done || scnr.lastManStanding() {
+			if scnr.isDone() || scnr.lastManStanding() {
 				return
 			}
 
@@ -93,7 +120,7 @@ func (scnr *Scanner) feedSerialNumbers() {
 func (scnr *Scanner) periodicBroadcaster() {
 	for {
 		time.Sleep(10 * time.Second)
-		if scnr.done || scnr.lastManStanding() {
+		if scnr.isDone() || scnr.lastManStanding() {
 			return
 		}
 
@@ -103,15 +130,19 @@ func (scnr *Scanner) periodicBroadcaster() {
 }
 
 func (scnr *Scanner) packetListener(pkt *Packet) {
+	scnr.mu.Lock()
+
 	// if we know about this serial, ignore the packet
 	for _, sn := range scnr.serials {
 		if sn == pkt.serial {
+			scnr.mu.Unlock()
 			return
 		}
 	}
 
 	// add the serial to the list and tell any listeners it was detected
 	scnr.serials = append(scnr.serials, pkt.serial)
+	scnr.mu.Unlock()
 	scnr.onDetectCB(pkt.serial)
 
 	// turn off pings for this serial now that we found it
@@ -122,22 +153,22 @@ func (scnr *Scanner) packetListener(pkt *Packet) {
 // detection will run until all probes are found or the given timeout (in seconds)
 // is reached.
 func (scnr *Scanner) Scan() ([]string, int, error) {
-	scnr.done = false
-	defer func() { scnr.done = true }()
+	scnr.setDone(false)
+	defer scnr.setDone(true)
 
 	once := new(sync.Once)
 
 	scanned := 0
-	if !scnr.bus.running {
+	if !scnr.bus.isRunning() {
 		err := fmt.Errorf("bus is not running")
-		scnr.onScanDone(scnr.serials, err)
-		return scnr.serials, scanned, err
+		scnr.onScanDone(scnr.found(), err)
+		return scnr.found(), scanned, err
 	}
 
 	// if we already have all the devices we need, bail out
 	if scnr.allFound() {
-		scnr.onScanDone(scnr.serials, nil)
-		return scnr.serials, scanned, nil
+		scnr.onScanDone(scnr.found(), nil)
+		return scnr.found(), scanned, nil
 	}
 
 	timer := time.NewTimer(time.Duration(scnr.timeout) * time.Second)
@@ -161,8 +192,8 @@ func (scnr *Scanner) Scan() ([]string, int, error) {
 		select {
 		case <-timer.C:
 			err := fmt.Errorf("probe detection timed out")
-			scnr.onScanDone(scnr.serials, err)
-			return scnr.serials, scanned, err
+			scnr.onScanDone(scnr.found(), err)
+			return scnr.found(), scanned, err
 
 		// if there's one probe left to detect, ping anyone left who still has pings enabled
 		case <-scnr.lmscan:
@@ -177,8 +208,8 @@ func (scnr *Scanner) Scan() ([]string, int, error) {
 
 		default:
 			if scnr.allFound() { // if we found all the probes get out of here
-				scnr.onScanDone(scnr.serials, nil)
-				return scnr.serials, scanned, nil
+				scnr.onScanDone(scnr.found(), nil)
+				return scnr.found(), scanned, nil
 			}
 
 		}
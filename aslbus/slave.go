@@ -12,11 +12,12 @@ type Slave struct {
 	running bool
 	rxChan  chan string
 	port    *SerialPort
+	capture *Capture
 }
 
 // NewSlave creates a new serial port slave based on the supplied config
 func NewSlave(options serial.OpenOptions, rxChan chan string) *Slave {
-	return &Slave{false, rxChan, NewPort(options)}
+	return &Slave{false, rxChan, NewPort(options), nil}
 }
 
 // Running - returns a true if the slave is running its listen loop
@@ -82,6 +83,8 @@ func (s *Slave) Listen() {
 				break
 			}
 
+			s.capture.Record(CaptureRx, []byte(reply))
+
 			go func() {
 				s.rxChan <- reply
 			}()
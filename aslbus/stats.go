@@ -0,0 +1,74 @@
+package aslbus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters that describe the health of the bus
+type Stats struct {
+	RxPackets   int64                `json:"rx_packets"`
+	TxPackets   int64                `json:"tx_packets"`
+	CRCFailures int64                `json:"crc_failures"`
+	ParseErrors int64                `json:"parse_errors"`
+	TxRequests  int64                `json:"tx_requests"`
+	TxSent      int64                `json:"tx_sent"`
+	LastSeen    map[string]time.Time `json:"last_seen"`
+}
+
+// busStats counts the packets received by the bus
+type busStats struct {
+	rxPackets   int64
+	crcFailures int64
+	parseErrors int64
+	lastSeen    map[string]time.Time
+	mu          sync.Mutex
+}
+
+func newBusStats() *busStats {
+	return &busStats{lastSeen: map[string]time.Time{}}
+}
+
+// received counts a packet read from the bus, and whether it failed to parse
+func (s *busStats) received(err error) {
+	atomic.AddInt64(&s.rxPackets, 1)
+
+	switch err.(type) {
+	case nil:
+	case CRCError:
+		atomic.AddInt64(&s.crcFailures, 1)
+	default:
+		if err != ErrMasterPacket {
+			atomic.AddInt64(&s.parseErrors, 1)
+		}
+	}
+}
+
+// seen records the time a packet was received from the given probe
+func (s *busStats) seen(serial string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSeen[serial] = t
+}
+
+// Stats returns a snapshot of the bus health counters
+func (bus *Bus) Stats() Stats {
+	stats := Stats{
+		RxPackets:   atomic.LoadInt64(&bus.stats.rxPackets),
+		CRCFailures: atomic.LoadInt64(&bus.stats.crcFailures),
+		ParseErrors: atomic.LoadInt64(&bus.stats.parseErrors),
+		TxPackets:   atomic.LoadInt64(&bus.master.txPackets),
+		TxRequests:  atomic.LoadInt64(&bus.master.txRequests),
+		TxSent:      atomic.LoadInt64(&bus.master.txSent),
+		LastSeen:    map[string]time.Time{},
+	}
+
+	bus.stats.mu.Lock()
+	defer bus.stats.mu.Unlock()
+	for sn, t := range bus.stats.lastSeen {
+		stats.LastSeen[sn] = t
+	}
+
+	return stats
+}
@@ -0,0 +1,480 @@
+package openminder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BundleVersion is the version of the bundles made by Export, bundles from a
+// later version can't be imported
+const BundleVersion = 1
+
+// The sections of the setup that a bundle change can be in
+const (
+	BundleSectionConfig             = "config"
+	BundleSectionCalibration        = "calibration"
+	BundleSectionCalibrationHistory = "calibration_history"
+	BundleSectionAlertRule          = "alert_rule"
+)
+
+// The actions of a bundle change
+const (
+	BundleActionAdd    = "add"
+	BundleActionChange = "change"
+	BundleActionRemove = "remove"
+	BundleActionKeep   = "keep"
+)
+
+// Bundle is the setup of a minder, which can be imported to restore it or to
+// set up another minder the same way
+type Bundle struct {
+	Version      int                 `json:"version"`
+	Exported     time.Time           `json:"exported"`
+	Config       Config              `json:"config"`
+	Calibrations []BundleCalibration `json:"calibrations"`
+	AlertRules   []AlertRule         `json:"alert_rules"`
+
+	// Probes are the EC probes the minder knew about when it was exported
+	Probes []BundleProbe `json:"probes"`
+}
+
+// BundleCalibration is a calibration in a bundle along with its history
+type BundleCalibration struct {
+	// Key is what the calibration is stored under, a field or ec_probe:<serial>
+	Key string `json:"key"`
+	calibration
+	History []CalibrationRecord `json:"history,omitempty"`
+}
+
+// record returns the calibration as a record for the history
+func (bc BundleCalibration) record() CalibrationRecord {
+	c := bc.calibration
+	rec := CalibrationRecord{
+		Model:    c.Model,
+		Scale:    c.Scale,
+		Offset:   c.Offset,
+		Table:    c.Table,
+		Coeffs:   c.Coeffs,
+		RateRef:  c.RateRef,
+		RateCoef: c.RateCoef,
+		Source:   CalibrationSourceImport,
+	}
+
+	if strings.HasPrefix(bc.Key, ecProbeKeyPrefix) {
+		rec.Probe = strings.TrimPrefix(bc.Key, ecProbeKeyPrefix)
+
+		// the side the probe was last calibrated on
+		if n := len(bc.History); n > 0 {
+			rec.Field = bc.History[n-1].Field
+		}
+	} else {
+		rec.Field = bc.Key
+	}
+
+	return rec
+}
+
+// BundleProbe is an EC probe that the minder knew about, the side is empty if
+// the probe wasn't being used
+type BundleProbe struct {
+	Serial     string `json:"serial"`
+	Side       string `json:"side,omitempty"`
+	Calibrated bool   `json:"calibrated"`
+}
+
+// BundleChange is a change that importing a bundle makes, with the value
+// before and after it
+type BundleChange struct {
+	Section string      `json:"section"`
+	Key     string      `json:"key"`
+	Action  string      `json:"action"`
+	From    interface{} `json:"from,omitempty"`
+	To      interface{} `json:"to,omitempty"`
+}
+
+// ImportOptions are the choices of how a bundle is imported
+type ImportOptions struct {
+	// DryRun only works out the changes without making them
+	DryRun bool
+
+	// Identity takes the EC probe of each side and the MQTT client ID from the
+	// bundle too, otherwise the minder keeps its own as they belong to its
+	// hardware rather than its setup
+	Identity bool
+}
+
+// identitySettings are the config settings that an import keeps unless it is
+// asked to take the identity from the bundle
+var identitySettings = []struct {
+	key   string
+	value func(*Config) *string
+}{
+	{"irrig_ec_probe", func(cfg *Config) *string { return &cfg.IrrigECProbe }},
+	{"runoff_ec_probe", func(cfg *Config) *string { return &cfg.RunoffECProbe }},
+	{"mqtt.client_id", func(cfg *Config) *string { return &cfg.MQTT.ClientID }},
+}
+
+// ImportResult is what importing a bundle changed, or would change on a dry run
+type ImportResult struct {
+	DryRun   bool           `json:"dry_run"`
+	Changes  []BundleChange `json:"changes"`
+	Warnings []string       `json:"warnings,omitempty"`
+
+	// Kept are the identity settings of the minder that are different in the
+	// bundle but were kept, from the minder's value to the bundle's
+	Kept []BundleChange `json:"kept,omitempty"`
+
+	// RestartRequired is set when the config changed, as most of it is only
+	// used when the minder starts
+	RestartRequired bool `json:"restart_required"`
+}
+
+// Validate checks that the bundle can be imported, filling in the defaults of
+// its calibrations and alert rules
+func (b *Bundle) Validate() error {
+	switch {
+	case b.Version == 0:
+		return fmt.Errorf("the bundle has no version")
+	case b.Version > BundleVersion:
+		return fmt.Errorf("bundle version %d is newer than this minder supports (%d)", b.Version, BundleVersion)
+	}
+
+	if err := b.Config.validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", err)
+	}
+
+	keys := map[string]bool{}
+	for i := range b.Calibrations {
+		bc := &b.Calibrations[i]
+		if !isCalibrationKey(bc.Key) {
+			return fmt.Errorf("invalid calibration key: %q", bc.Key)
+		}
+
+		if keys[bc.Key] {
+			return fmt.Errorf("calibration %s is in the bundle more than once", bc.Key)
+		}
+		keys[bc.Key] = true
+
+		if bc.Model == "" {
+			bc.Model = defaultCalibrationModel(bc.Key)
+		}
+
+		if err := bc.validate(); err != nil {
+			return fmt.Errorf("calibration %s: %s", bc.Key, err)
+		}
+
+		ids := map[uint64]bool{}
+		for _, rec := range bc.History {
+			if rec.key() != bc.Key {
+				return fmt.Errorf("calibration %s has history for %s", bc.Key, rec.key())
+			}
+
+			if rec.ID == 0 || ids[rec.ID] {
+				return fmt.Errorf("calibration %s has history without a unique id", bc.Key)
+			}
+			ids[rec.ID] = true
+		}
+	}
+
+	ids := map[string]bool{}
+	for i := range b.AlertRules {
+		r := &b.AlertRules[i]
+		if r.ID == "" || ids[r.ID] {
+			return fmt.Errorf("alert rules must have a unique id")
+		}
+		ids[r.ID] = true
+
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("alert rule %s: %s", r.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Export returns the setup of the minder as a bundle
+func (mdr *Minder) Export() (Bundle, error) {
+	b := Bundle{
+		Version:      BundleVersion,
+		Exported:     time.Now(),
+		Config:       *mdr.cfg,
+		Calibrations: []BundleCalibration{},
+		AlertRules:   mdr.alerts.Rules(),
+	}
+
+	keys, err := mdr.tr.calibrationKeys()
+	if err != nil {
+		return b, err
+	}
+
+	for _, key := range keys {
+		c, err := mdr.tr.getCalibration(key)
+		if err != nil {
+			continue
+		}
+
+		bc := BundleCalibration{Key: key, calibration: c}
+		if bc.History, err = mdr.tr.CalibrationHistory(key); err != nil {
+			return b, err
+		}
+
+		b.Calibrations = append(b.Calibrations, bc)
+	}
+
+	b.Probes = mdr.bundleProbes(keys)
+	return b, nil
+}
+
+// bundleProbes returns the EC probes that are on a side, on the bus or have a
+// calibration under one of the keys
+func (mdr *Minder) bundleProbes(keys []string) []BundleProbe {
+	probes := map[string]*BundleProbe{}
+	probe := func(sn string) *BundleProbe {
+		if probes[sn] == nil {
+			probes[sn] = &BundleProbe{Serial: sn}
+		}
+		return probes[sn]
+	}
+
+	if mdr.bus != nil {
+		for _, sn := range mdr.bus.Serials() {
+			probe(sn)
+		}
+	}
+
+	for side, sn := range mdr.probeSerials() {
+		if sn != "" {
+			probe(sn).Side = side
+		}
+	}
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, ecProbeKeyPrefix) {
+			probe(strings.TrimPrefix(key, ecProbeKeyPrefix)).Calibrated = true
+		}
+	}
+
+	list := []BundleProbe{}
+	for _, p := range probes {
+		list = append(list, *p)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Serial < list[j].Serial })
+	return list
+}
+
+// Import makes the setup of the minder match the bundle.  Calibrations that
+// aren't in the bundle are kept, and the history of those that are is added to
+// rather than replaced.  The EC probes and MQTT client ID are kept unless the
+// options ask for the identity too.  On a dry run nothing is changed and the
+// changes that would have been made are returned
+func (mdr *Minder) Import(b Bundle, opts ImportOptions) (ImportResult, error) {
+	res := ImportResult{DryRun: opts.DryRun, Changes: []BundleChange{}}
+	if err := b.Validate(); err != nil {
+		return res, err
+	}
+
+	cfg := b.Config
+	if !opts.Identity {
+		res.Kept = keepIdentity(*mdr.cfg, &cfg)
+	}
+
+	cfgChanges, err := diffConfig(*mdr.cfg, cfg)
+	if err != nil {
+		return res, err
+	}
+	res.Changes = append(res.Changes, cfgChanges...)
+	res.RestartRequired = len(cfgChanges) > 0
+
+	for _, bc := range b.Calibrations {
+		chg := BundleChange{Section: BundleSectionCalibration, Key: bc.Key, Action: BundleActionAdd, To: bc.calibration}
+		if cur, err := mdr.tr.getCalibration(bc.Key); err == nil {
+			chg.Action = BundleActionChange
+			chg.From = cur
+		}
+
+		if chg.Action == BundleActionAdd || !jsonEqual(chg.From, chg.To) {
+			res.Changes = append(res.Changes, chg)
+		}
+
+		hist, err := mdr.tr.CalibrationHistory(bc.Key)
+		if err != nil {
+			return res, err
+		}
+
+		// the imported history is added to the local one, the change is the
+		// number of records before and after
+		if added, _ := newHistoryRecords(hist, bc.History); len(added) > 0 {
+			res.Changes = append(res.Changes, BundleChange{
+				Section: BundleSectionCalibrationHistory,
+				Key:     bc.Key,
+				Action:  BundleActionAdd,
+				From:    len(hist),
+				To:      len(hist) + len(added),
+			})
+		}
+	}
+
+	rules := map[string]AlertRule{}
+	for _, r := range mdr.alerts.Rules() {
+		rules[r.ID] = r
+	}
+
+	setRules := []AlertRule{}
+	for _, r := range b.AlertRules {
+		cur, ok := rules[r.ID]
+		delete(rules, r.ID)
+
+		switch {
+		case !ok:
+			res.Changes = append(res.Changes, BundleChange{Section: BundleSectionAlertRule, Key: r.ID, Action: BundleActionAdd, To: r})
+		case !jsonEqual(cur, r):
+			res.Changes = append(res.Changes, BundleChange{Section: BundleSectionAlertRule, Key: r.ID, Action: BundleActionChange, From: cur, To: r})
+		default:
+			continue
+		}
+
+		setRules = append(setRules, r)
+	}
+
+	deleteRules := []string{}
+	for id, r := range rules {
+		res.Changes = append(res.Changes, BundleChange{Section: BundleSectionAlertRule, Key: id, Action: BundleActionRemove, From: r})
+		deleteRules = append(deleteRules, id)
+	}
+
+	res.Warnings = mdr.bundleWarnings(b, opts.Identity)
+
+	if opts.DryRun {
+		return res, nil
+	}
+
+	recs, err := mdr.tr.importCalibrations(b.Calibrations)
+	if err != nil {
+		return res, err
+	}
+
+	for _, rec := range recs {
+		mdr.notify(EventCalibrationChanged, rec)
+	}
+
+	for _, r := range setRules {
+		if _, err := mdr.alerts.SetRule(r); err != nil {
+			return res, err
+		}
+	}
+
+	for _, id := range deleteRules {
+		if _, err := mdr.alerts.DeleteRule(id); err != nil {
+			return res, err
+		}
+	}
+
+	if res.RestartRequired {
+		*mdr.cfg = cfg
+		go mdr.onCfgChangeCB(*mdr.cfg)
+	}
+
+	return res, nil
+}
+
+// keepIdentity sets the identity settings of the config to those of the
+// minder's own, returning the ones that were different
+func keepIdentity(own Config, cfg *Config) []BundleChange {
+	kept := []BundleChange{}
+	for _, s := range identitySettings {
+		from, to := *s.value(&own), s.value(cfg)
+		if from != *to {
+			kept = append(kept, BundleChange{Section: BundleSectionConfig, Key: s.key, Action: BundleActionKeep, From: from, To: *to})
+			*to = from
+		}
+	}
+
+	return kept
+}
+
+// bundleWarnings returns the problems with the probes of the bundle that don't
+// stop it from being imported, the sides of the probes only matter when the
+// identity is imported
+func (mdr *Minder) bundleWarnings(b Bundle, identity bool) []string {
+	if mdr.bus == nil {
+		return nil
+	}
+
+	onBus := map[string]bool{}
+	for _, sn := range mdr.bus.Serials() {
+		onBus[sn] = true
+	}
+
+	warnings := []string{}
+	for _, p := range b.Probes {
+		switch {
+		case onBus[p.Serial]:
+		case p.Side != "" && identity:
+			warnings = append(warnings, fmt.Sprintf("the %s EC probe %s isn't on the bus, the side will be given to another probe on the next scan", p.Side, p.Serial))
+		case p.Calibrated:
+			warnings = append(warnings, fmt.Sprintf("the calibration for EC probe %s will only be used once it is on the bus", p.Serial))
+		}
+	}
+
+	return warnings
+}
+
+// diffConfig returns the changes to each setting from one config to the other
+func diffConfig(from, to Config) ([]BundleChange, error) {
+	a, err := configSettings(from)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := configSettings(to)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changes := []BundleChange{}
+	for _, k := range keys {
+		if !bytes.Equal(a[k], b[k]) {
+			changes = append(changes, BundleChange{Section: BundleSectionConfig, Key: k, Action: BundleActionChange, From: a[k], To: b[k]})
+		}
+	}
+
+	return changes, nil
+}
+
+// configSettings returns the JSON of each of the config's settings
+func configSettings(cfg Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// jsonEqual returns true if the values are the same as JSON
+func jsonEqual(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(x, y)
+}
@@ -0,0 +1,229 @@
+package openminder
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBundles(t *testing.T) {
+	Convey("given two minders", t, func() {
+		newMinder := func(cfg *Config) *Minder {
+			jdb := newTestDB(t)
+
+			n, err := newNotifier(jdb.db, nil)
+			So(err, ShouldBeNil)
+
+			ae, err := newAlertEngine(jdb.db)
+			So(err, ShouldBeNil)
+
+			return &Minder{
+				tr:            &Translater{jdb},
+				cfg:           cfg,
+				alerts:        ae,
+				notifier:      n,
+				stream:        newStream(),
+				errors:        newErrorStore(),
+				onCfgChangeCB: func(Config) {},
+			}
+		}
+
+		src := newMinder(&Config{Port: "3232", IrrigECProbe: "1111", RunoffECProbe: "2222", DayStartHour: 6})
+		dst := newMinder(&Config{Port: "3232"})
+
+		_, err := src.Calibrate(CalibrationRecord{Field: "irrig_ph", Scale: 1.1, Offset: 0.2})
+		So(err, ShouldBeNil)
+		_, err = src.Calibrate(CalibrationRecord{Field: "irrig_ph", Scale: 1.2, Offset: 0.1, Note: "new buffers"})
+		So(err, ShouldBeNil)
+		_, err = src.Calibrate(CalibrationRecord{Field: "runoff_ec", Scale: 0.9})
+		So(err, ShouldBeNil)
+		So(src.tr.SetCalibration("moisture", 2, 0.5), ShouldBeNil)
+
+		_, err = src.alerts.SetRule(AlertRule{ID: "ph", Field: "irrig_ph", Op: OpOutside, Min: 5.5, Max: 6.5})
+		So(err, ShouldBeNil)
+
+		_, err = dst.alerts.SetRule(AlertRule{ID: "old", Field: "irrig_ec", Op: OpAbove, Value: 3})
+		So(err, ShouldBeNil)
+
+		Convey("the export should have the whole setup", func() {
+			b, err := src.Export()
+			So(err, ShouldBeNil)
+			So(b.Version, ShouldEqual, BundleVersion)
+			So(b.Config.DayStartHour, ShouldEqual, 6)
+			So(b.AlertRules, ShouldHaveLength, 1)
+
+			So(b.Calibrations, ShouldHaveLength, 3)
+			So(b.Calibrations[0].Key, ShouldEqual, "ec_probe:2222")
+			So(b.Calibrations[0].Scale, ShouldEqual, 0.9)
+			So(b.Calibrations[1].Key, ShouldEqual, "irrig_ph")
+			So(b.Calibrations[1].History, ShouldHaveLength, 2)
+			So(b.Calibrations[2].Key, ShouldEqual, "moisture")
+			So(b.Calibrations[2].Model, ShouldEqual, CalibrationModelPercent)
+
+			So(b.Probes, ShouldResemble, []BundleProbe{
+				{Serial: "1111", Side: "irrig"},
+				{Serial: "2222", Side: "runoff", Calibrated: true},
+			})
+
+			Convey("and it should survive being sent as JSON", func() {
+				data, err := json.Marshal(b)
+				So(err, ShouldBeNil)
+
+				b2 := Bundle{}
+				So(json.Unmarshal(data, &b2), ShouldBeNil)
+				So(b2.Calibrations[1].Scale, ShouldEqual, 1.2)
+				So(b2.Calibrations[1].History[1].Note, ShouldEqual, "new buffers")
+			})
+
+			Convey("a dry run import should only list the changes", func() {
+				res, err := dst.Import(b, ImportOptions{DryRun: true})
+				So(err, ShouldBeNil)
+				So(res.DryRun, ShouldBeTrue)
+				So(res.RestartRequired, ShouldBeTrue)
+
+				count := map[string]int{}
+				for _, chg := range res.Changes {
+					count[chg.Section+" "+chg.Action]++
+				}
+
+				So(count, ShouldResemble, map[string]int{
+					"config change":           1,
+					"calibration add":         3,
+					"calibration_history add": 3,
+					"alert_rule add":          1,
+					"alert_rule remove":       1,
+				})
+
+				_, err = dst.tr.getCalibration("irrig_ph")
+				So(err, ShouldNotBeNil)
+				So(dst.cfg.DayStartHour, ShouldEqual, 0)
+				So(dst.alerts.Rules()[0].ID, ShouldEqual, "old")
+
+				Convey("and say which of the minder's own settings it keeps", func() {
+					So(res.Kept, ShouldHaveLength, 2)
+					So(res.Kept[0].Key, ShouldEqual, "irrig_ec_probe")
+					So(res.Kept[0].Action, ShouldEqual, BundleActionKeep)
+					So(res.Kept[0].To, ShouldEqual, "1111")
+					So(res.Kept[1].Key, ShouldEqual, "runoff_ec_probe")
+				})
+			})
+
+			Convey("importing should keep the probes and MQTT client ID of the minder", func() {
+				dst.cfg.IrrigECProbe = "3333"
+				dst.cfg.MQTT.ClientID = "dst"
+				b.Config.MQTT.ClientID = "src"
+
+				_, err := dst.Import(b, ImportOptions{})
+				So(err, ShouldBeNil)
+				So(dst.cfg.DayStartHour, ShouldEqual, 6)
+				So(dst.cfg.IrrigECProbe, ShouldEqual, "3333")
+				So(dst.cfg.RunoffECProbe, ShouldEqual, "")
+				So(dst.cfg.MQTT.ClientID, ShouldEqual, "dst")
+
+				Convey("unless the identity is imported too", func() {
+					_, err := dst.Import(b, ImportOptions{Identity: true})
+					So(err, ShouldBeNil)
+					So(dst.cfg.IrrigECProbe, ShouldEqual, "1111")
+					So(dst.cfg.MQTT.ClientID, ShouldEqual, "src")
+				})
+			})
+
+			Convey("importing should set up the minder the same", func() {
+				_, err := dst.Import(b, ImportOptions{Identity: true})
+				So(err, ShouldBeNil)
+
+				So(dst.cfg.DayStartHour, ShouldEqual, 6)
+				So(dst.cfg.RunoffECProbe, ShouldEqual, "2222")
+
+				v, err := dst.tr.Translate(dst.calibrationKey("runoff_ec"), 2)
+				So(err, ShouldBeNil)
+				So(v, ShouldAlmostEqual, 1.8)
+
+				recs, err := dst.CalibrationHistory("irrig_ph")
+				So(err, ShouldBeNil)
+				So(recs, ShouldHaveLength, 3)
+				So(recs[1].Note, ShouldEqual, "new buffers")
+				So(recs[2].Source, ShouldEqual, CalibrationSourceImport)
+				So(recs[2].Scale, ShouldEqual, 1.2)
+
+				rules := dst.alerts.Rules()
+				So(rules, ShouldHaveLength, 1)
+				So(rules[0].ID, ShouldEqual, "ph")
+
+				Convey("the history should carry on from the imported one", func() {
+					rec, err := dst.Calibrate(CalibrationRecord{Field: "irrig_ph", Scale: 1})
+					So(err, ShouldBeNil)
+					So(rec.ID, ShouldEqual, 4)
+				})
+
+				Convey("importing it again should change nothing", func() {
+					res, err := dst.Import(b, ImportOptions{DryRun: true})
+					So(err, ShouldBeNil)
+					So(res.Changes, ShouldBeEmpty)
+					So(res.RestartRequired, ShouldBeFalse)
+				})
+			})
+
+			Convey("the history from before the import should be kept", func() {
+				good, err := dst.Calibrate(CalibrationRecord{Field: "irrig_ph", Scale: 0.95, Note: "good"})
+				So(err, ShouldBeNil)
+
+				_, err = dst.Import(b, ImportOptions{})
+				So(err, ShouldBeNil)
+
+				recs, err := dst.CalibrationHistory("irrig_ph")
+				So(err, ShouldBeNil)
+				So(recs, ShouldHaveLength, 4)
+				So(recs[0].Note, ShouldEqual, "good")
+				So(recs[1].ID, ShouldEqual, 2)
+				So(recs[3].Source, ShouldEqual, CalibrationSourceImport)
+
+				Convey("so it can be rolled back to", func() {
+					_, err := dst.tr.Rollback("irrig_ph", good.ID, "bad import")
+					So(err, ShouldBeNil)
+
+					v, err := dst.tr.Translate("irrig_ph", 10)
+					So(err, ShouldBeNil)
+					So(v, ShouldAlmostEqual, 9.5)
+				})
+			})
+
+			Convey("calibrations that aren't in the bundle should be kept", func() {
+				So(dst.tr.SetCalibration("irrig_volume", 5, 0), ShouldBeNil)
+				_, err := dst.Import(b, ImportOptions{})
+				So(err, ShouldBeNil)
+
+				c, err := dst.tr.getCalibration("irrig_volume")
+				So(err, ShouldBeNil)
+				So(c.Scale, ShouldEqual, 5)
+			})
+
+			Convey("bundles that don't validate should not be imported", func() {
+				for _, broken := range []func(b *Bundle){
+					func(b *Bundle) { b.Version = 0 },
+					func(b *Bundle) { b.Version = BundleVersion + 1 },
+					func(b *Bundle) { b.Config.DayStartHour = 24 },
+					func(b *Bundle) { b.Calibrations[0].Key = "irrig_adc" },
+					func(b *Bundle) { b.Calibrations[1].Model = "spline" },
+					func(b *Bundle) { b.Calibrations[1].History[0].Field = "runoff_ph" },
+					func(b *Bundle) { b.Calibrations = append(b.Calibrations, b.Calibrations[2]) },
+					func(b *Bundle) { b.AlertRules[0].Op = "~" },
+					func(b *Bundle) { b.AlertRules[0].ID = "" },
+				} {
+					data, _ := json.Marshal(b)
+					bb := Bundle{}
+					So(json.Unmarshal(data, &bb), ShouldBeNil)
+					broken(&bb)
+
+					_, err := dst.Import(bb, ImportOptions{})
+					So(err, ShouldNotBeNil)
+				}
+
+				So(dst.alerts.Rules()[0].ID, ShouldEqual, "old")
+				_, err := dst.tr.getCalibration("irrig_ph")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -50,15 +50,15 @@ func TransformPH(reading, scale, offset float64) float64 {
 	return (((reading - 7.0) + offset) * scale) + 7.0
 }
 
-// EC takes a buffer value and an EC reading from in that buffer and calculates
-// the scale and offset to calibrate future values from.  OK will be false if
-// the ec reading was too far out of range (indicating a bad probe)
-func EC(buffer, ec float64) (scale, offset float64, err error) {
-	scale = buffer / ec
-	min, max := (buffer * 0.5), (buffer * 1.5)
+// Moisture takes the readings from a moisture probe when dry and when wet and
+// calculates the scale and offset that turn a reading into a percentage.  An
+// error will be returned if the readings are too close together to tell apart
+func Moisture(dry, wet float64) (scale, offset float64, err error) {
+	scale = wet - dry
+	offset = dry
 
-	if ec <= max && ec >= min {
-		err = fmt.Errorf("EC reading out of range")
+	if math.Abs(scale) < 0.1 {
+		err = fmt.Errorf("dry reading (%.3f) and wet reading (%.3f) are too close together", dry, wet)
 	}
 
 	return
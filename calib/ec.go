@@ -0,0 +1,77 @@
+package calib
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// minECRatio and maxECRatio are how far from the buffer an uncalibrated EC
+	// reading can be, outside of these the probe is likely bad
+	minECRatio = 0.5
+	maxECRatio = 1.5
+
+	// minECBufferRatio is how many times the high buffer must be of the low one
+	// for the slope between them to be worth working out
+	minECBufferRatio = 2.0
+
+	// maxECOffset is the furthest from 0 mS/cm a usable EC probe should read in
+	// pure water
+	maxECOffset = 0.5
+)
+
+// EC takes a buffer value and an EC reading from in that buffer and calculates
+// the scale and offset to calibrate future values from.  An error will be
+// returned if the ec reading was too far out of range (indicating a bad probe)
+func EC(buffer, ec float64) (scale, offset float64, err error) {
+	if err = checkECReading(buffer, ec); err != nil {
+		return
+	}
+
+	scale = buffer / ec
+	return
+}
+
+// ECTwoPoint takes the EC readings from in a low and a high buffer, such as
+// 1.413 and 12.88 mS/cm, and calculates the scale and offset that calibrate
+// future values across the range between them.  An error will be returned if a
+// reading is too far out of range or the probe is too far off in pure water
+func ECTwoPoint(low, high Point) (scale, offset float64, err error) {
+	if low.Buffer > high.Buffer {
+		low, high = high, low
+	}
+
+	if low.Buffer <= 0 || high.Buffer < low.Buffer*minECBufferRatio {
+		err = fmt.Errorf("EC buffers (%.3f and %.3f) are too close together", low.Buffer, high.Buffer)
+		return
+	}
+
+	for _, p := range []Point{low, high} {
+		if err = checkECReading(p.Buffer, p.Reading); err != nil {
+			return
+		}
+	}
+
+	scale = (high.Buffer - low.Buffer) / (high.Reading - low.Reading)
+	offset = low.Buffer - low.Reading*scale
+
+	if math.Abs(offset) > maxECOffset {
+		err = fmt.Errorf("EC readings (%.3f and %.3f) produce an out of range offset (%.3f)", low.Reading, high.Reading, offset)
+		return
+	}
+
+	return
+}
+
+// checkECReading returns an error if the reading is too far from the buffer
+func checkECReading(buffer, ec float64) error {
+	if buffer <= 0 {
+		return fmt.Errorf("EC buffer (%.3f) must be above 0", buffer)
+	}
+
+	if ec < buffer*minECRatio || ec > buffer*maxECRatio {
+		return fmt.Errorf("EC reading (%.3f) out of range for the %.3f buffer", ec, buffer)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package calib
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEC(t *testing.T) {
+	Convey("a reading close to the buffer should give the scale", t, func() {
+		scale, offset, err := EC(2.77, 2.5)
+		So(err, ShouldBeNil)
+		So(scale, ShouldAlmostEqual, 2.77/2.5)
+		So(offset, ShouldEqual, 0)
+	})
+
+	Convey("a reading far from the buffer should be refused", t, func() {
+		_, _, err := EC(2.77, 1)
+		So(err, ShouldNotBeNil)
+
+		_, _, err = EC(2.77, 5)
+		So(err, ShouldNotBeNil)
+
+		_, _, err = EC(2.77, 0)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestECTwoPoint(t *testing.T) {
+	Convey("readings in a low and a high buffer should give the scale and offset", t, func() {
+		// the probe reads 5% high with 0.1 mS/cm in pure water
+		low := Point{Buffer: 1.413, Reading: 1.413*1.05 + 0.1}
+		high := Point{Buffer: 12.88, Reading: 12.88*1.05 + 0.1}
+
+		scale, offset, err := ECTwoPoint(low, high)
+		So(err, ShouldBeNil)
+		So(scale, ShouldAlmostEqual, 1/1.05)
+		So(offset, ShouldAlmostEqual, -0.1/1.05)
+
+		Convey("in either order", func() {
+			s, o, err := ECTwoPoint(high, low)
+			So(err, ShouldBeNil)
+			So(s, ShouldAlmostEqual, scale)
+			So(o, ShouldAlmostEqual, offset)
+		})
+	})
+
+	Convey("buffers that are too close together should be refused", t, func() {
+		_, _, err := ECTwoPoint(Point{Buffer: 2.77, Reading: 2.7}, Point{Buffer: 2.77, Reading: 2.8})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("a reading far from its buffer should be refused", t, func() {
+		_, _, err := ECTwoPoint(Point{Buffer: 1.413, Reading: 0.4}, Point{Buffer: 12.88, Reading: 12.5})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("a probe that is too far off in pure water should be refused", t, func() {
+		_, _, err := ECTwoPoint(Point{Buffer: 1.413, Reading: 2}, Point{Buffer: 12.88, Reading: 12.5})
+		So(err, ShouldNotBeNil)
+	})
+}
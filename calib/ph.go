@@ -0,0 +1,109 @@
+package calib
+
+import (
+	"fmt"
+	"math"
+)
+
+// NernstSlope is the theoretical slope of a pH electrode at 25°C in mV per pH
+const NernstSlope = 59.16
+
+const (
+	// minPHSlope and maxPHSlope are the slope percentages a usable pH probe
+	// should be within, outside of these it should be cleaned or replaced
+	minPHSlope = 85.0
+	maxPHSlope = 105.0
+
+	// maxPHOffsetMV is the furthest from 0 mV a usable pH probe should read in pH7
+	maxPHOffsetMV = 30.0
+)
+
+// Point is a reading taken with a probe in a buffer of known value
+type Point struct {
+	Buffer  float64 `json:"buffer"`
+	Reading float64 `json:"reading"`
+}
+
+// PHResult is the calibration worked out from the pH buffer points
+type PHResult struct {
+	// Scale and Offset calibrate the readings as reading*scale+offset
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+
+	// SlopePercent is the slope of the probe as a percentage of the Nernst slope
+	SlopePercent float64 `json:"slope_percent"`
+
+	// OffsetMV is the potential of the probe in pH7
+	OffsetMV float64 `json:"offset_mv"`
+}
+
+// fitLine returns the least squares fit of buffer = reading*scale + offset
+func fitLine(points []Point) (scale, offset float64, err error) {
+	n := float64(len(points))
+	var sx, sy, sxx, sxy float64
+
+	for _, p := range points {
+		sx += p.Reading
+		sy += p.Buffer
+		sxx += p.Reading * p.Reading
+		sxy += p.Reading * p.Buffer
+	}
+
+	d := n*sxx - sx*sx
+	if math.Abs(d) < 1e-12 {
+		return 0, 0, fmt.Errorf("the readings are all the same")
+	}
+
+	scale = (n*sxy - sx*sy) / d
+	offset = (sy - scale*sx) / n
+	return
+}
+
+// PHMultiPoint works out the calibration of a pH probe from readings taken in 2
+// to 5 buffers by fitting a line through them.  The readings are the uncalibrated
+// pH from a circuit that assumes a slope of rawSlope mV per pH.  An error is
+// returned if the probe's slope or offset shows it needs replacing.
+func PHMultiPoint(points []Point, rawSlope float64) (res PHResult, err error) {
+	if len(points) < 2 || len(points) > 5 {
+		return res, fmt.Errorf("need between 2 and 5 buffers, got %d", len(points))
+	}
+
+	lo, hi := points[0].Buffer, points[0].Buffer
+	for _, p := range points {
+		if p.Buffer < 0 || p.Buffer > 14 {
+			return res, fmt.Errorf("buffer pH%0.2f is out of range", p.Buffer)
+		}
+
+		lo = math.Min(lo, p.Buffer)
+		hi = math.Max(hi, p.Buffer)
+	}
+
+	if hi-lo < 1 {
+		return res, fmt.Errorf("the buffers need to be at least 1 pH apart")
+	}
+
+	if res.Scale, res.Offset, err = fitLine(points); err != nil {
+		return res, err
+	}
+
+	if res.Scale <= 0 {
+		return res, fmt.Errorf("the readings go the wrong way, the probe may be faulty")
+	}
+
+	// each raw pH is rawSlope mV, so the probe moves rawSlope/scale mV per pH
+	res.SlopePercent = rawSlope / res.Scale / NernstSlope * 100
+
+	// the reading the probe gives in pH7, as mV away from the ideal 0 mV
+	ph7 := (7 - res.Offset) / res.Scale
+	res.OffsetMV = (7 - ph7) * rawSlope
+
+	if res.SlopePercent < minPHSlope || res.SlopePercent > maxPHSlope {
+		return res, fmt.Errorf("probe slope of %0.1f%% is outside %0.0f-%0.0f%%, clean or replace the probe", res.SlopePercent, minPHSlope, maxPHSlope)
+	}
+
+	if math.Abs(res.OffsetMV) > maxPHOffsetMV {
+		return res, fmt.Errorf("probe offset of %0.1f mV is more than ±%0.0f mV, clean or replace the probe", res.OffsetMV, maxPHOffsetMV)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package calib
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPHMultiPoint(t *testing.T) {
+	Convey("given readings from a perfect probe in three buffers", t, func() {
+		points := []Point{{4, 4}, {7, 7}, {10, 10}}
+
+		Convey("the calibration should change nothing", func() {
+			res, err := PHMultiPoint(points, NernstSlope)
+			So(err, ShouldBeNil)
+			So(res.Scale, ShouldAlmostEqual, 1)
+			So(res.Offset, ShouldAlmostEqual, 0)
+			So(res.SlopePercent, ShouldAlmostEqual, 100)
+			So(res.OffsetMV, ShouldAlmostEqual, 0)
+		})
+	})
+
+	Convey("given readings from a tired probe with a 95% slope and offset", t, func() {
+		// the probe reads 0.1 pH high in pH7 and only moves 0.95 pH per pH
+		points := []Point{{4, 7.1 - 3*0.95}, {7, 7.1}, {10, 7.1 + 3*0.95}}
+
+		Convey("the calibration should correct it", func() {
+			res, err := PHMultiPoint(points, 59)
+			So(err, ShouldBeNil)
+			So(res.Scale*7.1+res.Offset, ShouldAlmostEqual, 7)
+			So(res.Scale*(7.1+3*0.95)+res.Offset, ShouldAlmostEqual, 10)
+			So(res.SlopePercent, ShouldAlmostEqual, 0.95*59/NernstSlope*100)
+			So(res.OffsetMV, ShouldAlmostEqual, -5.9)
+		})
+	})
+
+	Convey("a pH7 and pH4 pair should still work", t, func() {
+		res, err := PHMultiPoint([]Point{{7, 7.05}, {4, 4.1}}, NernstSlope)
+		So(err, ShouldBeNil)
+		So(res.Scale*4.1+res.Offset, ShouldAlmostEqual, 4)
+	})
+
+	Convey("bad points should be refused", t, func() {
+		_, err := PHMultiPoint([]Point{{7, 7}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+
+		_, err = PHMultiPoint([]Point{{7, 7}, {7.5, 7.5}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+
+		_, err = PHMultiPoint([]Point{{7, 7}, {4, 7}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+
+		_, err = PHMultiPoint([]Point{{7, 4}, {4, 7}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("a probe with a low slope should be refused", t, func() {
+		_, err := PHMultiPoint([]Point{{4, 5.5}, {7, 7}, {10, 8.5}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "slope")
+	})
+
+	Convey("a probe with a big offset should be refused", t, func() {
+		_, err := PHMultiPoint([]Point{{4, 4.8}, {7, 7.8}, {10, 10.8}}, NernstSlope)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "offset")
+	})
+}
@@ -0,0 +1,91 @@
+package calib
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// minMLPerTip and maxMLPerTip are the volumes a tip of a working tipping
+	// bucket should be within
+	minMLPerTip = 1.0
+	maxMLPerTip = 100.0
+
+	// minRateSpread is how far apart in tips per minute the pours need to be for
+	// a rate correction to be worked out
+	minRateSpread = 1.0
+)
+
+// Pour is a known volume poured through a tipping bucket
+type Pour struct {
+	// Volume is the volume poured in mL
+	Volume float64 `json:"volume"`
+
+	// Tips is how many times the bucket tipped
+	Tips float64 `json:"tips"`
+
+	// Rate is how fast the bucket tipped in tips per minute
+	Rate float64 `json:"rate"`
+}
+
+// TBResult is the calibration of a tipping bucket worked out from the pours
+type TBResult struct {
+	// MLPerTip is the volume of each tip at the reference rate
+	MLPerTip float64 `json:"ml_per_tip"`
+
+	// RateRef is the tip rate in tips per minute that MLPerTip is for, and
+	// RateCoef is how many more mL each tip holds for every tip per minute
+	// faster than that.  They are only set when the pours were at different rates
+	RateRef  float64 `json:"rate_ref"`
+	RateCoef float64 `json:"rate_coef"`
+}
+
+// TippingBucket works out the volume of each tip of a tipping bucket from one
+// or more pours of a known volume.  If the pours were at different rates a
+// rate correction is fitted through the volume per tip of each pour.  An error
+// is returned if the volume per tip is out of range (indicating a bad bucket)
+func TippingBucket(pours []Pour) (res TBResult, err error) {
+	if len(pours) == 0 {
+		return res, fmt.Errorf("need at least 1 pour")
+	}
+
+	var vol, tips, rates float64
+	lo, hi := math.Inf(1), math.Inf(-1)
+	points := []Point{}
+
+	for _, p := range pours {
+		if p.Volume <= 0 {
+			return res, fmt.Errorf("pour volume (%.0f mL) must be more than 0", p.Volume)
+		}
+
+		if p.Tips < 1 {
+			return res, fmt.Errorf("the bucket didn't tip for the %.0f mL pour", p.Volume)
+		}
+
+		vol += p.Volume
+		tips += p.Tips
+		rates += p.Rate
+		lo = math.Min(lo, p.Rate)
+		hi = math.Max(hi, p.Rate)
+		points = append(points, Point{Buffer: p.Volume / p.Tips, Reading: p.Rate})
+	}
+
+	res.MLPerTip = vol / tips
+
+	if len(pours) > 1 && hi-lo >= minRateSpread {
+		coef, intercept, err := fitLine(points)
+		if err != nil {
+			return res, err
+		}
+
+		res.RateRef = rates / float64(len(pours))
+		res.RateCoef = coef
+		res.MLPerTip = intercept + coef*res.RateRef
+	}
+
+	if res.MLPerTip < minMLPerTip || res.MLPerTip > maxMLPerTip {
+		return res, fmt.Errorf("%.2f mL per tip is outside %.0f-%.0f mL, check the bucket and the volumes poured", res.MLPerTip, minMLPerTip, maxMLPerTip)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,46 @@
+package calib
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTippingBucket(t *testing.T) {
+	Convey("a single pour should give the volume per tip", t, func() {
+		res, err := TippingBucket([]Pour{{Volume: 500, Tips: 100, Rate: 10}})
+		So(err, ShouldBeNil)
+		So(res.MLPerTip, ShouldAlmostEqual, 5)
+		So(res.RateCoef, ShouldEqual, 0)
+	})
+
+	Convey("pours at the same rate should be averaged", t, func() {
+		res, err := TippingBucket([]Pour{{500, 100, 10}, {1000, 190, 10.5}})
+		So(err, ShouldBeNil)
+		So(res.MLPerTip, ShouldAlmostEqual, 1500.0/290)
+		So(res.RateCoef, ShouldEqual, 0)
+	})
+
+	Convey("pours at different rates should give a rate correction", t, func() {
+		// each tip holds 5 mL at 10 tips/min and 0.1 mL more per tip/min faster
+		res, err := TippingBucket([]Pour{{500, 100, 10}, {520, 100, 12}, {540, 100, 14}})
+		So(err, ShouldBeNil)
+		So(res.RateRef, ShouldAlmostEqual, 12)
+		So(res.MLPerTip, ShouldAlmostEqual, 5.2)
+		So(res.RateCoef, ShouldAlmostEqual, 0.1)
+	})
+
+	Convey("bad pours should be refused", t, func() {
+		_, err := TippingBucket(nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = TippingBucket([]Pour{{500, 0, 0}})
+		So(err, ShouldNotBeNil)
+
+		_, err = TippingBucket([]Pour{{0, 10, 5}})
+		So(err, ShouldNotBeNil)
+
+		_, err = TippingBucket([]Pour{{500, 1000, 5}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,513 @@
+package openminder
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/types"
+)
+
+// The probes that can be calibrated with a calibration session
+const (
+	ProbeEC       = "ec"
+	ProbePH       = "ph"
+	ProbeMoisture = "moisture"
+	ProbeTB       = "tb"
+)
+
+const (
+	// sessionWindow is how many readings the stability of a session's reading
+	// is worked out from, the readings are taken about once a second
+	sessionWindow = 10
+
+	// tbSessionWindow is how many readings the tips have to stop for before a
+	// pour through a tipping bucket is done
+	tbSessionWindow = 20
+
+	// sessionTimeout is how long a session is kept for without being looked at
+	sessionTimeout = 30 * time.Minute
+
+	// tbSessionTimeout is how long a tipping bucket session is kept for without
+	// being looked at, it is short as the tips of an armed step aren't counted
+	tbSessionTimeout = 2 * time.Minute
+)
+
+// Errors returned by the calibration sessions
+var (
+	ErrSessionNotFound = fmt.Errorf("calibration session not found")
+	ErrSessionUnstable = fmt.Errorf("the reading has not settled yet")
+	ErrSessionNoValue  = fmt.Errorf("there is no reading from the probe")
+	ErrSessionCaptured = fmt.Errorf("all the points have been captured")
+	ErrSessionNotReady = fmt.Errorf("not all the points have been captured")
+	ErrSessionNotArmed = fmt.Errorf("the step has not been marked as ready")
+)
+
+// sessionProbe describes how a type of probe is calibrated in a session
+type sessionProbe struct {
+	// raw and field are the uncalibrated reading the points are taken from and
+	// the reading that is calibrated, with %s replaced by the side
+	raw   string
+	field string
+	sided bool
+
+	// buffers are the default buffers, min and max are how many can be used
+	buffers  []float64
+	min, max int
+
+	// window is how many readings stability is worked out over, and tolerance
+	// is how far they can deviate and still be stable, as a fraction of the
+	// reading when relative is set
+	window    int
+	tolerance float64
+	relative  bool
+
+	// counter is set when the probe is a tipping bucket, the tips are handed to
+	// the session instead of a raw reading and the reading for each step is how
+	// many tips there have been since it was armed
+	counter bool
+
+	// timeout is how long the session is kept for without being looked at
+	timeout time.Duration
+}
+
+var sessionProbes = map[string]sessionProbe{
+	ProbeEC: {
+		raw: "%s_ec_raw", field: "%s_ec", sided: true,
+		buffers: []float64{1.413, 12.88}, min: 1, max: 2,
+		window: sessionWindow, tolerance: 0.01, relative: true,
+		timeout: sessionTimeout,
+	},
+	ProbePH: {
+		raw: "%s_ph_raw", field: "%s_ph", sided: true,
+		buffers: []float64{7, 4}, min: 2, max: 5,
+		window: sessionWindow, tolerance: 0.05,
+		timeout: sessionTimeout,
+	},
+	ProbeMoisture: {
+		raw: "moisture_voltage", field: "moisture",
+		buffers: []float64{0, 100}, min: 2, max: 2,
+		window: sessionWindow, tolerance: 0.005,
+		timeout: sessionTimeout,
+	},
+	ProbeTB: {
+		field: "%s_volume", sided: true,
+		buffers: []float64{500}, min: 1, max: 5,
+		window: tbSessionWindow, counter: true,
+		timeout: tbSessionTimeout,
+	},
+}
+
+// sessionPrompt returns what to do with the probe before capturing the buffer
+func sessionPrompt(probe string, buffer float64) string {
+	switch {
+	case probe == ProbeEC:
+		return fmt.Sprintf("wash the probe and put it in the %g EC buffer solution", buffer)
+	case probe == ProbePH:
+		return fmt.Sprintf("wash the probe and put it in the pH%g buffer solution", buffer)
+	case probe == ProbeTB:
+		return fmt.Sprintf("pour %g mL through the tipping bucket", buffer)
+	case buffer == 0:
+		return "make sure the probe is completely dry or in dry media"
+	default:
+		return "place the probe in water or completely saturated media"
+	}
+}
+
+// CalibrationStep is a buffer to capture a reading in during a calibration session
+type CalibrationStep struct {
+	Buffer   float64 `json:"buffer"`
+	Prompt   string  `json:"prompt"`
+	Captured bool    `json:"captured"`
+	Reading  float64 `json:"reading"`
+
+	// Rate is how fast a tipping bucket tipped in tips per minute
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// CalibrationSession walks through calibrating a probe, capturing a reading
+// with the probe in each buffer once the reading has settled
+type CalibrationSession struct {
+	ID      string            `json:"id"`
+	Probe   string            `json:"probe"`
+	Side    string            `json:"side,omitempty"`
+	Field   string            `json:"field"`
+	Note    string            `json:"note,omitempty"`
+	Started time.Time         `json:"started"`
+	Steps   []CalibrationStep `json:"steps"`
+
+	// Step is the index of the next step to capture, it is the number of steps
+	// once they have all been captured
+	Step int `json:"step"`
+
+	// Armed is set once the probe is in the buffer of the step, only readings
+	// from after then are used for the point
+	Armed bool `json:"armed"`
+
+	// Reading is the latest uncalibrated reading, it is stable once the readings
+	// in the window are all within the tolerance of the probe
+	Reading *types.NullFloat `json:"reading"`
+	StdDev  float64          `json:"std_dev"`
+	Stable  bool             `json:"stable"`
+
+	// Result is the calibration worked out once all the steps have been captured,
+	// or Error is why it couldn't be
+	Result *CalibrationRecord `json:"result,omitempty"`
+	Error  string             `json:"error,omitempty"`
+
+	probe   sessionProbe
+	raw     string
+	window  []float64
+	touched time.Time
+
+	// the tips since the step was armed and when the first and last were
+	tips        int
+	first, last time.Time
+}
+
+// snapshot returns a copy of the session that is safe to hand out
+func (s *CalibrationSession) snapshot() CalibrationSession {
+	cp := *s
+	cp.Steps = append([]CalibrationStep{}, s.Steps...)
+	cp.Reading = &types.NullFloat{}
+	if s.Reading.IsValid() {
+		cp.Reading.SetValue(s.Reading.Value())
+	}
+	cp.window = nil
+	return cp
+}
+
+// observe adds the reading to the window, a missing reading empties it.  Until
+// the step is armed the reading is only shown
+func (s *CalibrationSession) observe(now time.Time, v float64, ok bool) {
+	if !ok {
+		s.Reading.SetInvalid()
+		s.reset()
+		return
+	}
+
+	if !s.Armed {
+		if !s.probe.counter {
+			s.Reading.SetValue(v)
+		}
+		return
+	}
+
+	s.Reading.SetValue(v)
+	s.window = append(s.window, v)
+	if len(s.window) > s.probe.window {
+		s.window = s.window[len(s.window)-s.probe.window:]
+	}
+
+	mean, sd := meanStdDev(s.window)
+	tolerance := s.probe.tolerance
+	if s.probe.relative {
+		tolerance *= math.Abs(mean)
+	}
+
+	s.StdDev = sd
+	s.Stable = len(s.window) == s.probe.window && s.StdDev <= tolerance
+
+	// a count is only done once it has gone up and then stopped
+	if s.probe.counter && v <= 0 {
+		s.Stable = false
+	}
+}
+
+// reset starts the window of readings for the step again
+func (s *CalibrationSession) reset() {
+	s.window = s.window[:0]
+	s.StdDev = 0
+	s.Stable = false
+	s.tips = 0
+	s.first, s.last = time.Time{}, time.Time{}
+}
+
+// rate returns how fast the bucket tipped during the step, per minute
+func (s *CalibrationSession) rate() float64 {
+	return tipRate(s.tips, s.first, s.last)
+}
+
+func meanStdDev(vals []float64) (mean, sd float64) {
+	if len(vals) == 0 {
+		return
+	}
+
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+
+	for _, v := range vals {
+		sd += (v - mean) * (v - mean)
+	}
+	sd = math.Sqrt(sd / float64(len(vals)))
+	return
+}
+
+// calibrationSessions keeps the calibration sessions that are in progress
+type calibrationSessions struct {
+	sessions map[string]*CalibrationSession
+	mu       *sync.Mutex
+}
+
+func newCalibrationSessions() *calibrationSessions {
+	return &calibrationSessions{
+		sessions: map[string]*CalibrationSession{},
+		mu:       new(sync.Mutex),
+	}
+}
+
+// Start begins a session to calibrate the probe on the side, using the default
+// buffers for the probe if none are given
+func (cs *calibrationSessions) Start(probe, side string, buffers []float64, note string) (CalibrationSession, error) {
+	sp, ok := sessionProbes[probe]
+	if !ok {
+		return CalibrationSession{}, fmt.Errorf("unknown probe %q", probe)
+	}
+
+	if sp.sided && side != "irrig" && side != "runoff" {
+		return CalibrationSession{}, fmt.Errorf("side must be irrig or runoff")
+	}
+
+	if !sp.sided {
+		side = ""
+	}
+
+	if len(buffers) == 0 {
+		buffers = sp.buffers
+	}
+
+	if len(buffers) < sp.min || len(buffers) > sp.max {
+		return CalibrationSession{}, fmt.Errorf("need between %d and %d buffers for a %s probe, got %d", sp.min, sp.max, probe, len(buffers))
+	}
+
+	now := time.Now()
+	s := &CalibrationSession{
+		ID:      fmt.Sprintf("%x", now.UnixNano()),
+		Probe:   probe,
+		Side:    side,
+		Field:   strings.Replace(sp.field, "%s", side, 1),
+		Note:    note,
+		Started: now,
+		Reading: &types.NullFloat{},
+		probe:   sp,
+		raw:     strings.Replace(sp.raw, "%s", side, 1),
+		touched: now,
+	}
+
+	for _, b := range buffers {
+		s.Steps = append(s.Steps, CalibrationStep{Buffer: b, Prompt: sessionPrompt(probe, b)})
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.sessions[s.ID] = s
+	return s.snapshot(), nil
+}
+
+// Sessions returns the sessions in progress, oldest first
+func (cs *calibrationSessions) Sessions() []CalibrationSession {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	sessions := []CalibrationSession{}
+	for _, s := range cs.sessions {
+		sessions = append(sessions, s.snapshot())
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions
+}
+
+// Get returns the session with the given ID
+func (cs *calibrationSessions) Get(id string) (CalibrationSession, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	s, ok := cs.sessions[id]
+	if !ok {
+		return CalibrationSession{}, ErrSessionNotFound
+	}
+
+	s.touched = time.Now()
+	return s.snapshot(), nil
+}
+
+// Ready arms the current step once the probe is in its buffer, the readings
+// taken before then are thrown away
+func (cs *calibrationSessions) Ready(id string) (CalibrationSession, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	s, ok := cs.sessions[id]
+	if !ok {
+		return CalibrationSession{}, ErrSessionNotFound
+	}
+	s.touched = time.Now()
+
+	if s.Step >= len(s.Steps) {
+		return s.snapshot(), ErrSessionCaptured
+	}
+
+	s.reset()
+	s.Armed = true
+	if s.probe.counter {
+		s.Reading.SetInvalid()
+	}
+
+	return s.snapshot(), nil
+}
+
+// Capture takes the average of the readings in the window as the point for the
+// current step, the step has to be armed and unless force is set the reading
+// has to be stable.  Once every step has been captured the calibration is
+// worked out from the points
+func (cs *calibrationSessions) Capture(id string, force bool) (CalibrationSession, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	s, ok := cs.sessions[id]
+	if !ok {
+		return CalibrationSession{}, ErrSessionNotFound
+	}
+	s.touched = time.Now()
+
+	switch {
+	case s.Step >= len(s.Steps):
+		return s.snapshot(), ErrSessionCaptured
+	case !s.Armed:
+		return s.snapshot(), ErrSessionNotArmed
+	case len(s.window) == 0:
+		return s.snapshot(), ErrSessionNoValue
+	case !s.Stable && !force:
+		return s.snapshot(), ErrSessionUnstable
+	}
+
+	step := &s.Steps[s.Step]
+	step.Reading, _ = meanStdDev(s.window)
+	if s.probe.counter {
+		step.Reading = s.Reading.Value()
+		step.Rate = s.rate()
+	}
+	step.Captured = true
+	s.Step++
+
+	// the probe is moved to the next buffer so the step has to be armed again
+	s.reset()
+	s.Armed = false
+
+	if s.Step < len(s.Steps) {
+		return s.snapshot(), nil
+	}
+
+	points := []CalibrationPoint{}
+	for _, st := range s.Steps {
+		points = append(points, CalibrationPoint{Buffer: st.Buffer, Reading: st.Reading, Rate: st.Rate})
+	}
+
+	rec, err := calibrationFromPoints(s.Field, points)
+	if err != nil {
+		s.Error = err.Error()
+		return s.snapshot(), nil
+	}
+
+	rec.Note = s.Note
+	s.Result = &rec
+	return s.snapshot(), nil
+}
+
+// Cancel ends the session without saving anything
+func (cs *calibrationSessions) Cancel(id string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, ok := cs.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+
+	delete(cs.sessions, id)
+	return nil
+}
+
+// Observe gives the latest readings to the sessions and drops any sessions
+// that haven't been looked at for a while
+func (cs *calibrationSessions) Observe(now time.Time, values map[string]float64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for id, s := range cs.sessions {
+		if now.Sub(s.touched) > s.probe.timeout {
+			delete(cs.sessions, id)
+			continue
+		}
+
+		if s.Step >= len(s.Steps) {
+			continue
+		}
+
+		if s.probe.counter {
+			s.observe(now, float64(s.tips), true)
+			continue
+		}
+
+		v, ok := values[s.raw]
+		s.observe(now, v, ok)
+	}
+}
+
+// Tip hands a tip of the tipping bucket on the side to the session calibrating
+// it, false is returned when there is no session with an armed step and so the
+// tip is from an irrigation
+func (cs *calibrationSessions) Tip(side string, t time.Time) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, s := range cs.sessions {
+		if s.Probe != ProbeTB || s.Side != side || !s.Armed || s.Step >= len(s.Steps) {
+			continue
+		}
+
+		s.tips++
+		if s.first.IsZero() {
+			s.first = t
+		}
+		s.last = t
+		return true
+	}
+
+	return false
+}
+
+// CommitCalibrationSession saves the calibration worked out by the session
+// and ends it, the saved record is returned
+func (mdr *Minder) CommitCalibrationSession(id, source string) (CalibrationRecord, error) {
+	s, err := mdr.sessions.Get(id)
+	if err != nil {
+		return CalibrationRecord{}, err
+	}
+
+	if s.Error != "" {
+		return CalibrationRecord{}, fmt.Errorf("%s", s.Error)
+	}
+
+	if s.Result == nil {
+		return CalibrationRecord{}, ErrSessionNotReady
+	}
+
+	rec := *s.Result
+	rec.Source = source
+	if rec.Source == "" {
+		rec.Source = CalibrationSourceWizard
+	}
+
+	if rec, err = mdr.Calibrate(rec); err != nil {
+		return rec, err
+	}
+
+	mdr.sessions.Cancel(id)
+	return rec, nil
+}
@@ -0,0 +1,351 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCalibrationSessions(t *testing.T) {
+	Convey("given a minder with calibration sessions", t, func() {
+		jdb := newTestDB(t)
+
+		n, err := newNotifier(jdb.db, nil)
+		So(err, ShouldBeNil)
+
+		mdr := &Minder{
+			tr:       &Translater{jdb},
+			sessions: newCalibrationSessions(),
+			notifier: n,
+			stream:   newStream(),
+			errors:   newErrorStore(),
+		}
+
+		feed := func(field string, vals ...float64) {
+			for _, v := range vals {
+				mdr.sessions.Observe(time.Now(), map[string]float64{field: v})
+			}
+		}
+
+		ready := func(id string) {
+			_, err := mdr.sessions.Ready(id)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("sessions for unknown probes or sides should be refused", func() {
+			_, err := mdr.sessions.Start("orp", "irrig", nil, "")
+			So(err, ShouldNotBeNil)
+
+			_, err = mdr.sessions.Start(ProbePH, "", nil, "")
+			So(err, ShouldNotBeNil)
+
+			_, err = mdr.sessions.Start(ProbePH, "irrig", []float64{7}, "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when a pH session is started", func() {
+			s, err := mdr.sessions.Start(ProbePH, "runoff", []float64{7, 4, 10}, "new probe")
+			So(err, ShouldBeNil)
+			So(s.Field, ShouldEqual, "runoff_ph")
+			So(s.Steps, ShouldHaveLength, 3)
+			So(s.Steps[0].Prompt, ShouldContainSubstring, "pH7")
+
+			Convey("the readings from the other side should be ignored", func() {
+				feed("irrig_ph_raw", 7, 7, 7)
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Reading.IsValid(), ShouldBeFalse)
+			})
+
+			Convey("the point should not be captured before the step is ready", func() {
+				feed("runoff_ph_raw", 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1)
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Reading.Value(), ShouldEqual, 7.1)
+				So(s.Armed, ShouldBeFalse)
+				So(s.Stable, ShouldBeFalse)
+
+				_, err := mdr.sessions.Capture(s.ID, true)
+				So(err, ShouldEqual, ErrSessionNotArmed)
+			})
+
+			Convey("the readings from before the step was ready should not be used", func() {
+				feed("runoff_ph_raw", 7.1, 7.1, 7.1, 7.1, 7.1)
+				ready(s.ID)
+				feed("runoff_ph_raw", 7.1, 7.1, 7.1, 7.1, 7.1)
+
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Armed, ShouldBeTrue)
+				So(s.Stable, ShouldBeFalse)
+
+				Convey("so a jump in the reading should start the window again", func() {
+					feed("runoff_ph_raw", 4.1, 4.1, 4.1, 4.1, 4.1)
+					s, _ := mdr.sessions.Get(s.ID)
+					So(s.Stable, ShouldBeFalse)
+
+					feed("runoff_ph_raw", 4.1, 4.1, 4.1, 4.1, 4.1)
+					s, err := mdr.sessions.Capture(s.ID, false)
+					So(err, ShouldBeNil)
+					So(s.Steps[0].Reading, ShouldAlmostEqual, 4.1)
+					So(s.Armed, ShouldBeFalse)
+				})
+			})
+
+			Convey("the reading should not be stable until the window is full", func() {
+				ready(s.ID)
+				feed("runoff_ph_raw", 7.1, 7.1, 7.1)
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Reading.Value(), ShouldEqual, 7.1)
+				So(s.Stable, ShouldBeFalse)
+
+				_, err := mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldEqual, ErrSessionUnstable)
+			})
+
+			Convey("a reading that is moving around should not be stable", func() {
+				ready(s.ID)
+				feed("runoff_ph_raw", 6.5, 6.7, 6.9, 7.1, 6.5, 6.7, 6.9, 7.1, 6.5, 6.7)
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Stable, ShouldBeFalse)
+				So(s.StdDev, ShouldBeGreaterThan, 0.05)
+			})
+
+			Convey("losing the reading should start the window again", func() {
+				ready(s.ID)
+				feed("runoff_ph_raw", 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1)
+				mdr.sessions.Observe(time.Now(), map[string]float64{})
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Stable, ShouldBeFalse)
+				So(s.Reading.IsValid(), ShouldBeFalse)
+
+				_, err := mdr.sessions.Capture(s.ID, true)
+				So(err, ShouldEqual, ErrSessionNoValue)
+			})
+
+			Convey("and the readings settle in each buffer", func() {
+				ready(s.ID)
+				feed("runoff_ph_raw", 7.0, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.1, 7.2)
+				s, err := mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldBeNil)
+				So(s.Step, ShouldEqual, 1)
+				So(s.Steps[0].Captured, ShouldBeTrue)
+				So(s.Steps[0].Reading, ShouldAlmostEqual, 7.1)
+
+				Convey("the next step should have to be ready again", func() {
+					_, err := mdr.sessions.Capture(s.ID, true)
+					So(err, ShouldEqual, ErrSessionNotArmed)
+				})
+
+				Convey("the next step should have to settle again", func() {
+					ready(s.ID)
+					feed("runoff_ph_raw", 4.1, 4.1)
+					_, err := mdr.sessions.Capture(s.ID, false)
+					So(err, ShouldEqual, ErrSessionUnstable)
+				})
+
+				ready(s.ID)
+				feed("runoff_ph_raw", 4.1, 4.1, 4.1, 4.1, 4.1, 4.1, 4.1, 4.1, 4.1, 4.1)
+				_, err = mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldBeNil)
+
+				Convey("committing before the last step should fail", func() {
+					_, err := mdr.CommitCalibrationSession(s.ID, "")
+					So(err, ShouldEqual, ErrSessionNotReady)
+				})
+
+				ready(s.ID)
+				feed("runoff_ph_raw", 10.1, 10.1, 10.1, 10.1, 10.1, 10.1, 10.1, 10.1, 10.1, 10.1)
+				s, err = mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldBeNil)
+
+				Convey("the calibration should be worked out", func() {
+					So(s.Error, ShouldBeEmpty)
+					So(s.Result, ShouldNotBeNil)
+					So(s.Result.Scale, ShouldAlmostEqual, 1)
+					So(s.Result.Offset, ShouldAlmostEqual, -0.1)
+					So(s.Result.Points, ShouldHaveLength, 3)
+
+					_, err := mdr.sessions.Capture(s.ID, true)
+					So(err, ShouldEqual, ErrSessionCaptured)
+				})
+
+				Convey("committing should save it and end the session", func() {
+					rec, err := mdr.CommitCalibrationSession(s.ID, "")
+					So(err, ShouldBeNil)
+					So(rec.Source, ShouldEqual, CalibrationSourceWizard)
+					So(rec.Note, ShouldEqual, "new probe")
+
+					c, err := mdr.tr.getCalibration("runoff_ph")
+					So(err, ShouldBeNil)
+					So(c.Offset, ShouldAlmostEqual, -0.1)
+
+					_, err = mdr.sessions.Get(s.ID)
+					So(err, ShouldEqual, ErrSessionNotFound)
+				})
+			})
+
+			Convey("a probe that fails validation should not be saved", func() {
+				for _, v := range []float64{7, 5.5, 8.5} {
+					ready(s.ID)
+					feed("runoff_ph_raw", v, v, v, v, v, v, v, v, v, v)
+					s, err = mdr.sessions.Capture(s.ID, false)
+					So(err, ShouldBeNil)
+				}
+
+				So(s.Result, ShouldBeNil)
+				So(s.Error, ShouldContainSubstring, "slope")
+
+				_, err := mdr.CommitCalibrationSession(s.ID, "")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("a session that isn't looked at should be dropped", func() {
+				mdr.sessions.Observe(time.Now().Add(sessionTimeout+time.Minute), map[string]float64{})
+				_, err := mdr.sessions.Get(s.ID)
+				So(err, ShouldEqual, ErrSessionNotFound)
+			})
+
+			Convey("cancelling should end the session", func() {
+				So(mdr.sessions.Cancel(s.ID), ShouldBeNil)
+				So(mdr.sessions.Sessions(), ShouldBeEmpty)
+				So(mdr.sessions.Cancel(s.ID), ShouldEqual, ErrSessionNotFound)
+			})
+		})
+
+		Convey("when a tipping bucket session is started", func() {
+			s, err := mdr.sessions.Start(ProbeTB, "irrig", []float64{500, 520}, "")
+			So(err, ShouldBeNil)
+			So(s.Field, ShouldEqual, "irrig_volume")
+			So(s.Steps[0].Prompt, ShouldContainSubstring, "500 mL")
+
+			t0 := time.Now()
+			pour := func(tips int, every time.Duration) {
+				for i := 0; i < tips; i++ {
+					t0 = t0.Add(every)
+					So(mdr.sessions.Tip("irrig", t0), ShouldBeTrue)
+					mdr.sessions.Observe(t0, map[string]float64{})
+				}
+			}
+
+			wait := func(n int) {
+				for i := 0; i < n; i++ {
+					t0 = t0.Add(time.Second)
+					mdr.sessions.Observe(t0, map[string]float64{})
+				}
+			}
+
+			Convey("the tips of the other bucket should not be taken", func() {
+				So(mdr.sessions.Tip("runoff", t0), ShouldBeFalse)
+			})
+
+			Convey("the tips before the step is ready should be irrigation", func() {
+				So(mdr.sessions.Tip("irrig", t0), ShouldBeFalse)
+				ready(s.ID)
+				s, _ := mdr.sessions.Get(s.ID)
+				So(s.Reading.IsValid(), ShouldBeFalse)
+			})
+
+			Convey("an armed session that isn't looked at should be dropped quickly", func() {
+				ready(s.ID)
+				mdr.sessions.Observe(time.Now().Add(tbSessionTimeout+time.Second), map[string]float64{})
+				So(mdr.sessions.Tip("irrig", t0), ShouldBeFalse)
+			})
+
+			Convey("it should not be done before the bucket has tipped", func() {
+				ready(s.ID)
+				wait(tbSessionWindow + 5)
+				_, err := mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldEqual, ErrSessionUnstable)
+			})
+
+			Convey("and pours are made at different rates", func() {
+				// the clock restarts with each step so the pours stay inside the timeout
+				ready(s.ID)
+				t0 = time.Now()
+				pour(50, time.Second)
+
+				Convey("it should not be done while the bucket is tipping", func() {
+					s, _ := mdr.sessions.Get(s.ID)
+					So(s.Reading.Value(), ShouldEqual, 50)
+					So(s.Stable, ShouldBeFalse)
+				})
+
+				wait(tbSessionWindow)
+				s, err := mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldBeNil)
+				So(s.Steps[0].Reading, ShouldEqual, 50)
+				So(s.Steps[0].Rate, ShouldAlmostEqual, 60, 0.01)
+
+				ready(s.ID)
+				t0 = time.Now()
+				pour(50, time.Second/2)
+				wait(tbSessionWindow)
+				s, err = mdr.sessions.Capture(s.ID, false)
+				So(err, ShouldBeNil)
+				So(s.Steps[1].Reading, ShouldEqual, 50)
+				So(s.Steps[1].Rate, ShouldAlmostEqual, 120, 0.01)
+
+				Convey("the volume per tip should have a rate correction", func() {
+					So(s.Error, ShouldBeEmpty)
+					So(s.Result.Scale, ShouldAlmostEqual, 10.2)
+					So(s.Result.RateRef, ShouldAlmostEqual, 90, 0.01)
+					So(s.Result.RateCoef, ShouldAlmostEqual, 0.4/60)
+				})
+			})
+
+			Convey("once it is cancelled the tips should be irrigation again", func() {
+				ready(s.ID)
+				So(mdr.sessions.Cancel(s.ID), ShouldBeNil)
+				So(mdr.sessions.Tip("irrig", t0), ShouldBeFalse)
+			})
+		})
+
+		Convey("an EC session should settle relative to the buffer", func() {
+			s, err := mdr.sessions.Start(ProbeEC, "irrig", nil, "")
+			So(err, ShouldBeNil)
+
+			ready(s.ID)
+			feed("irrig_ec_raw", 1.39, 1.43, 1.39, 1.43, 1.39, 1.43, 1.39, 1.43, 1.39, 1.43)
+			s, _ = mdr.sessions.Get(s.ID)
+			So(s.Stable, ShouldBeFalse)
+
+			feed("irrig_ec_raw", 1.41, 1.42, 1.41, 1.42, 1.41, 1.42, 1.41, 1.42, 1.41, 1.42)
+			s, err = mdr.sessions.Capture(s.ID, false)
+			So(err, ShouldBeNil)
+
+			ready(s.ID)
+			feed("irrig_ec_raw", 12.8, 12.9, 12.8, 12.9, 12.8, 12.9, 12.8, 12.9, 12.8, 12.9)
+			s, err = mdr.sessions.Capture(s.ID, false)
+			So(err, ShouldBeNil)
+			So(s.Step, ShouldEqual, 2)
+		})
+
+		Convey("a moisture session should not need a side", func() {
+			s, err := mdr.sessions.Start(ProbeMoisture, "", nil, "")
+			So(err, ShouldBeNil)
+			So(s.Field, ShouldEqual, "moisture")
+
+			ready(s.ID)
+			feed("moisture_voltage", 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5)
+			_, err = mdr.sessions.Capture(s.ID, false)
+			So(err, ShouldBeNil)
+
+			ready(s.ID)
+			feed("moisture_voltage", 2.5, 2.5, 2.5, 2.5, 2.5, 2.5, 2.5, 2.5, 2.5, 2.5)
+			s, err = mdr.sessions.Capture(s.ID, false)
+			So(err, ShouldBeNil)
+			So(s.Result, ShouldNotBeNil)
+			So(s.Result.calibration().Transform(2.5), ShouldAlmostEqual, 100)
+			So(s.Result.calibration().Transform(1.5), ShouldAlmostEqual, 50)
+		})
+	})
+}
+
+func TestSessionValues(t *testing.T) {
+	Convey("the calibration sessions should get the raw pH before it is rounded", t, func() {
+		mdr := &Minder{Readings: newReadings(), phUnrounded: map[string]float64{"irrig_ph_raw": 6.93}}
+		mdr.Readings.IrrigPHRaw = 6.9
+
+		So(mdr.sessionValues()["irrig_ph_raw"], ShouldEqual, 6.93)
+		So(mdr.Readings.Values()["irrig_ph_raw"], ShouldEqual, 6.9)
+	})
+}
@@ -0,0 +1,595 @@
+package openminder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autogrow/openminder/calib"
+	"github.com/boltdb/bolt"
+)
+
+// The sources that a calibration can come from
+const (
+	CalibrationSourceAPI      = "api"
+	CalibrationSourceOmcli    = "omcli"
+	CalibrationSourceWizard   = "wizard"
+	CalibrationSourceRollback = "rollback"
+	CalibrationSourceImport   = "import"
+
+	// CalibrationSourcePrevious marks a calibration that was in use before the
+	// history was kept, so it can still be rolled back to
+	CalibrationSourcePrevious = "previous"
+)
+
+// ecProbeKeyPrefix is put in front of the serial of an EC probe to give the key
+// that its calibration is stored under
+const ecProbeKeyPrefix = "ec_probe:"
+
+// calibrationHistoryBucket holds a bucket of calibration records for each field
+var calibrationHistoryBucket = []byte("calibration_history")
+
+// ErrCalibrationNotFound is returned when a calibration is not in the history
+var ErrCalibrationNotFound = fmt.Errorf("calibration not found")
+
+// ErrPointsNotSupported is returned when a field can't be calibrated from points
+var ErrPointsNotSupported = fmt.Errorf("calibrating from points is not supported for that field")
+
+// CalibrationPoint is a point used to calibrate a reading, such as the reading
+// taken with the probe in a buffer solution
+type CalibrationPoint struct {
+	// Buffer is the known value, e.g. the pH of the buffer solution
+	Buffer float64 `json:"buffer"`
+
+	// Reading is the uncalibrated reading that was taken
+	Reading float64 `json:"reading"`
+
+	// Rate is how fast a tipping bucket tipped in tips per minute
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// CalibrationRecord is a calibration in the history of a field
+type CalibrationRecord struct {
+	ID         uint64             `json:"id"`
+	Field      string             `json:"field"`
+	Time       time.Time          `json:"time"`
+	Model      string             `json:"model,omitempty"`
+	Scale      float64            `json:"scale"`
+	Offset     float64            `json:"offset"`
+	Points     []CalibrationPoint `json:"points,omitempty"`
+	Source     string             `json:"source"`
+	Note       string             `json:"note,omitempty"`
+	RollbackOf uint64             `json:"rollback_of,omitempty"`
+
+	// Probe is the serial of the EC probe the calibration belongs to, so that it
+	// follows the probe if it is moved to the other side
+	Probe string `json:"probe,omitempty"`
+
+	// SlopePercent and OffsetMV describe the health of a pH probe when it was
+	// calibrated from buffer points
+	SlopePercent float64 `json:"slope_percent,omitempty"`
+	OffsetMV     float64 `json:"offset_mv,omitempty"`
+
+	// RateRef and RateCoef are the rate correction of a tipping bucket, see calib.TBResult
+	RateRef  float64 `json:"rate_ref,omitempty"`
+	RateCoef float64 `json:"rate_coef,omitempty"`
+
+	// Table and Coeffs are used by the table and polynomial models, see calibration
+	Table  []CalibrationPoint `json:"table,omitempty"`
+	Coeffs []float64          `json:"coeffs,omitempty"`
+}
+
+func (rec CalibrationRecord) calibration() calibration {
+	return calibration{
+		Model:    rec.Model,
+		Scale:    rec.Scale,
+		Offset:   rec.Offset,
+		Table:    rec.Table,
+		Coeffs:   rec.Coeffs,
+		RateRef:  rec.RateRef,
+		RateCoef: rec.RateCoef,
+	}
+}
+
+// key returns the key that the calibration is stored under
+func (rec CalibrationRecord) key() string {
+	if rec.Probe != "" {
+		return ecProbeKey(rec.Probe)
+	}
+
+	return rec.Field
+}
+
+func ecProbeKey(sn string) string {
+	return ecProbeKeyPrefix + sn
+}
+
+// calibrationFromPoints works out the calibration for the field from the points
+// taken with the probe in buffer solutions
+func calibrationFromPoints(field string, points []CalibrationPoint) (CalibrationRecord, error) {
+	rec := CalibrationRecord{Field: field, Model: defaultCalibrationModel(field), Points: points}
+	if !isTranslatable(field) {
+		return rec, ErrNotTranslatable
+	}
+
+	pts := make([]calib.Point, len(points))
+	for i, p := range points {
+		pts[i] = calib.Point{Buffer: p.Buffer, Reading: p.Reading}
+	}
+
+	switch field {
+	case "irrig_ph", "runoff_ph":
+		// the raw pH readings assume the circuit's slope in mV per pH
+		res, err := calib.PHMultiPoint(pts, phPerVolt*1000)
+		if err != nil {
+			return rec, err
+		}
+
+		rec.Scale = res.Scale
+		rec.Offset = res.Offset
+		rec.SlopePercent = res.SlopePercent
+		rec.OffsetMV = res.OffsetMV
+		return rec, nil
+
+	case "irrig_ec", "runoff_ec":
+		var err error
+		switch len(points) {
+		case 1:
+			rec.Scale, rec.Offset, err = calib.EC(points[0].Buffer, points[0].Reading)
+		case 2:
+			rec.Scale, rec.Offset, err = calib.ECTwoPoint(pts[0], pts[1])
+		default:
+			err = fmt.Errorf("need 1 or 2 buffers, got %d", len(points))
+		}
+
+		return rec, err
+
+	case "irrig_volume", "runoff_volume":
+		// the buffers are the volumes poured and the readings the tips counted
+		pours := make([]calib.Pour, len(points))
+		for i, p := range points {
+			pours[i] = calib.Pour{Volume: p.Buffer, Tips: p.Reading, Rate: p.Rate}
+		}
+
+		res, err := calib.TippingBucket(pours)
+		if err != nil {
+			return rec, err
+		}
+
+		rec.Scale = res.MLPerTip
+		rec.RateRef = res.RateRef
+		rec.RateCoef = res.RateCoef
+		return rec, nil
+
+	case "moisture":
+		// the dry and wet points are 0% and 100%
+		if len(points) != 2 || points[0].Buffer != 0 || points[1].Buffer != 100 {
+			return rec, fmt.Errorf("need a dry (0) and a wet (100) point")
+		}
+
+		var err error
+		rec.Scale, rec.Offset, err = calib.Moisture(points[0].Reading, points[1].Reading)
+		return rec, err
+	}
+
+	return rec, ErrPointsNotSupported
+}
+
+func isTranslatable(field string) bool {
+	for _, f := range translatableFields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCalibrationKey returns true if the key is one that calibrations are stored under
+func isCalibrationKey(key string) bool {
+	return isTranslatable(key) || (strings.HasPrefix(key, ecProbeKeyPrefix) && len(key) > len(ecProbeKeyPrefix))
+}
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// Calibrate saves the calibration as the one to use for its field and adds it to
+// the history, the saved record is returned
+func (tr *Translater) Calibrate(rec CalibrationRecord) (CalibrationRecord, error) {
+	err := tr.jdb.db.Update(func(tx *bolt.Tx) (err error) {
+		rec, err = tr.calibrate(tx, rec)
+		return err
+	})
+
+	return rec, err
+}
+
+// calibrate does the work of Calibrate in the transaction
+func (tr *Translater) calibrate(tx *bolt.Tx, rec CalibrationRecord) (CalibrationRecord, error) {
+	// a calibration kept by EC probe doesn't have to be for a side
+	if (rec.Field != "" || rec.Probe == "") && !isTranslatable(rec.Field) {
+		return rec, ErrNotTranslatable
+	}
+
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	if rec.Source == "" {
+		rec.Source = CalibrationSourceAPI
+	}
+
+	if rec.Model == "" {
+		rec.Model = defaultCalibrationModel(rec.key())
+	}
+
+	// the volumes are the tips counted times the volume of a tip, so they can
+	// only be worked out with a linear model
+	if (rec.Field == "irrig_volume" || rec.Field == "runoff_volume") && rec.Model != CalibrationModelLinear {
+		return rec, InvalidCalibrationError{rec.Model, "the volumes need a linear model"}
+	}
+
+	if rec.Model == CalibrationModelTable {
+		rec.Table = append([]CalibrationPoint{}, rec.Table...)
+		sort.Slice(rec.Table, func(i, j int) bool { return rec.Table[i].Reading < rec.Table[j].Reading })
+	}
+
+	if err := rec.calibration().validate(); err != nil {
+		return rec, err
+	}
+
+	b, err := tr.historyBucket(tx, rec)
+	if err != nil {
+		return rec, err
+	}
+
+	if rec.ID, err = b.NextSequence(); err != nil {
+		return rec, err
+	}
+
+	if err := putCalibrationRecord(b, rec); err != nil {
+		return rec, err
+	}
+
+	data, err := json.Marshal(rec.calibration())
+	if err != nil {
+		return rec, err
+	}
+
+	return rec, tx.Bucket(tr.jdb.bucket).Put([]byte(rec.key()), data)
+}
+
+// historyBucket returns the history bucket for the key of the record, when the
+// history is new it starts with the calibration from before there was one
+func (tr *Translater) historyBucket(tx *bolt.Tx, rec CalibrationRecord) (*bolt.Bucket, error) {
+	hist, err := tx.CreateBucketIfNotExists(calibrationHistoryBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := hist.CreateBucketIfNotExists([]byte(rec.key()))
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Stats().KeyN > 0 {
+		return b, nil
+	}
+
+	data := tx.Bucket(tr.jdb.bucket).Get([]byte(rec.key()))
+	if data == nil {
+		return b, nil
+	}
+
+	prev := calibration{}
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return b, nil
+	}
+
+	return b, putCalibrationRecord(b, CalibrationRecord{
+		Field:    rec.Field,
+		Probe:    rec.Probe,
+		Model:    prev.Model,
+		Scale:    prev.Scale,
+		Offset:   prev.Offset,
+		Table:    prev.Table,
+		Coeffs:   prev.Coeffs,
+		RateRef:  prev.RateRef,
+		RateCoef: prev.RateCoef,
+		Source:   CalibrationSourcePrevious,
+	})
+}
+
+func putCalibrationRecord(b *bolt.Bucket, rec CalibrationRecord) (err error) {
+	if rec.ID == 0 {
+		if rec.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(idKey(rec.ID), data)
+}
+
+// CalibrationHistory returns the calibrations that have been stored under the
+// key, oldest first
+func (tr *Translater) CalibrationHistory(key string) ([]CalibrationRecord, error) {
+	if !isCalibrationKey(key) {
+		return nil, ErrNotTranslatable
+	}
+
+	recs := []CalibrationRecord{}
+	err := tr.jdb.db.View(func(tx *bolt.Tx) error {
+		hist := tx.Bucket(calibrationHistoryBucket)
+		if hist == nil {
+			return nil
+		}
+
+		b := hist.Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+
+		var err error
+		recs, err = historyRecords(b)
+		return err
+	})
+
+	return recs, err
+}
+
+// historyRecords returns the calibration records in the history bucket, oldest first
+func historyRecords(b *bolt.Bucket) ([]CalibrationRecord, error) {
+	recs := []CalibrationRecord{}
+	err := b.ForEach(func(k, v []byte) error {
+		rec := CalibrationRecord{}
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return err
+		}
+
+		recs = append(recs, rec)
+		return nil
+	})
+
+	return recs, err
+}
+
+// Rollback makes the calibration with the given ID in the history of the key
+// the one to use again, the rollback is added to the history
+func (tr *Translater) Rollback(key string, id uint64, note string) (CalibrationRecord, error) {
+	recs, err := tr.CalibrationHistory(key)
+	if err != nil {
+		return CalibrationRecord{}, err
+	}
+
+	for _, rec := range recs {
+		if rec.ID != id {
+			continue
+		}
+
+		return tr.Calibrate(CalibrationRecord{
+			Field:      rec.Field,
+			Probe:      rec.Probe,
+			Model:      rec.Model,
+			Scale:      rec.Scale,
+			Offset:     rec.Offset,
+			Points:     rec.Points,
+			Source:     CalibrationSourceRollback,
+			Note:       note,
+			RollbackOf: rec.ID,
+
+			SlopePercent: rec.SlopePercent,
+			OffsetMV:     rec.OffsetMV,
+			RateRef:      rec.RateRef,
+			RateCoef:     rec.RateCoef,
+			Table:        rec.Table,
+			Coeffs:       rec.Coeffs,
+		})
+	}
+
+	return CalibrationRecord{}, ErrCalibrationNotFound
+}
+
+// calibrationKeys returns the keys that there are calibrations or a history
+// stored under, sorted
+func (tr *Translater) calibrationKeys() ([]string, error) {
+	seen := map[string]bool{}
+	err := tr.jdb.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(tr.jdb.bucket).ForEach(func(k, v []byte) error {
+			seen[string(k)] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		hist := tx.Bucket(calibrationHistoryBucket)
+		if hist == nil {
+			return nil
+		}
+
+		return hist.ForEach(func(k, v []byte) error {
+			seen[string(k)] = true
+			return nil
+		})
+	})
+
+	keys := []string{}
+	for k := range seen {
+		if isCalibrationKey(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, err
+}
+
+// importCalibrations saves the calibrations that are different to the ones in
+// use in the same way as Calibrate, with the import as the source.  The history
+// that comes with a calibration is added to the end of the one stored under its
+// key, leaving out the records that are already in it
+func (tr *Translater) importCalibrations(cals []BundleCalibration) ([]CalibrationRecord, error) {
+	saved := []CalibrationRecord{}
+	err := tr.jdb.db.Update(func(tx *bolt.Tx) error {
+		for _, bc := range cals {
+			rec := bc.record()
+			b, err := tr.historyBucket(tx, rec)
+			if err != nil {
+				return err
+			}
+
+			local, err := historyRecords(b)
+			if err != nil {
+				return err
+			}
+
+			// the imported records get new IDs, so rollbacks are pointed at the
+			// new IDs or the IDs of the same records in the local history
+			added, ids := newHistoryRecords(local, bc.History)
+			for _, r := range added {
+				old := r.ID
+				if r.ID, err = b.NextSequence(); err != nil {
+					return err
+				}
+				ids[old] = r.ID
+				r.RollbackOf = ids[r.RollbackOf]
+
+				if err := putCalibrationRecord(b, r); err != nil {
+					return err
+				}
+			}
+
+			cur := calibration{}
+			if data := tx.Bucket(tr.jdb.bucket).Get([]byte(bc.Key)); data != nil && json.Unmarshal(data, &cur) == nil {
+				if cur.Model == "" {
+					cur.Model = defaultCalibrationModel(bc.Key)
+				}
+
+				if jsonEqual(cur, bc.calibration) {
+					continue
+				}
+			}
+
+			if rec, err = tr.calibrate(tx, rec); err != nil {
+				return err
+			}
+
+			saved = append(saved, rec)
+		}
+
+		return nil
+	})
+
+	return saved, err
+}
+
+// newHistoryRecords returns the imported records that aren't already in the
+// local history, records are the same if they only differ by their IDs.  The
+// IDs of the imported records that are already in it are mapped to their local IDs
+func newHistoryRecords(local, imported []CalibrationRecord) ([]CalibrationRecord, map[uint64]uint64) {
+	sameness := func(rec CalibrationRecord) string {
+		rec.ID = 0
+		rec.RollbackOf = 0
+		data, _ := json.Marshal(rec)
+		return string(data)
+	}
+
+	seen := map[string]uint64{}
+	for _, rec := range local {
+		seen[sameness(rec)] = rec.ID
+	}
+
+	recs := []CalibrationRecord{}
+	ids := map[uint64]uint64{}
+	for _, rec := range imported {
+		s := sameness(rec)
+		if id, ok := seen[s]; ok {
+			ids[rec.ID] = id
+			continue
+		}
+
+		seen[s] = rec.ID
+		recs = append(recs, rec)
+	}
+
+	return recs, ids
+}
+
+// migrateECCalibrations moves the EC calibrations that were stored by side,
+// along with their history, to the probes that are on those sides.  Probes that
+// already have a calibration of their own keep it
+func (tr *Translater) migrateECCalibrations(probes map[string]string) error {
+	for field, sn := range probes {
+		if sn == "" {
+			continue
+		}
+
+		err := tr.jdb.db.Update(func(tx *bolt.Tx) error {
+			cur := tx.Bucket(tr.jdb.bucket)
+			key := []byte(ecProbeKey(sn))
+			if cur.Get(key) != nil {
+				return nil
+			}
+
+			data := cur.Get([]byte(field))
+			if data == nil {
+				return nil
+			}
+
+			if err := cur.Put(key, data); err != nil {
+				return err
+			}
+
+			if err := cur.Delete([]byte(field)); err != nil {
+				return err
+			}
+
+			hist := tx.Bucket(calibrationHistoryBucket)
+			if hist == nil || hist.Bucket([]byte(field)) == nil {
+				return nil
+			}
+
+			old := hist.Bucket([]byte(field))
+			b, err := hist.CreateBucketIfNotExists(key)
+			if err != nil {
+				return err
+			}
+
+			err = old.ForEach(func(k, v []byte) error {
+				rec := CalibrationRecord{}
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+
+				rec.Probe = sn
+				return putCalibrationRecord(b, rec)
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := b.SetSequence(old.Sequence()); err != nil {
+				return err
+			}
+
+			return hist.DeleteBucket([]byte(field))
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
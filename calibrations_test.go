@@ -0,0 +1,359 @@
+package openminder
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/autogrow/openminder/calib"
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCalibrationHistory(t *testing.T) {
+	Convey("given a translater with a calibration from before the history was kept", t, func() {
+		jdb := newTestDB(t)
+
+		tr := &Translater{jdb}
+		So(jdb.Set("irrig_ph", calibration{Scale: 1.1, Offset: 0.2}), ShouldBeNil)
+
+		Convey("when a new calibration is saved", func() {
+			rec, err := tr.Calibrate(CalibrationRecord{
+				Field:  "irrig_ph",
+				Scale:  0.9,
+				Offset: -0.1,
+				Points: []CalibrationPoint{{Buffer: 7, Reading: 7.1}, {Buffer: 4, Reading: 4.3}},
+				Source: CalibrationSourceOmcli,
+				Note:   "new probe",
+			})
+			So(err, ShouldBeNil)
+
+			Convey("it should be used for translating", func() {
+				v, err := tr.Translate("irrig_ph", 10)
+				So(err, ShouldBeNil)
+				So(v, ShouldAlmostEqual, 8.9)
+			})
+
+			Convey("the history should have the old and new calibrations", func() {
+				recs, err := tr.CalibrationHistory("irrig_ph")
+				So(err, ShouldBeNil)
+				So(recs, ShouldHaveLength, 2)
+
+				So(recs[0].ID, ShouldEqual, 1)
+				So(recs[0].Scale, ShouldEqual, 1.1)
+				So(recs[0].Source, ShouldEqual, CalibrationSourcePrevious)
+
+				So(recs[1].ID, ShouldEqual, rec.ID)
+				So(recs[1].ID, ShouldEqual, 2)
+				So(recs[1].Time.Equal(rec.Time), ShouldBeTrue)
+				So(recs[1].Points, ShouldHaveLength, 2)
+				So(recs[1].Note, ShouldEqual, "new probe")
+				So(recs[1].Time.IsZero(), ShouldBeFalse)
+			})
+
+			Convey("and it is rolled back to the old one", func() {
+				rb, err := tr.Rollback("irrig_ph", 1, "bad buffer")
+				So(err, ShouldBeNil)
+
+				Convey("the old calibration should be used again", func() {
+					c, err := tr.getCalibration("irrig_ph")
+					So(err, ShouldBeNil)
+					So(c, ShouldResemble, calibration{Model: CalibrationModelLinear, Scale: 1.1, Offset: 0.2})
+				})
+
+				Convey("the rollback should be in the history", func() {
+					recs, _ := tr.CalibrationHistory("irrig_ph")
+					So(recs, ShouldHaveLength, 3)
+					So(recs[2].ID, ShouldEqual, rb.ID)
+					So(rb.Source, ShouldEqual, CalibrationSourceRollback)
+					So(rb.RollbackOf, ShouldEqual, 1)
+					So(rb.Note, ShouldEqual, "bad buffer")
+				})
+			})
+
+			Convey("rolling back to a calibration that doesn't exist should fail", func() {
+				_, err := tr.Rollback("irrig_ph", 99, "")
+				So(err, ShouldEqual, ErrCalibrationNotFound)
+			})
+		})
+
+		Convey("a field without any calibrations should have an empty history", func() {
+			recs, err := tr.CalibrationHistory("runoff_ec")
+			So(err, ShouldBeNil)
+			So(recs, ShouldBeEmpty)
+		})
+
+		Convey("fields that can't be translated should be refused", func() {
+			_, err := tr.Calibrate(CalibrationRecord{Field: "irrig_adc", Scale: 1})
+			So(err, ShouldEqual, ErrNotTranslatable)
+
+			_, err = tr.CalibrationHistory("irrig_adc")
+			So(err, ShouldEqual, ErrNotTranslatable)
+		})
+	})
+}
+
+func TestCalibrationFromPoints(t *testing.T) {
+	Convey("pH buffer points should give a calibration with the probe health", t, func() {
+		rec, err := calibrationFromPoints("runoff_ph", []CalibrationPoint{{Buffer: 4, Reading: 4.1}, {Buffer: 7, Reading: 7.1}, {Buffer: 10, Reading: 10.1}})
+		So(err, ShouldBeNil)
+		So(rec.Field, ShouldEqual, "runoff_ph")
+		So(rec.Points, ShouldHaveLength, 3)
+		So(rec.Scale, ShouldAlmostEqual, 1)
+		So(rec.Offset, ShouldAlmostEqual, -0.1)
+		So(rec.SlopePercent, ShouldAlmostEqual, 59/calib.NernstSlope*100)
+		So(rec.OffsetMV, ShouldAlmostEqual, -5.9)
+	})
+
+	Convey("a worn out pH probe should be refused", t, func() {
+		_, err := calibrationFromPoints("irrig_ph", []CalibrationPoint{{Buffer: 4, Reading: 5.5}, {Buffer: 7, Reading: 7}, {Buffer: 10, Reading: 8.5}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("EC buffer points should give a calibration across the range", t, func() {
+		rec, err := calibrationFromPoints("irrig_ec", []CalibrationPoint{{Buffer: 1.413, Reading: 1.5}, {Buffer: 12.88, Reading: 13.3}})
+		So(err, ShouldBeNil)
+		So(rec.Scale, ShouldAlmostEqual, (12.88-1.413)/(13.3-1.5))
+		So(rec.calibration().Transform(1.5), ShouldAlmostEqual, 1.413)
+		So(rec.calibration().Transform(13.3), ShouldAlmostEqual, 12.88)
+
+		Convey("or just the scale from a single buffer", func() {
+			rec, err := calibrationFromPoints("irrig_ec", []CalibrationPoint{{Buffer: 2.77, Reading: 2.5}})
+			So(err, ShouldBeNil)
+			So(rec.Scale, ShouldAlmostEqual, 2.77/2.5)
+			So(rec.Offset, ShouldEqual, 0)
+		})
+
+		Convey("but not from more buffers", func() {
+			_, err := calibrationFromPoints("irrig_ec", []CalibrationPoint{{Buffer: 1.413, Reading: 1.5}, {Buffer: 2.77, Reading: 2.8}, {Buffer: 12.88, Reading: 13.3}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("pours through a tipping bucket should give the volume per tip", t, func() {
+		rec, err := calibrationFromPoints("runoff_volume", []CalibrationPoint{
+			{Buffer: 500, Reading: 100, Rate: 10},
+			{Buffer: 540, Reading: 100, Rate: 14},
+		})
+		So(err, ShouldBeNil)
+		So(rec.Scale, ShouldAlmostEqual, 5.2)
+		So(rec.Offset, ShouldEqual, 0)
+		So(rec.RateRef, ShouldAlmostEqual, 12)
+		So(rec.RateCoef, ShouldAlmostEqual, 0.1)
+	})
+
+	Convey("fields that can't be translated should be refused", t, func() {
+		_, err := calibrationFromPoints("irrig_adc", nil)
+		So(err, ShouldEqual, ErrNotTranslatable)
+	})
+}
+
+func TestECProbeCalibrations(t *testing.T) {
+	Convey("given a minder with an EC probe on each side", t, func() {
+		jdb := newTestDB(t)
+
+		n, err := newNotifier(jdb.db, nil)
+		So(err, ShouldBeNil)
+
+		mdr := &Minder{
+			cfg:           &Config{IrrigECProbe: "A", RunoffECProbe: "B"},
+			tr:            &Translater{jdb},
+			notifier:      n,
+			stream:        newStream(),
+			errors:        newErrorStore(),
+			onCfgChangeCB: func(Config) {},
+		}
+
+		Convey("calibrations made before they were kept by probe should be moved to the probes", func() {
+			tr := mdr.tr
+			So(tr.SetCalibration("irrig_ec", 1.1, 0), ShouldBeNil)
+			So(tr.SetCalibration("irrig_ec", 1.2, 0), ShouldBeNil)
+			So(jdb.Set("runoff_ec", calibration{Scale: 0.9, Offset: 0}), ShouldBeNil)
+
+			mdr.migrateECCalibrations()
+			So(mdr.errors.Len(), ShouldEqual, 0)
+
+			c, err := tr.getCalibration(ecProbeKey("A"))
+			So(err, ShouldBeNil)
+			So(c.Scale, ShouldEqual, 1.2)
+
+			c, err = tr.getCalibration(ecProbeKey("B"))
+			So(err, ShouldBeNil)
+			So(c.Scale, ShouldEqual, 0.9)
+
+			_, err = tr.getCalibration("irrig_ec")
+			So(err, ShouldNotBeNil)
+
+			Convey("along with their history", func() {
+				recs, err := mdr.CalibrationHistory("irrig_ec")
+				So(err, ShouldBeNil)
+				So(recs, ShouldHaveLength, 2)
+				So(recs[1].Probe, ShouldEqual, "A")
+				So(recs[1].Scale, ShouldEqual, 1.2)
+
+				rec, err := mdr.Calibrate(CalibrationRecord{Field: "irrig_ec", Scale: 1.3})
+				So(err, ShouldBeNil)
+				So(rec.ID, ShouldEqual, 3)
+			})
+
+			Convey("running it again should change nothing", func() {
+				So(tr.SetCalibration("irrig_ec", 2, 0), ShouldBeNil)
+				mdr.migrateECCalibrations()
+
+				c, _ := tr.getCalibration(ecProbeKey("A"))
+				So(c.Scale, ShouldEqual, 1.2)
+			})
+		})
+
+		Convey("when the irrigation probe is calibrated", func() {
+			rec, err := mdr.Calibrate(CalibrationRecord{Field: "irrig_ec", Scale: 1.1})
+			So(err, ShouldBeNil)
+			So(rec.Probe, ShouldEqual, "A")
+
+			Convey("the calibration should be kept for the probe", func() {
+				v, err := mdr.tr.Translate(mdr.calibrationKey("irrig_ec"), 2)
+				So(err, ShouldBeNil)
+				So(v, ShouldAlmostEqual, 2.2)
+
+				_, err = mdr.tr.Translate(mdr.calibrationKey("runoff_ec"), 2)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("and the probes are swapped, the calibration should follow the probe", func() {
+				mdr.swapECProbes()
+
+				v, err := mdr.tr.Translate(mdr.calibrationKey("runoff_ec"), 2)
+				So(err, ShouldBeNil)
+				So(v, ShouldAlmostEqual, 2.2)
+
+				recs, err := mdr.CalibrationHistory("runoff_ec")
+				So(err, ShouldBeNil)
+				So(recs, ShouldHaveLength, 1)
+
+				recs, err = mdr.CalibrationHistory("irrig_ec")
+				So(err, ShouldBeNil)
+				So(recs, ShouldBeEmpty)
+			})
+		})
+
+		Convey("other calibrations should not be kept by probe", func() {
+			rec, err := mdr.Calibrate(CalibrationRecord{Field: "irrig_ph", Scale: 1, Probe: "A"})
+			So(err, ShouldBeNil)
+			So(rec.Probe, ShouldBeEmpty)
+			So(mdr.calibrationKey("irrig_ph"), ShouldEqual, "irrig_ph")
+		})
+	})
+}
+
+func TestCalibrationModels(t *testing.T) {
+	Convey("given a translater", t, func() {
+		jdb := newTestDB(t)
+
+		tr := &Translater{jdb}
+
+		Convey("calibrations saved before there were models should use the old ones", func() {
+			So(jdb.Set("irrig_ec", calibration{Scale: 2, Offset: 1}), ShouldBeNil)
+			So(jdb.Set("moisture", calibration{Scale: 2, Offset: 0.5}), ShouldBeNil)
+
+			v, err := tr.Translate("irrig_ec", 1.5)
+			So(err, ShouldBeNil)
+			So(v, ShouldAlmostEqual, 4)
+
+			v, err = tr.Translate("moisture", 1.5)
+			So(err, ShouldBeNil)
+			So(v, ShouldAlmostEqual, 50)
+		})
+
+		Convey("a table calibration should interpolate between its points", func() {
+			rec, err := tr.Calibrate(CalibrationRecord{
+				Field: "moisture",
+				Model: CalibrationModelTable,
+				Table: []CalibrationPoint{
+					{Reading: 2.5, Buffer: 100},
+					{Reading: 0.5, Buffer: 0},
+					{Reading: 1, Buffer: 40},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(rec.Table[0].Reading, ShouldEqual, 0.5)
+			So(rec.Table[2].Reading, ShouldEqual, 2.5)
+
+			for reading, want := range map[float64]float64{0.5: 0, 0.75: 20, 1: 40, 1.75: 70, 2.5: 100, 0: -40, 3: 120} {
+				v, err := tr.Translate("moisture", reading)
+				So(err, ShouldBeNil)
+				So(v, ShouldAlmostEqual, want)
+			}
+		})
+
+		Convey("a polynomial calibration should use its coefficients", func() {
+			_, err := tr.Calibrate(CalibrationRecord{
+				Field:  "moisture",
+				Model:  CalibrationModelPolynomial,
+				Coeffs: []float64{1, 5, 0.5, 0.01},
+			})
+			So(err, ShouldBeNil)
+
+			v, err := tr.Translate("moisture", 10)
+			So(err, ShouldBeNil)
+			So(v, ShouldAlmostEqual, 111)
+		})
+
+		Convey("calibrations without what their model needs should be refused", func() {
+			for _, rec := range []CalibrationRecord{
+				{Field: "moisture", Model: "spline"},
+				{Field: "moisture", Model: CalibrationModelPercent},
+				{Field: "moisture", Model: CalibrationModelTable, Table: []CalibrationPoint{{Reading: 1, Buffer: 10}}},
+				{Field: "moisture", Model: CalibrationModelTable, Table: []CalibrationPoint{{Reading: 1, Buffer: 10}, {Reading: 1, Buffer: 20}}},
+				{Field: "irrig_volume", Model: CalibrationModelPolynomial},
+				{Field: "irrig_volume", Model: CalibrationModelPolynomial, Coeffs: []float64{1, 2, 3, 4, 5}},
+				{Field: "irrig_volume", Model: CalibrationModelPolynomial, Coeffs: []float64{0, 5}},
+				{Field: "runoff_volume", Model: CalibrationModelTable, Table: []CalibrationPoint{{Reading: 0, Buffer: 0}, {Reading: 10, Buffer: 50}}},
+				{Field: "runoff_volume", Model: CalibrationModelPercent, Scale: 5},
+			} {
+				_, err := tr.Calibrate(rec)
+				So(err, ShouldHaveSameTypeAs, InvalidCalibrationError{})
+			}
+
+			_, err := tr.getCalibration("moisture")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCalibrateHandler(t *testing.T) {
+	Convey("given a minder serving the calibrations", t, func() {
+		jdb := newTestDB(t)
+
+		n, err := newNotifier(jdb.db, nil)
+		So(err, ShouldBeNil)
+
+		mdr := &Minder{
+			tr:       &Translater{jdb},
+			cfg:      &Config{},
+			notifier: n,
+			stream:   newStream(),
+			errors:   newErrorStore(),
+		}
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.PUT("/calibrations/:field/:scale/:offset", mdr.calibrateHandler())
+
+		put := func(path string) int {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("PUT", path, nil))
+			return w.Code
+		}
+
+		Convey("a scale and offset should be saved", func() {
+			So(put("/calibrations/irrig_ph/1.1/0.2"), ShouldEqual, 204)
+		})
+
+		Convey("a scale and offset the model can't use should be refused", func() {
+			So(put("/calibrations/moisture/0/1"), ShouldEqual, 400)
+		})
+
+		Convey("a field that can't be calibrated should be refused", func() {
+			So(put("/calibrations/irrig_adc/1/0"), ShouldEqual, 400)
+		})
+	})
+}
@@ -1,17 +1,42 @@
 package openminder
 
 import (
+	"log"
 	"time"
 
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpioreg"
 )
 
+// DefaultDebounce is the debounce window used for edge detection when none is given
+const DefaultDebounce = 20 * time.Millisecond
+
+// ContactSwitch is an interface to a switch that reports contact closures
+type ContactSwitch interface {
+	OnClosure(func(Closure))
+	Start()
+	Stop()
+}
+
+// Closure records a single closure of a contact switch
+type Closure struct {
+	// Time is when the contact closed
+	Time time.Time `json:"time"`
+
+	// Duration is how long the contact was closed for
+	Duration time.Duration `json:"duration"`
+
+	// Bounce is true if the contact was closed for less than the debounce window
+	Bounce bool `json:"bounce"`
+}
+
 // ContactClosure models a contact switch
 type ContactClosure struct {
 	Pin         gpio.PinIn
-	onClosureCB func()
-	stop        bool
+	Debounce    time.Duration
+	edges       bool
+	onClosureCB func(Closure)
+	done        chan struct{}
 }
 
 // NewContactClosure creates a new polling contact closure at the given pin.
@@ -28,26 +53,71 @@ func NewContactClosure(pin string) (*ContactClosure, error) {
 	return cc, nil
 }
 
+// NewEdgeContactClosure creates a new contact closure at the given pin that
+// uses edge detection rather than polling.  Closures shorter than the debounce
+// window are reported as bounces.  If edge detection is not supported on the
+// pin it will fall back to polling the pin.
+func NewEdgeContactClosure(pin string, debounce time.Duration) (*ContactClosure, error) {
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+
+	cc := &ContactClosure{Debounce: debounce}
+
+	p := gpioreg.ByName(pin)
+	if err := p.In(gpio.PullUp, gpio.BothEdges); err != nil {
+		log.Printf("WARN: edge detection not available on %s, falling back to polling: %s", pin, err)
+		cc, err := NewContactClosure(pin)
+		cc.Debounce = debounce
+		return cc, err
+	}
+
+	cc.Pin = p
+	cc.edges = true
+	return cc, nil
+}
+
 // OnClosure takes a function to call when a contact closure is detected
-func (cc *ContactClosure) OnClosure(cb func()) {
+func (cc *ContactClosure) OnClosure(cb func(Closure)) {
 	cc.onClosureCB = cb
 }
 
 // Stop will stop the contact closure from polling the pin
 func (cc *ContactClosure) Stop() {
-	cc.stop = true
+	if cc.done != nil {
+		close(cc.done)
+		cc.done = nil
+	}
 }
 
 // Start will start polling the contact closure pin
 func (cc *ContactClosure) Start() {
-	cc.stop = false
-	go cc.loop()
+	cc.done = make(chan struct{})
+	if cc.edges {
+		go cc.edgeLoop(cc.done)
+		return
+	}
+
+	go cc.loop(cc.done)
 }
 
-func (cc *ContactClosure) loop() {
+// stopped returns true once the done channel of the loop has been closed
+func stopped(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cc *ContactClosure) loop(done <-chan struct{}) {
+	var closedAt time.Time
 	lowCount := 0
+	last := gpio.High
+
 	for {
-		if cc.stop {
+		if stopped(done) {
 			return
 		}
 
@@ -55,13 +125,47 @@ func (cc *ContactClosure) loop() {
 
 		if level == gpio.Low {
 			lowCount++
+			if last == gpio.High {
+				closedAt = time.Now()
+			}
 		}
 
 		if lowCount >= 4 && level == gpio.High {
-			cc.onClosureCB()
+			cc.onClosureCB(Closure{Time: closedAt, Duration: time.Since(closedAt)})
 			lowCount = 0
 		}
 
+		last = level
 		time.Sleep(time.Second / 10)
 	}
 }
+
+func (cc *ContactClosure) edgeLoop(done <-chan struct{}) {
+	var closedAt time.Time
+	closed := false
+
+	for {
+		if stopped(done) {
+			return
+		}
+
+		// timeout so the stop flag gets checked
+		if !cc.Pin.WaitForEdge(time.Second) {
+			continue
+		}
+
+		now := time.Now()
+		level := cc.Pin.Read()
+
+		switch {
+		case level == gpio.Low && !closed:
+			closed = true
+			closedAt = now
+
+		case level == gpio.High && closed:
+			closed = false
+			d := now.Sub(closedAt)
+			cc.onClosureCB(Closure{Time: closedAt, Duration: d, Bounce: d < cc.Debounce})
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package openminder
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,34 +18,42 @@ func TestContactClosure(t *testing.T) {
 		cc := &ContactClosure{}
 		cc.Pin = p
 
+		// the pin is read by the cc's loop while the test changes it
+		set := func(l gpio.Level) {
+			p.Lock()
+			defer p.Unlock()
+			p.L = l
+		}
+
 		Convey("when the cc is started with an interrupt callback", func() {
-			closed := false
-			cc.onClosureCB = func() {
-				closed = true
+			var closures int32
+			closed := func() bool { return atomic.LoadInt32(&closures) > 0 }
+			cc.onClosureCB = func(Closure) {
+				atomic.AddInt32(&closures, 1)
 			}
 
 			cc.Start()
 
 			Convey("and the pin reads low for < 400ms", func() {
-				p.L = gpio.Low
+				set(gpio.Low)
 				time.Sleep(300 * time.Millisecond)
-				p.L = gpio.High
+				set(gpio.High)
 
 				Convey("it should not have closed", func() {
-					So(closed, ShouldBeFalse)
+					So(closed(), ShouldBeFalse)
 				})
 			})
 
 			Convey("and the pin reads low for > 400ms", func() {
-				p.L = gpio.Low
+				set(gpio.Low)
 				time.Sleep(500 * time.Millisecond)
 
 				Convey("and the pin goes high again", func() {
-					p.L = gpio.High
+					set(gpio.High)
 					time.Sleep(100 * time.Millisecond)
 
 					Convey("it should have closed", func() {
-						So(closed, ShouldBeTrue)
+						So(closed(), ShouldBeTrue)
 					})
 				})
 
@@ -52,7 +61,7 @@ func TestContactClosure(t *testing.T) {
 					time.Sleep(100 * time.Millisecond)
 
 					Convey("it should not have closed", func() {
-						So(closed, ShouldBeFalse)
+						So(closed(), ShouldBeFalse)
 					})
 				})
 			})
@@ -61,3 +70,58 @@ func TestContactClosure(t *testing.T) {
 		cc.Stop()
 	})
 }
+
+func TestEdgeContactClosure(t *testing.T) {
+	Convey("given an edge triggered cc with a high pin", t, func() {
+
+		// the edges aren't buffered so each one has been taken by the cc by the
+		// time it is sent, and the time between them is what the cc sees
+		p := &gpiotest.Pin{L: gpio.High, EdgesChan: make(chan gpio.Level)}
+		cc := &ContactClosure{Pin: p, Debounce: 50 * time.Millisecond, edges: true}
+
+		closures := make(chan Closure, 10)
+		cc.onClosureCB = func(cl Closure) {
+			closures <- cl
+		}
+
+		cc.Start()
+
+		Convey("when the contact closes for longer than the debounce window", func() {
+			start := time.Now()
+			p.EdgesChan <- gpio.Low
+			time.Sleep(120 * time.Millisecond)
+			p.EdgesChan <- gpio.High
+
+			cl := <-closures
+
+			Convey("it should report a closure with its timestamp and duration", func() {
+				So(cl.Bounce, ShouldBeFalse)
+				So(cl.Time, ShouldHappenOnOrBetween, start, start.Add(50*time.Millisecond))
+				So(cl.Duration, ShouldBeGreaterThanOrEqualTo, 120*time.Millisecond)
+			})
+		})
+
+		Convey("when the contact closes for less than the debounce window", func() {
+			p.EdgesChan <- gpio.Low
+			p.EdgesChan <- gpio.High
+
+			cl := <-closures
+
+			Convey("it should report a bounce", func() {
+				So(cl.Bounce, ShouldBeTrue)
+				So(cl.Duration, ShouldBeLessThan, 50*time.Millisecond)
+			})
+		})
+
+		Convey("when the contact opens without closing", func() {
+			p.EdgesChan <- gpio.High
+			time.Sleep(50 * time.Millisecond)
+
+			Convey("it should not report a closure", func() {
+				So(closures, ShouldBeEmpty)
+			})
+		})
+
+		cc.Stop()
+	})
+}
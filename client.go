@@ -1,10 +1,13 @@
 package openminder
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -42,6 +45,359 @@ func (cl *Client) SetCalibration(field string, scale, offset float64) error {
 	return nil
 }
 
+// Calibrate saves the calibration of the record's field, returning the record
+// as it was saved in the history
+func (cl *Client) Calibrate(rec CalibrationRecord) (CalibrationRecord, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+
+	res, err := cl.Post(cl.baseURL+"/calibrations/"+rec.Field, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 201 {
+		return rec, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&rec)
+	return rec, err
+}
+
+// CalibratePoints works out the calibration of the field from the buffer points
+// and saves it, unless dryRun is set in which case it is only returned
+func (cl *Client) CalibratePoints(field string, points []CalibrationPoint, note string, dryRun bool) (CalibrationRecord, error) {
+	rec := CalibrationRecord{}
+	data, err := json.Marshal(map[string]interface{}{
+		"points": points,
+		"source": CalibrationSourceOmcli,
+		"note":   note,
+	})
+	if err != nil {
+		return rec, err
+	}
+
+	u := fmt.Sprintf("%s/calibrations/%s/points?dry_run=%t", cl.baseURL, field, dryRun)
+	res, err := cl.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return rec, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&rec)
+	return rec, err
+}
+
+// StartCalibrationSession starts a session to calibrate the probe on the side,
+// the default buffers for the probe are used if none are given
+func (cl *Client) StartCalibrationSession(probe, side string, buffers []float64, note string) (CalibrationSession, error) {
+	s := CalibrationSession{}
+	data, err := json.Marshal(map[string]interface{}{
+		"probe":   probe,
+		"side":    side,
+		"buffers": buffers,
+		"note":    note,
+	})
+	if err != nil {
+		return s, err
+	}
+
+	res, err := cl.Post(cl.baseURL+"/calibration-sessions", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 201 {
+		return s, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&s)
+	return s, err
+}
+
+// CalibrationSession returns the calibration session with the live reading
+func (cl *Client) CalibrationSession(id string) (CalibrationSession, error) {
+	s := CalibrationSession{}
+	res, err := cl.Get(cl.baseURL + "/calibration-sessions/" + id)
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return s, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&s)
+	return s, err
+}
+
+// ReadyCalibrationStep marks the probe as being in the buffer for the current
+// step of the session, only the readings from after this are captured
+func (cl *Client) ReadyCalibrationStep(id string) (CalibrationSession, error) {
+	s := CalibrationSession{}
+	res, err := cl.Post(cl.baseURL+"/calibration-sessions/"+id+"/ready", "application/json", nil)
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return s, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&s)
+	return s, err
+}
+
+// CaptureCalibrationPoint captures the reading for the current step of the
+// session, unless force is set the reading must have settled
+func (cl *Client) CaptureCalibrationPoint(id string, force bool) (CalibrationSession, error) {
+	s := CalibrationSession{}
+	u := fmt.Sprintf("%s/calibration-sessions/%s/capture?force=%t", cl.baseURL, id, force)
+	res, err := cl.Post(u, "application/json", nil)
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return s, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&s)
+	return s, err
+}
+
+// CommitCalibrationSession saves the calibration worked out by the session
+func (cl *Client) CommitCalibrationSession(id string) (CalibrationRecord, error) {
+	rec := CalibrationRecord{}
+	u := fmt.Sprintf("%s/calibration-sessions/%s/commit?source=%s", cl.baseURL, id, CalibrationSourceOmcli)
+	res, err := cl.Post(u, "application/json", nil)
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 201 {
+		return rec, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&rec)
+	return rec, err
+}
+
+// CancelCalibrationSession ends the session without saving anything
+func (cl *Client) CancelCalibrationSession(id string) error {
+	req, err := http.NewRequest("DELETE", cl.baseURL+"/calibration-sessions/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 204 {
+		return apiError(res)
+	}
+
+	return nil
+}
+
+// CalibrationHistory returns the calibrations that have been used for the field
+func (cl *Client) CalibrationHistory(field string) ([]CalibrationRecord, error) {
+	recs := []CalibrationRecord{}
+
+	res, err := cl.Get(cl.baseURL + "/calibrations/" + field + "/history")
+	if err != nil {
+		return recs, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return recs, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&recs)
+	return recs, err
+}
+
+// RollbackCalibration goes back to the calibration with the given ID from the
+// history of the field
+func (cl *Client) RollbackCalibration(field string, id uint64, note string) (CalibrationRecord, error) {
+	rec := CalibrationRecord{}
+	u := fmt.Sprintf("%s/calibrations/%s/rollback/%d?%s", cl.baseURL, field, id, url.Values{"note": {note}}.Encode())
+
+	res, err := cl.Post(u, "application/json", nil)
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return rec, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&rec)
+	return rec, err
+}
+
+// Export returns the bundle of the minder's setup as it was sent by the API
+func (cl *Client) Export() ([]byte, error) {
+	res, err := cl.Get(cl.baseURL + "/export")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, apiError(res)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// Import makes the minder's setup match the bundle, unless the options ask for
+// a dry run in which case the changes that would be made are only returned
+func (cl *Client) Import(bundle []byte, opts ImportOptions) (ImportResult, error) {
+	ir := ImportResult{}
+	u := fmt.Sprintf("%s/import?dry_run=%t&identity=%t", cl.baseURL, opts.DryRun, opts.Identity)
+	res, err := cl.Post(u, "application/json", bytes.NewReader(bundle))
+	if err != nil {
+		return ir, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return ir, apiError(res)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&ir)
+	return ir, err
+}
+
+// apiError returns an error with the status and the message from the API if there is one
+func apiError(res *http.Response) error {
+	msg := struct {
+		Error string `json:"error"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil || msg.Error == "" {
+		return fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	return fmt.Errorf("unexpected http status: %d: %s", res.StatusCode, msg.Error)
+}
+
+// ResetCounters will zero the tip counters, returning the counters after the reset
+func (cl *Client) ResetCounters() (Counters, error) {
+	c := Counters{}
+	req, err := http.NewRequest("PUT", cl.baseURL+"/counters/reset", nil)
+	if err != nil {
+		return c, err
+	}
+
+	res, err := cl.Do(req)
+	if err != nil {
+		return c, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return c, fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&c)
+	return c, err
+}
+
+// History returns the readings history between the given times with only the
+// given fields, or all of them if none are given.  If step is not zero the
+// samples are averaged over each step.
+func (cl *Client) History(fields []string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	samples := []Sample{}
+
+	q := url.Values{}
+	q.Set("from", from.Format(time.RFC3339))
+	q.Set("to", to.Format(time.RFC3339))
+	if len(fields) > 0 {
+		q.Set("fields", strings.Join(fields, ","))
+	}
+	if step > 0 {
+		q.Set("step", step.String())
+	}
+
+	res, err := cl.Get(cl.baseURL + "/readings/history?" + q.Encode())
+	if err != nil {
+		return samples, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return samples, fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&samples)
+	return samples, err
+}
+
+// Rollups returns the rollups of the readings for the given period (1m, 1h or 1d)
+// between the given times with only the given fields, or all of them if none are given
+func (cl *Client) Rollups(agg string, fields []string, from, to time.Time) ([]Rollup, error) {
+	rollups := []Rollup{}
+
+	q := url.Values{}
+	q.Set("agg", agg)
+	q.Set("from", from.Format(time.RFC3339))
+	q.Set("to", to.Format(time.RFC3339))
+	if len(fields) > 0 {
+		q.Set("fields", strings.Join(fields, ","))
+	}
+
+	res, err := cl.Get(cl.baseURL + "/readings/history?" + q.Encode())
+	if err != nil {
+		return rollups, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return rollups, fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&rollups)
+	return rollups, err
+}
+
+// DailyReport returns the water balance for the given number of days up to and
+// including today, oldest first
+func (cl *Client) DailyReport(days int) ([]DailyReport, error) {
+	reports := []DailyReport{}
+
+	res, err := cl.Get(fmt.Sprintf("%s/reports/daily?days=%d", cl.baseURL, days))
+	if err != nil {
+		return reports, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return reports, fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&reports)
+	return reports, err
+}
+
 // Readings returns the readings from the API
 func (cl *Client) Readings() (Readings, error) {
 	r := Readings{}
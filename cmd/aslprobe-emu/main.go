@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/autogrow/openminder/aslbus/emulator"
+)
+
+func main() {
+	var serials, ecs, temps, link, drop string
+	var noise, corrupt float64
+	var delay, dropout, dropoutFor time.Duration
+	var verbose bool
+
+	flag.StringVar(&serials, "probes", "ASL0000000001,ASL0000000002", "comma separated serials of the probes to emulate")
+	flag.StringVar(&ecs, "ec", "2.0,2.6", "comma separated EC of each probe, the last is used for any remaining probes")
+	flag.StringVar(&temps, "temp", "22.0", "comma separated temperature of each probe, the last is used for any remaining probes")
+	flag.Float64Var(&noise, "noise", 0.02, "standard deviation of the noise added to the readings")
+	flag.DurationVar(&delay, "delay", 0, "how long the probes wait before replying")
+	flag.Float64Var(&corrupt, "corrupt", 0, "fraction (0 to 1) of replies to send with a bad CRC")
+	flag.StringVar(&drop, "drop", "", "serial of the probe that drops off the bus (default is the last probe)")
+	flag.DurationVar(&dropout, "dropout", 0, "drop a probe off the bus after this long")
+	flag.DurationVar(&dropoutFor, "dropout-for", 0, "bring the dropped probe back after this long (default is never)")
+	flag.StringVar(&link, "link", "", "create a symlink to the pty at this path")
+	flag.BoolVar(&verbose, "v", false, "verbose")
+	flag.Parse()
+
+	sns := strings.Split(serials, ",")
+	ecVals := parseFloats(ecs)
+	tempVals := parseFloats(temps)
+
+	var probes []*emulator.Probe
+	for i, sn := range sns {
+		ec := pick(ecVals, i)
+		temp := pick(tempVals, i)
+
+		p := emulator.NewProbe(sn, func() (float64, float64) {
+			return ec + (rand.NormFloat64() * noise), temp + (rand.NormFloat64() * noise)
+		})
+		p.Delay = delay
+		p.CorruptRate = corrupt
+		probes = append(probes, p)
+
+		log.Printf("emulating probe %s at %0.2f EC and %0.1f°C", sn, ec, temp)
+	}
+
+	eb := emulator.New(probes...)
+	eb.Verbose(verbose)
+
+	pty, err := emulator.OpenPTY()
+	if err != nil {
+		log.Fatalf("ERROR: failed to open pty: %s", err)
+	}
+	defer pty.Close()
+
+	if link != "" {
+		os.Remove(link)
+		if err := os.Symlink(pty.Name, link); err != nil {
+			log.Fatalf("ERROR: failed to link pty: %s", err)
+		}
+		defer os.Remove(link)
+		log.Printf("linked %s to %s", link, pty.Name)
+	}
+
+	if dropout > 0 {
+		go dropProbe(probes, drop, dropout, dropoutFor)
+	}
+
+	log.Printf("emulating %d probes on %s", len(probes), pty.Name)
+	if err := eb.Serve(pty); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+func dropProbe(probes []*emulator.Probe, sn string, after, dur time.Duration) {
+	p := probes[len(probes)-1]
+	for _, _p := range probes {
+		if _p.Serial == sn {
+			p = _p
+		}
+	}
+
+	time.Sleep(after)
+	log.Printf("probe %s dropped off the bus", p.Serial)
+	p.SetOffline(true)
+
+	if dur == 0 {
+		return
+	}
+
+	time.Sleep(dur)
+	log.Printf("probe %s is back on the bus", p.Serial)
+	p.SetOffline(false)
+}
+
+func parseFloats(s string) []float64 {
+	var vals []float64
+	for _, bit := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(bit), 64)
+		if err != nil {
+			log.Fatalf("ERROR: invalid number %q: %s", bit, err)
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func pick(vals []float64, i int) float64 {
+	if i < len(vals) {
+		return vals[i]
+	}
+	return vals[len(vals)-1]
+}
@@ -2,37 +2,54 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/autogrow/openminder"
 	"github.com/autogrow/openminder/aslbus"
-	calibutil "github.com/autogrow/openminder/calib"
+	"github.com/autogrow/openminder/types"
 )
 
 var version = "1.0.0"
 
 func main() {
-	var calibDef, port, cfgFile string
-	var printReadings, calib, ecProbe, phProbe, moistureProbe, runoffSide, irrigSide, printVersion, detectProbes, scanbus bool
-	var ecBuffer float64
+	var calibDef, port, cfgFile, report, note, history, rollback, ecBuffers, phBuffers, pours, exportFile, importFile string
+	var days int
+	var printReadings, calib, ecProbe, phProbe, moistureProbe, tipBucket, runoffSide, irrigSide, printVersion, detectProbes, scanbus, resetCounters, identity bool
 
 	flag.BoolVar(&calib, "calib", false, "calibrate something")
 	flag.StringVar(&calibDef, "set", "", "set reading calibration: reading,scale,offset (e.g. runoff_volume,5.0,0)")
-	flag.Float64Var(&ecBuffer, "buffer", 2.77, "EC buffer")
+	flag.StringVar(&note, "note", "", "a note to save with the calibration")
+	flag.StringVar(&history, "history", "", "print the calibration history of a reading (e.g. irrig_ph)")
+	flag.StringVar(&rollback, "rollback", "", "roll back to a calibration from the history: reading,id (e.g. irrig_ph,3)")
+	flag.StringVar(&ecBuffers, "buffer", "1.413,12.88", "the EC buffers in mS/cm to calibrate with, a low and a high one or just one (e.g. 2.77)")
 	flag.BoolVar(&ecProbe, "ec", false, "calibrate an EC probe")
 	flag.BoolVar(&phProbe, "ph", false, "calibrate a pH probe")
+	flag.StringVar(&phBuffers, "buffers", "7,4", "the pH buffers to calibrate with, 2 to 5 of them (e.g. 7,4,10)")
 	flag.BoolVar(&moistureProbe, "moisture", false, "calibrate a moisture probe")
+	flag.BoolVar(&tipBucket, "tb", false, "calibrate a tipping bucket")
+	flag.StringVar(&pours, "pours", "500", "the volumes in mL to pour through the tipping bucket, pour them at different rates for a rate correction (e.g. 500,500,1000)")
 	flag.BoolVar(&runoffSide, "runoff", false, "calibrate a probe for the runoff side")
 	flag.BoolVar(&irrigSide, "irrig", false, "calibrate a probe for the irrig side")
 	flag.BoolVar(&printReadings, "readings", false, "print readings")
 	flag.BoolVar(&detectProbes, "detectprobes", false, "start the probe detection wizard")
+	flag.StringVar(&report, "report", "", "print a report (daily)")
+	flag.IntVar(&days, "days", 30, "the number of days to report on")
+	flag.BoolVar(&resetCounters, "reset-counters", false, "reset the tip counters and volumes to zero")
+	flag.StringVar(&exportFile, "export", "", "export the config, calibrations and alert rules to a file")
+	flag.StringVar(&importFile, "import", "", "import the config, calibrations and alert rules from an exported file")
+	flag.BoolVar(&identity, "identity", false, "also import the EC probe sides and MQTT client ID instead of keeping the minder's own")
 	flag.BoolVar(&scanbus, "scanbus", false, "scan the bus for probes wihout saving to config")
 	flag.StringVar(&port, "p", "3232", "the port to talk to the API on")
 	flag.StringVar(&cfgFile, "c", "", "the config file to use/write to")
@@ -53,6 +70,59 @@ func main() {
 		}
 		dumpJSONR(r)
 
+	case report == "daily":
+		if err := printDailyReport(client, days); err != nil {
+			log.Fatalf("ERROR: failed to get daily report: %s", err)
+		}
+
+	case report != "":
+		log.Fatalf("ERROR: unknown report: %s", report)
+
+	case resetCounters:
+		fmt.Print("This will reset the tip counters and volumes to zero, are you sure? [y/N]: ")
+		if !waitForAnswer(false) {
+			fmt.Println("not resetting...")
+			os.Exit(0)
+		}
+
+		c, err := client.ResetCounters()
+		if err != nil {
+			log.Fatalf("ERROR: failed to reset counters: %s", err)
+		}
+		fmt.Printf("counters reset at %s\n", c.ResetAt.Format(time.RFC3339))
+
+	case exportFile != "":
+		if err := exportBundle(client, exportFile); err != nil {
+			log.Fatalf("ERROR: failed to export: %s", err)
+		}
+
+	case importFile != "":
+		if err := importBundle(client, importFile, identity); err != nil {
+			log.Fatalf("ERROR: failed to import: %s", err)
+		}
+
+	case history != "":
+		if err := printCalibrationHistory(client, history); err != nil {
+			log.Fatalf("ERROR: failed to get calibration history: %s", err)
+		}
+
+	case rollback != "":
+		bits := strings.Split(rollback, ",")
+		if len(bits) != 2 {
+			log.Fatalf("ERROR: rollback must be specified as reading,id")
+		}
+
+		id, err := strconv.ParseUint(bits[1], 10, 64)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		rec, err := client.RollbackCalibration(bits[0], id, note)
+		if err != nil {
+			log.Fatalf("ERROR: failed to roll back calibration: %s", err)
+		}
+		fmt.Printf("%s rolled back to calibration %d: scale=%0.3f offset=%0.3f\n", rec.Field, id, rec.Scale, rec.Offset)
+
 	case scanbus:
 		if err := scanProbes(cfgFile); err != nil {
 			log.Fatalf("ERROR: failed to scan probes: %s", err)
@@ -79,7 +149,7 @@ func main() {
 			log.Fatalf("ERROR: %s", err)
 		}
 
-		err = client.SetCalibration(bits[0], s, o)
+		err = saveCalibration(client, bits[0], s, o, note)
 		if err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
@@ -88,17 +158,37 @@ func main() {
 		log.Fatalf("must specify the side to calibrate with -runoff or -irrig")
 
 	case calib && ecProbe:
-		if err := calibrateEC(client, runoffSide, ecBuffer); err != nil {
+		buffers, err := parseBuffers(ecBuffers)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		if err := calibrateProbe(client, openminder.ProbeEC, side(runoffSide), buffers, note); err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
 
 	case calib && phProbe:
-		if err := calibratePH(client, runoffSide); err != nil {
+		buffers, err := parseBuffers(phBuffers)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		if err := calibrateProbe(client, openminder.ProbePH, side(runoffSide), buffers, note); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+	case calib && tipBucket:
+		volumes, err := parseBuffers(pours)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		if err := calibrateProbe(client, openminder.ProbeTB, side(runoffSide), volumes, note); err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
 
 	case calib && moistureProbe:
-		if err := calibrateMoisture(client); err != nil {
+		if err := calibrateProbe(client, openminder.ProbeMoisture, "", nil, note); err != nil {
 			log.Fatalf("ERROR: %s", err)
 		}
 
@@ -110,6 +200,13 @@ func main() {
 
 }
 
+func side(runoffSide bool) string {
+	if runoffSide {
+		return "runoff"
+	}
+	return "irrig"
+}
+
 func apiVersion() string {
 	return "v" + strings.Split(version, ".")[0]
 }
@@ -125,120 +222,211 @@ func dumpJSONR(r interface{}) error {
 	return nil
 }
 
-func calibrateEC(client *openminder.Client, runoffSide bool, ecBuffer float64) error {
-	fmt.Printf("wash the probe and put it in the %0.2f buffer solution, then push enter...\n", ecBuffer)
-	waitForEnter()
-	for i := 30; i > 0; i-- {
-		fmt.Print("\rwaiting for the readings to settle... ", i, " ")
-		time.Sleep(time.Second)
+// saveCalibration saves the calibration along with the points it was worked out from
+func saveCalibration(client *openminder.Client, field string, scale, offset float64, note string, points ...openminder.CalibrationPoint) error {
+	_, err := client.Calibrate(openminder.CalibrationRecord{
+		Field:  field,
+		Scale:  scale,
+		Offset: offset,
+		Points: points,
+		Source: openminder.CalibrationSourceOmcli,
+		Note:   note,
+	})
+	return err
+}
+
+// exportBundle saves the bundle of the minder's setup to the file
+func exportBundle(client *openminder.Client, fn string) error {
+	data, err := client.Export()
+	if err != nil {
+		return err
 	}
 
-	fmt.Println()
-	fmt.Println("taking reading...")
+	buf := bytes.Buffer{}
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
 
-	r, err := client.Readings()
-	if err != nil {
+	// the config can have passwords in it
+	if err := ioutil.WriteFile(fn, buf.Bytes(), 0600); err != nil {
 		return err
 	}
 
-	side, reading := func() (string, float64) {
-		switch runoffSide {
-		case true:
-			return "runoff", r.RunoffECRaw.Value()
-		default:
-			return "irrig", r.IrrigECRaw.Value()
-		}
-	}()
+	fmt.Println("exported to", fn)
+	return nil
+}
 
-	fmt.Printf("reading was %0.2f EC\n", reading)
+// importBundle shows the changes that importing the bundle in the file would
+// make and imports it if they are wanted
+func importBundle(client *openminder.Client, fn string, identity bool) error {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
 
-	scale, offset, err := calibutil.EC(ecBuffer, reading)
+	opts := openminder.ImportOptions{DryRun: true, Identity: identity}
+	res, err := client.Import(data, opts)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("calibrated probe, scale=%0.2f offset=%0.2f - save this? [Y/n]: ", scale, offset)
-	if !waitForAnswer(true) {
-		fmt.Println("not saving...")
-		os.Exit(0)
+	for _, w := range res.Warnings {
+		fmt.Println("WARNING:", w)
 	}
 
-	err = client.SetCalibration(side+"_ec", scale, offset)
-	if err != nil {
+	for _, k := range res.Kept {
+		fmt.Printf("keeping the minder's %s of %s rather than %s from the export (use -identity to import it)\n", k.Key, changeValue(k.From), changeValue(k.To))
+	}
+
+	if len(res.Changes) == 0 {
+		fmt.Println("nothing to import, the minder is already set up the same")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "section\tkey\taction\tfrom\tto")
+	for _, chg := range res.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", chg.Section, chg.Key, chg.Action, changeValue(chg.From), changeValue(chg.To))
+	}
+	w.Flush()
+
+	fmt.Printf("import these %d changes? [y/N]: ", len(res.Changes))
+	if !waitForAnswer(false) {
+		fmt.Println("not importing...")
+		return nil
+	}
+
+	opts.DryRun = false
+	if res, err = client.Import(data, opts); err != nil {
 		return err
 	}
 
-	fmt.Println("saved")
+	fmt.Printf("imported %d changes\n", len(res.Changes))
+	if res.RestartRequired {
+		fmt.Println("the config changed, restart the minder to use it")
+	}
 
 	return nil
 }
 
-func calibratePH(client *openminder.Client, runoffSide bool) error {
-	fmt.Println("wash the probe and put it in the pH7 buffer solution, then push enter...")
-	waitForEnter()
-	for i := 30; i > 0; i-- {
-		fmt.Print("\rwaiting for the readings to settle... ", i, " ")
-		time.Sleep(time.Second)
+// changeValue returns the value of a change as short JSON
+func changeValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "?"
 	}
 
-	fmt.Println()
-	fmt.Println("taking reading...")
+	if len(data) > 60 {
+		return string(data[:57]) + "..."
+	}
+
+	return string(data)
+}
 
-	r, err := client.Readings()
+func printCalibrationHistory(client *openminder.Client, field string) error {
+	recs, err := client.CalibrationHistory(field)
 	if err != nil {
 		return err
 	}
 
-	side, ph7 := func() (string, float64) {
-		switch runoffSide {
-		case true:
-			return "runoff", r.RunoffPHRaw
-		default:
-			return "irrig", r.IrrigPHRaw
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "id\ttime\tmodel\tscale\toffset\tsource\tpoints\tnote")
+	for _, rec := range recs {
+		t := "-"
+		if !rec.Time.IsZero() {
+			t = rec.Time.Local().Format("2006-01-02 15:04")
 		}
-	}()
-	fmt.Printf("reading was %0.2f pH\n", ph7)
 
-	fmt.Println("wash the probe and put it in the pH4 buffer solution, then push enter...")
-	waitForEnter()
-	for i := 30; i > 0; i-- {
-		fmt.Print("\rwaiting for the readings to settle... ", i, " ")
-		time.Sleep(time.Second)
+		points := []string{}
+		for _, p := range rec.Points {
+			points = append(points, fmt.Sprintf("%g=%g", p.Buffer, p.Reading))
+		}
+
+		model := rec.Model
+		if model == "" {
+			model = "-"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%0.3f\t%0.3f\t%s\t%s\t%s\n", rec.ID, t, model, rec.Scale, rec.Offset, rec.Source, strings.Join(points, " "), rec.Note)
 	}
 
-	fmt.Println()
-	fmt.Println("taking reading...")
+	return w.Flush()
+}
 
-	r, err = client.Readings()
+// calibrateProbe drives a calibration session on the minder, waiting for the
+// reading to settle in each buffer before capturing it
+func calibrateProbe(client *openminder.Client, probe, side string, buffers []float64, note string) error {
+	s, err := client.StartCalibrationSession(probe, side, buffers, note)
 	if err != nil {
 		return err
 	}
 
-	ph4 := func() float64 {
-		switch runoffSide {
-		case true:
-			return r.RunoffPHRaw
-		default:
-			return r.IrrigPHRaw
-		}
+	// the session is cancelled however the wizard ends, so a tipping bucket
+	// isn't left with its tips going to a session, once it has been committed
+	// there is nothing left to cancel
+	id := s.ID
+	defer client.CancelCalibrationSession(id)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+	go func() {
+		<-interrupted
+		client.CancelCalibrationSession(id)
+		os.Exit(1)
 	}()
-	fmt.Printf("reading was %0.2f pH\n", ph4)
 
-	fmt.Println("calibrating...")
+	for s.Step < len(s.Steps) {
+		step := s.Steps[s.Step]
+		fmt.Printf("%s, then push enter...\n", step.Prompt)
+		waitForEnter()
 
-	scale, offset, err := calibutil.PH(ph7, ph4)
-	if err != nil {
-		return err
+		if _, err = client.ReadyCalibrationStep(s.ID); err != nil {
+			return err
+		}
+
+		if s, err = waitForStable(client, s.ID); err != nil {
+			return err
+		}
+
+		if s, err = client.CaptureCalibrationPoint(s.ID, true); err != nil {
+			return err
+		}
+
+		step = s.Steps[s.Step-1]
+		if probe == openminder.ProbeTB {
+			fmt.Printf("counted %0.0f tips at %0.1f tips/min\n", step.Reading, step.Rate)
+			continue
+		}
+
+		fmt.Printf("reading was %0.3f\n", step.Reading)
+	}
+
+	if s.Error != "" {
+		return fmt.Errorf("%s", s.Error)
+	}
+
+	rec := s.Result
+	switch {
+	case probe == openminder.ProbePH:
+		fmt.Printf("probe slope is %0.1f%% with an offset of %0.1f mV\n", rec.SlopePercent, rec.OffsetMV)
+	case probe == openminder.ProbeTB && rec.RateCoef != 0:
+		fmt.Printf("%0.2f mL per tip at %0.1f tips/min, changing by %0.3f mL per tip/min\n", rec.Scale, rec.RateRef, rec.RateCoef)
+	case probe == openminder.ProbeTB:
+		fmt.Printf("%0.2f mL per tip\n", rec.Scale)
 	}
 
-	fmt.Printf("calibrated probe, scale=%0.2f offset=%0.2f - save this? [Y/n]: ", scale, offset)
+	fmt.Printf("calibrated probe, scale=%0.3f offset=%0.3f - save this? [Y/n]: ", rec.Scale, rec.Offset)
 	if !waitForAnswer(true) {
 		fmt.Println("not saving...")
-		os.Exit(0)
+		return nil
 	}
 
-	err = client.SetCalibration(side+"_ph", scale, offset)
-	if err != nil {
+	if _, err := client.CommitCalibrationSession(s.ID); err != nil {
 		return err
 	}
 
@@ -247,57 +435,80 @@ func calibratePH(client *openminder.Client, runoffSide bool) error {
 	return nil
 }
 
-func calibrateMoisture(client *openminder.Client) error {
-	fmt.Println("make sure the probe is completely dry or in dry media, then push enter...")
-	waitForEnter()
-	for i := 30; i > 0; i-- {
-		fmt.Print("\rwaiting for the readings to settle... ", i, " ")
-		time.Sleep(time.Second)
-	}
+// waitForStable polls the session until the reading settles, asking whether to
+// carry on anyway if it takes too long
+func waitForStable(client *openminder.Client, id string) (openminder.CalibrationSession, error) {
+	start := time.Now()
+	for {
+		s, err := client.CalibrationSession(id)
+		if err != nil {
+			return s, err
+		}
 
-	fmt.Println()
-	fmt.Println("taking reading...")
+		if s.Stable {
+			fmt.Println()
+			return s, nil
+		}
 
-	r, err := client.Readings()
-	if err != nil {
-		return err
-	}
+		reading := "-"
+		if s.Reading.IsValid() {
+			reading = fmt.Sprintf("%0.3f", s.Reading.Value())
+		}
+		if s.Probe == openminder.ProbeTB {
+			fmt.Printf("\rwaiting for the bucket to stop tipping... %s tips   ", reading)
+		} else {
+			fmt.Printf("\rwaiting for the reading to settle... %s (±%0.3f)   ", reading, s.StdDev)
+		}
 
-	offset := r.MoistureVoltage
-	fmt.Printf("reading was %0.3f volts\n", offset)
+		if time.Since(start) > 2*time.Minute {
+			fmt.Print("\nthe reading hasn't settled, capture it anyway? [y/N]: ")
+			if waitForAnswer(false) {
+				return s, nil
+			}
+			start = time.Now()
+		}
 
-	fmt.Println("place probe in water or completely saturated media, then push enter...")
-	waitForEnter()
-	for i := 30; i > 0; i-- {
-		fmt.Print("\rwaiting for the readings to settle... ", i, " ")
 		time.Sleep(time.Second)
 	}
+}
 
-	fmt.Println()
-	fmt.Println("taking reading...")
+// parseBuffers parses a comma separated list of buffer values
+func parseBuffers(s string) ([]float64, error) {
+	buffers := []float64{}
+	for _, b := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid buffer %q", b)
+		}
 
-	r, err = client.Readings()
-	if err != nil {
-		return err
+		buffers = append(buffers, v)
 	}
 
-	scale := r.MoistureVoltage
-	fmt.Printf("reading was %0.3f pH\n", scale)
-
-	fmt.Printf("calibrated probe, dry voltage=%0.3f wet voltage=%0.3f - save this? [Y/n]: ", scale, offset)
-	if !waitForAnswer(true) {
-		fmt.Println("not saving...")
-		os.Exit(0)
-	}
+	return buffers, nil
+}
 
-	err = client.SetCalibration("moisture", scale, offset)
+func printDailyReport(client *openminder.Client, days int) error {
+	reports, err := client.DailyReport(days)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("saved")
+	perPlant := func(v *types.NullFloat) string {
+		if v == nil || !v.IsValid() {
+			return "-"
+		}
+		return fmt.Sprintf("%0.1f", v.Value())
+	}
 
-	return nil
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "day\tirrig\trunoff\tratio\tirrig/plant\trunoff/plant\tuptake/plant\t")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%0.1f\t%0.1f\t%0.2f\t%s\t%s\t%s\t\n",
+			r.Day.Format("2006-01-02"), r.IrrigVolume, r.RunoffVolume, r.RunoffRatio,
+			perPlant(r.IrrigPerPlant), perPlant(r.RunoffPerPlant), perPlant(r.UptakePerPlant))
+	}
+
+	return w.Flush()
 }
 
 func waitForEnter() {
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/autogrow/openminder"
+	"github.com/autogrow/openminder/aslbus"
+	"github.com/autogrow/openminder/sim"
 	"github.com/gin-gonic/gin"
 	"periph.io/x/periph/host"
 )
@@ -16,14 +20,18 @@ var version = "1.0.0"
 
 func main() {
 	cfg := &openminder.Config{}
-	var cfgFile string
-	var printVersion bool
+	var cfgFile, scenario, capture, replay string
+	var printVersion, simulate bool
 
 	flag.StringVar(&cfg.IrrigTBGPIO, "tb1", "GPIO5", "pin for irrigation tipping bucket")
 	flag.StringVar(&cfg.RunoffTBGPIO, "tb2", "GPIO6", "pin for runoff tipping bucket")
 	flag.StringVar(&cfg.Port, "p", "3232", "the port to serve the API on")
 	flag.StringVar(&cfgFile, "c", "", "path to the config file to use")
 	flag.BoolVar(&printVersion, "v", false, "print the version")
+	flag.BoolVar(&simulate, "simulate", false, "simulate the hat hardware")
+	flag.StringVar(&scenario, "scenario", "", "path to the scenario file to simulate")
+	flag.StringVar(&capture, "capture", "", "record the ASL bus traffic to this file")
+	flag.StringVar(&replay, "replay", "", "play back the ASL bus traffic from this capture file")
 	flag.Parse()
 
 	if printVersion {
@@ -37,19 +45,67 @@ func main() {
 		}
 	}
 
-	if _, err := host.Init(); err != nil {
+	if simulate {
+		cfg.Simulate.Enabled = true
+	}
+
+	if scenario != "" {
+		cfg.Simulate.Scenario = scenario
+	}
+
+	hw := openminder.HatHardware()
+	if cfg.Simulate.Enabled {
+		scn, err := sim.LoadScenario(cfg.Simulate.Scenario)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Printf("simulating the hardware")
+		hw = sim.New(scn, cfg)
+	} else if _, err := host.Init(); err != nil {
 		panic(err)
 	}
 
+	if replay != "" {
+		frames, err := aslbus.LoadCapture(replay)
+		if err != nil {
+			panic(err)
+		}
+
+		rp := aslbus.NewReplay(frames)
+		rp.OnDone(func() { log.Printf("finished replaying %d frames from %s", len(frames), replay) })
+		hw = openminder.WithBusReplay(hw, rp)
+	}
+
+	if capture != "" {
+		c, err := aslbus.CreateCapture(capture)
+		if err != nil {
+			panic(err)
+		}
+		defer c.Close()
+
+		log.Printf("capturing bus traffic to %s", capture)
+		hw = openminder.WithBusCapture(hw, c)
+	}
+
 	api := gin.Default()
 	r := api.Group("/" + apiVersion())
 
-	minder, err := openminder.NewMinder(cfg)
+	minder, err := openminder.NewMinderWithHardware(cfg, hw)
 	if err != nil {
 		panic(err)
 	}
 	go minder.Start()
 
+	// stop the minder on shutdown so the counters get saved
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		minder.Stop()
+		os.Exit(0)
+	}()
+
 	minder.OnConfigChange(func(cfg openminder.Config) {
 		err := cfg.SaveTo(cfgFile)
 		if err != nil {
@@ -60,6 +116,7 @@ func main() {
 	})
 
 	minder.AttachAPI(r)
+	minder.AttachMetrics(api)
 	api.Run(":" + cfg.Port)
 }
 
@@ -9,15 +9,38 @@ import (
 )
 
 func main() {
-	var port string
+	var port, capture, replay string
 	var verbose bool
 
 	flag.StringVar(&port, "port", "/dev/ttyUSB0", "port to use")
 	flag.BoolVar(&verbose, "v", false, "verbose")
+	flag.StringVar(&capture, "capture", "", "record the bus traffic to this file")
+	flag.StringVar(&replay, "replay", "", "play back the bus traffic from this capture file instead of using the port")
 	flag.Parse()
 
-	log.Printf("using port %s", port)
-	bus := aslbus.New(port)
+	var bus *aslbus.Bus
+	if replay != "" {
+		frames, err := aslbus.LoadCapture(replay)
+		if err != nil {
+			log.Fatalf("ERROR: failed to load capture: %s", err)
+		}
+
+		log.Printf("replaying %d frames from %s", len(frames), replay)
+		bus = aslbus.NewWithOpener(port, aslbus.NewReplay(frames).Opener())
+	} else {
+		log.Printf("using port %s", port)
+		bus = aslbus.New(port)
+	}
+
+	if capture != "" {
+		c, err := aslbus.CreateCapture(capture)
+		if err != nil {
+			log.Fatalf("ERROR: failed to create capture: %s", err)
+		}
+
+		log.Printf("capturing bus traffic to %s", capture)
+		bus.SetCapture(c)
+	}
 	scanner := aslbus.NewScanner(bus, 2, 60)
 
 	bus.OnConnect(func() {
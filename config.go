@@ -2,7 +2,10 @@ package openminder
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strconv"
+	"time"
 )
 
 // Config is the configuration for the OpenMinder
@@ -13,6 +16,13 @@ type Config struct {
 	// RunoffTBGPIO is the GPIO port that should be used for the runoff tipping bucket
 	RunoffTBGPIO string `json:"runoff_tb_gpio"`
 
+	// TBEdgeDetect uses edge detection on the tipping bucket pins instead of polling them
+	TBEdgeDetect bool `json:"tb_edge_detect"`
+
+	// TBDebounce is the number of milliseconds the tipping bucket contact must be
+	// closed for to count as a tip when edge detection is used
+	TBDebounce int `json:"tb_debounce"`
+
 	// Port is the port that the API should run on
 	Port string `json:"port"`
 
@@ -28,12 +38,149 @@ type Config struct {
 	// TTY is the bus to use for the ASL Bus comms
 	TTY string `json:"tty"`
 
+	// PHTempComp contains the settings for compensating the pH readings for temperature
+	PHTempComp PHTempCompConfig `json:"ph_temp_comp"`
+
 	// MoistureGain is the gain to use with the moisture probe this should be 1,2,4 or 8
 	MoistureGain int `json:"moisture_gain"`
 
 	DrippersPerPlant int `json:"drippers_per_plant"`
 	RunoffDrippers   int `json:"runoff_drippers"`
 	IrrigDrippers    int `json:"irrig_drippers"`
+
+	// HistoryInterval is how often in seconds the readings are saved to the history
+	HistoryInterval int `json:"history_interval"`
+
+	// HistoryRetention is how many days the readings history is kept for
+	HistoryRetention int `json:"history_retention"`
+
+	// IrrigationQuiet is how many seconds without irrigation tips it takes for the
+	// next tip to start a new irrigation event
+	IrrigationQuiet int `json:"irrigation_quiet"`
+
+	// RunoffTimeout is how many seconds without any tips it takes for an irrigation
+	// event to be considered finished
+	RunoffTimeout int `json:"runoff_timeout"`
+
+	// DayStartHour is the hour of the day (0-23) that the days of the daily report start at
+	DayStartHour int `json:"day_start_hour"`
+
+	// Webhooks are the URLs that alerts and device events are posted to
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// MQTT contains the settings for publishing the readings to an MQTT broker
+	MQTT MQTTConfig `json:"mqtt"`
+
+	// Influx contains the settings for writing the readings to InfluxDB
+	Influx InfluxConfig `json:"influx"`
+
+	// Modbus contains the settings for the Modbus TCP server
+	Modbus ModbusConfig `json:"modbus"`
+
+	// Simulate contains the settings for running without the hat
+	Simulate SimulateConfig `json:"simulate"`
+}
+
+// SimulateConfig is the configuration for the hardware simulation mode
+type SimulateConfig struct {
+	// Enabled replaces the hat hardware with simulated devices
+	Enabled bool `json:"enabled"`
+
+	// Scenario is the path to the scenario file to simulate, the default scenario
+	// will be used if this is empty
+	Scenario string `json:"scenario"`
+}
+
+// historyInterval returns how often the readings should be saved to the history
+func (cfg *Config) historyInterval() time.Duration {
+	if cfg.HistoryInterval <= 0 {
+		return DefaultHistoryInterval
+	}
+
+	return time.Duration(cfg.HistoryInterval) * time.Second
+}
+
+// historyRetention returns how long the readings history should be kept for
+func (cfg *Config) historyRetention() time.Duration {
+	if cfg.HistoryRetention <= 0 {
+		return DefaultHistoryRetention
+	}
+
+	return time.Duration(cfg.HistoryRetention) * 24 * time.Hour
+}
+
+// irrigationQuiet returns the quiet period that separates irrigation events
+func (cfg *Config) irrigationQuiet() time.Duration {
+	if cfg.IrrigationQuiet <= 0 {
+		return DefaultIrrigationQuiet
+	}
+
+	return time.Duration(cfg.IrrigationQuiet) * time.Second
+}
+
+// runoffTimeout returns how long an irrigation event waits for more runoff
+func (cfg *Config) runoffTimeout() time.Duration {
+	if cfg.RunoffTimeout <= 0 {
+		return DefaultRunoffTimeout
+	}
+
+	return time.Duration(cfg.RunoffTimeout) * time.Second
+}
+
+// validate checks that the settings are within their ranges
+func (cfg *Config) validate() error {
+	if cfg.Port != "" {
+		if p, err := strconv.Atoi(cfg.Port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("port must be a number from 1 to 65535")
+		}
+	}
+
+	switch cfg.MoistureGain {
+	case 0, 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("moisture_gain must be 1, 2, 4 or 8")
+	}
+
+	if err := cfg.validateDayStart(); err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		name string
+		v    int
+	}{
+		{"tb_debounce", cfg.TBDebounce},
+		{"scan_timeout", cfg.ScanTimeout},
+		{"drippers_per_plant", cfg.DrippersPerPlant},
+		{"runoff_drippers", cfg.RunoffDrippers},
+		{"irrig_drippers", cfg.IrrigDrippers},
+		{"history_interval", cfg.HistoryInterval},
+		{"history_retention", cfg.HistoryRetention},
+		{"irrigation_quiet", cfg.IrrigationQuiet},
+		{"runoff_timeout", cfg.RunoffTimeout},
+	} {
+		if f.v < 0 {
+			return fmt.Errorf("%s can't be negative", f.name)
+		}
+	}
+
+	for _, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			return fmt.Errorf("webhooks must have a url")
+		}
+	}
+
+	return nil
+}
+
+// validateDayStart checks that the day starts within the day, the daily log
+// can't be kept otherwise
+func (cfg *Config) validateDayStart() error {
+	if cfg.DayStartHour < 0 || cfg.DayStartHour > 23 {
+		return fmt.Errorf("day_start_hour must be from 0 to 23")
+	}
+
+	return nil
 }
 
 // AssignProbeSerials assigns the probes in a way that preserves the order that the
@@ -72,14 +219,19 @@ func (cfg *Config) AssignProbeSerials(serials ...string) {
 	cfg.RunoffECProbe = r
 }
 
-// LoadFrom will load the config from the given filename
+// LoadFrom will load the config from the given filename, checking that the
+// day starts within the day
 func (cfg *Config) LoadFrom(fn string) error {
 	data, err := ioutil.ReadFile(fn)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(data, cfg)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+
+	return cfg.validateDayStart()
 }
 
 // SaveTo will load the config from the given filename
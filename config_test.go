@@ -1,6 +1,8 @@
 package openminder
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -114,3 +116,32 @@ func TestAssignProbeSerials(t *testing.T) {
 
 	})
 }
+
+func TestLoadConfig(t *testing.T) {
+	Convey("given a config file", t, func() {
+		fn := filepath.Join(t.TempDir(), "config.json")
+
+		Convey("it should be loaded", func() {
+			So(ioutil.WriteFile(fn, []byte(`{"port": "3232", "day_start_hour": 6}`), 0644), ShouldBeNil)
+
+			cfg := Config{}
+			So(cfg.LoadFrom(fn), ShouldBeNil)
+			So(cfg.DayStartHour, ShouldEqual, 6)
+		})
+
+		Convey("it should not be loaded with a day that starts outside of the day", func() {
+			So(ioutil.WriteFile(fn, []byte(`{"port": "3232", "day_start_hour": 24}`), 0644), ShouldBeNil)
+
+			cfg := Config{}
+			So(cfg.LoadFrom(fn), ShouldNotBeNil)
+		})
+
+		Convey("it should still be loaded with settings that are out of range", func() {
+			So(ioutil.WriteFile(fn, []byte(`{"moisture_gain": 3, "webhooks": [{"events": ["tip"]}]}`), 0644), ShouldBeNil)
+
+			cfg := Config{}
+			So(cfg.LoadFrom(fn), ShouldBeNil)
+			So(cfg.MoistureGain, ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package openminder
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// countersKey is the key the counters are stored under in the database
+const countersKey = "counters"
+
+// counterFlushInterval is how often changes to the counters are written to the database
+var counterFlushInterval = 10 * time.Second
+
+// Counters are the tip counts that are kept across restarts
+type Counters struct {
+	IrrigTips  int64     `json:"irrig_tips"`
+	RunoffTips int64     `json:"runoff_tips"`
+	ResetAt    time.Time `json:"reset_at"`
+
+	// IrrigAdjust and RunoffAdjust are the volumes in mL that the rate correction
+	// of the tipping buckets has added to the tips
+	IrrigAdjust  float64 `json:"irrig_adjust,omitempty"`
+	RunoffAdjust float64 `json:"runoff_adjust,omitempty"`
+}
+
+// counterStore keeps the counters in memory and writes them to the database
+// in batches so that every tip doesn't cause a write
+type counterStore struct {
+	jdb      *BoltedJSON
+	counters Counters
+	dirty    bool
+	mu       *sync.Mutex
+}
+
+// newCounterStore returns a counter store with the counters restored from the
+// given database, if there are no counters stored yet they start from now
+func newCounterStore(jdb *BoltedJSON) *counterStore {
+	cs := &counterStore{jdb: jdb, mu: new(sync.Mutex)}
+
+	if err := jdb.Get(countersKey, &cs.counters); err != nil {
+		cs.counters = Counters{ResetAt: time.Now()}
+		cs.dirty = true
+	}
+
+	return cs
+}
+
+// Counters returns a copy of the current counters
+func (cs *counterStore) Counters() Counters {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.counters
+}
+
+// AddIrrigTip increments the irrigation tip count and returns the new count
+func (cs *counterStore) AddIrrigTip() int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counters.IrrigTips++
+	cs.dirty = true
+	return cs.counters.IrrigTips
+}
+
+// AddRunoffTip increments the runoff tip count and returns the new count
+func (cs *counterStore) AddRunoffTip() int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counters.RunoffTips++
+	cs.dirty = true
+	return cs.counters.RunoffTips
+}
+
+// AddIrrigAdjust adds to the irrigation volume adjustment and returns the new total
+func (cs *counterStore) AddIrrigAdjust(ml float64) float64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counters.IrrigAdjust += ml
+	cs.dirty = true
+	return cs.counters.IrrigAdjust
+}
+
+// AddRunoffAdjust adds to the runoff volume adjustment and returns the new total
+func (cs *counterStore) AddRunoffAdjust(ml float64) float64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.counters.RunoffAdjust += ml
+	cs.dirty = true
+	return cs.counters.RunoffAdjust
+}
+
+// Reset zeros the counters, starting a new epoch from now, and writes them
+// to the database straight away
+func (cs *counterStore) Reset() (Counters, error) {
+	cs.mu.Lock()
+	old := cs.counters
+	cs.counters = Counters{ResetAt: time.Now()}
+	cs.dirty = true
+	cs.mu.Unlock()
+
+	log.Printf("counters reset at %s (irrig tips: %d, runoff tips: %d since %s)",
+		cs.counters.ResetAt.Format(time.RFC3339), old.IrrigTips, old.RunoffTips, old.ResetAt.Format(time.RFC3339))
+
+	return cs.Counters(), cs.Flush()
+}
+
+// Flush writes the counters to the database if they have changed
+func (cs *counterStore) Flush() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if !cs.dirty {
+		return nil
+	}
+
+	if err := cs.jdb.Set(countersKey, cs.counters); err != nil {
+		return err
+	}
+
+	cs.dirty = false
+	return nil
+}
+
+// run flushes the counters to the database at the flush interval
+func (cs *counterStore) run(onError func(error)) {
+	for {
+		time.Sleep(counterFlushInterval)
+		if err := cs.Flush(); err != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCounterStore(t *testing.T) {
+	Convey("given an empty database", t, func() {
+		jdb := newTestDB(t)
+
+		Convey("when a counter store is created", func() {
+			start := time.Now()
+			cs := newCounterStore(jdb)
+
+			Convey("the counters should start at zero from now", func() {
+				c := cs.Counters()
+				So(c.IrrigTips, ShouldEqual, 0)
+				So(c.RunoffTips, ShouldEqual, 0)
+				So(c.ResetAt, ShouldHappenOnOrAfter, start)
+			})
+
+			Convey("and tips are added and flushed", func() {
+				So(cs.AddIrrigTip(), ShouldEqual, 1)
+				So(cs.AddIrrigTip(), ShouldEqual, 2)
+				So(cs.AddRunoffTip(), ShouldEqual, 1)
+				So(cs.Flush(), ShouldBeNil)
+
+				Convey("a new store should restore them", func() {
+					c := newCounterStore(jdb).Counters()
+					So(c.IrrigTips, ShouldEqual, 2)
+					So(c.RunoffTips, ShouldEqual, 1)
+					So(c.ResetAt.Equal(cs.Counters().ResetAt), ShouldBeTrue)
+				})
+
+				Convey("and the counters are reset", func() {
+					resetAt := time.Now()
+					_, err := cs.Reset()
+					So(err, ShouldBeNil)
+
+					Convey("a new store should restore the reset counters", func() {
+						c := newCounterStore(jdb).Counters()
+						So(c.IrrigTips, ShouldEqual, 0)
+						So(c.RunoffTips, ShouldEqual, 0)
+						So(c.ResetAt, ShouldHappenOnOrAfter, resetAt)
+					})
+				})
+			})
+
+			Convey("and tips are added without flushing", func() {
+				cs.AddIrrigTip()
+
+				Convey("a new store should not have them yet", func() {
+					So(newCounterStore(jdb).Counters().IrrigTips, ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
+
+func TestTipVolumes(t *testing.T) {
+	Convey("given a minder with a calibrated irrigation tipping bucket", t, func() {
+		jdb := newTestDB(t)
+
+		mdr := &Minder{
+			Readings: newReadings(),
+			cfg:      &Config{},
+			tr:       &Translater{jdb},
+			counters: newCounterStore(jdb),
+			errors:   newErrorStore(),
+		}
+		So(jdb.Set("irrig_volume", calibration{Scale: 5, RateRef: 10, RateCoef: 0.1}), ShouldBeNil)
+
+		Convey("the runoff bucket should use it until it is calibrated", func() {
+			So(mdr.runoffVolume(10), ShouldEqual, 50)
+
+			So(jdb.Set("runoff_volume", calibration{Scale: 4}), ShouldBeNil)
+			So(mdr.runoffVolume(10), ShouldEqual, 40)
+			So(mdr.irrigVolume(10), ShouldEqual, 50)
+		})
+
+		Convey("tips faster than the reference rate should hold more", func() {
+			So(mdr.tipAdjust("irrig_volume", 20), ShouldAlmostEqual, 1)
+			So(mdr.tipAdjust("irrig_volume", 5), ShouldAlmostEqual, -0.5)
+			So(mdr.tipAdjust("runoff_volume", 20), ShouldEqual, 0)
+			So(calibration{Scale: 5, RateRef: 100, RateCoef: 0.1}.tipAdjust(1), ShouldAlmostEqual, -5)
+
+			Convey("but not before the rate is known", func() {
+				So(mdr.tipAdjust("irrig_volume", 0), ShouldEqual, 0)
+			})
+
+			mdr.Readings.IrrigTips = mdr.counters.AddIrrigTip()
+			mdr.counters.AddIrrigAdjust(mdr.tipAdjust("irrig_volume", 20))
+			mdr.updateIrrigVolume()
+			So(mdr.Readings.IrrigVolume, ShouldAlmostEqual, 6)
+
+			Convey("and the irrigation events and daily report should add it too", func() {
+				ev := Irrigation{IrrigTips: 2, irrigAdjust: 1.5}
+				mdr.fillIrrigation(&ev)
+				So(ev.IrrigVolume, ShouldAlmostEqual, 11.5)
+
+				rpt := DailyReport{IrrigTips: 2, RunoffTips: 1, irrigAdjust: 1.5, runoffAdjust: -0.5}
+				mdr.fillDailyReport(&rpt)
+				So(rpt.IrrigVolume, ShouldAlmostEqual, 11.5)
+				So(rpt.RunoffVolume, ShouldAlmostEqual, 4.5)
+			})
+		})
+	})
+}
@@ -0,0 +1,206 @@
+package openminder
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/types"
+	"github.com/boltdb/bolt"
+)
+
+// maxReportDays is the most days that the daily report can be asked for
+const maxReportDays = 366
+
+// DailyReport is the water balance for a day
+type DailyReport struct {
+	Day            time.Time        `json:"day"`
+	IrrigTips      int64            `json:"irrig_tips"`
+	RunoffTips     int64            `json:"runoff_tips"`
+	IrrigVolume    float64          `json:"irrig_volume"`
+	RunoffVolume   float64          `json:"runoff_volume"`
+	RunoffRatio    float64          `json:"runoff_ratio"`
+	IrrigPerPlant  *types.NullFloat `json:"irrig_per_plant"`
+	RunoffPerPlant *types.NullFloat `json:"runoff_per_plant"`
+	UptakePerPlant *types.NullFloat `json:"uptake_per_plant"`
+
+	// the volumes in mL that the rate correction of the tips adds
+	irrigAdjust  float64
+	runoffAdjust float64
+}
+
+// dailyTips are the tip counts stored for each day, with the volumes in mL
+// that the rate correction of the tips adds
+type dailyTips struct {
+	IrrigTips    int64   `json:"irrig_tips"`
+	RunoffTips   int64   `json:"runoff_tips"`
+	IrrigAdjust  float64 `json:"irrig_adjust,omitempty"`
+	RunoffAdjust float64 `json:"runoff_adjust,omitempty"`
+}
+
+// dailyLog keeps the tip counts for each day in a bolt bucket, the counts for
+// the current day are kept in memory and written in batches
+type dailyLog struct {
+	db        *bolt.DB
+	bucket    []byte
+	startHour int
+	day       time.Time
+	tips      dailyTips
+	dirty     bool
+	mu        *sync.Mutex
+}
+
+// newDailyLog returns a daily log where each day starts at the given hour
+func newDailyLog(db *bolt.DB, startHour int) (*dailyLog, error) {
+	dl := &dailyLog{db: db, bucket: []byte("daily"), startHour: startHour, mu: new(sync.Mutex)}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dl.bucket)
+		return err
+	})
+
+	if err != nil {
+		return dl, err
+	}
+
+	return dl, dl.switchDay(dl.dayStart(time.Now()))
+}
+
+// dayStart returns the start of the day the given time is in
+func (dl *dailyLog) dayStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, dl.startHour, 0, 0, 0, t.Location())
+	if t.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+
+	return start
+}
+
+// switchDay saves the current day and loads the given one
+func (dl *dailyLog) switchDay(day time.Time) error {
+	if err := dl.flush(); err != nil {
+		return err
+	}
+
+	dl.day = day
+	dl.tips = dailyTips{}
+
+	return dl.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(dl.bucket).Get(timeKey(day))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &dl.tips)
+	})
+}
+
+// AddIrrigTip adds an irrigation tip at the given time with the volume its rate
+// correction adds
+func (dl *dailyLog) AddIrrigTip(t time.Time, adjust float64) error {
+	return dl.add(t, func(tips *dailyTips) {
+		tips.IrrigTips++
+		tips.IrrigAdjust += adjust
+	})
+}
+
+// AddRunoffTip adds a runoff tip at the given time with the volume its rate
+// correction adds
+func (dl *dailyLog) AddRunoffTip(t time.Time, adjust float64) error {
+	return dl.add(t, func(tips *dailyTips) {
+		tips.RunoffTips++
+		tips.RunoffAdjust += adjust
+	})
+}
+
+func (dl *dailyLog) add(t time.Time, inc func(*dailyTips)) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	var err error
+	if day := dl.dayStart(t); !day.Equal(dl.day) {
+		err = dl.switchDay(day)
+	}
+
+	inc(&dl.tips)
+	dl.dirty = true
+	return err
+}
+
+// Flush writes the counts for the current day to the database if they have changed
+func (dl *dailyLog) Flush() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.flush()
+}
+
+func (dl *dailyLog) flush() error {
+	if !dl.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(dl.tips)
+	if err != nil {
+		return err
+	}
+
+	err = dl.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dl.bucket).Put(timeKey(dl.day), data)
+	})
+
+	if err == nil {
+		dl.dirty = false
+	}
+
+	return err
+}
+
+// run flushes the counts to the database at the counter flush interval
+func (dl *dailyLog) run(onError func(error)) {
+	for {
+		time.Sleep(counterFlushInterval)
+		if err := dl.Flush(); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// Days returns the tip counts for the given number of days up to and including
+// the day that the given time is in, oldest first
+func (dl *dailyLog) Days(now time.Time, days int) ([]DailyReport, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	reports := []DailyReport{}
+	last := dl.dayStart(now)
+
+	err := dl.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dl.bucket)
+
+		for i := days - 1; i >= 0; i-- {
+			day := last.AddDate(0, 0, -i)
+			tips := dailyTips{}
+
+			if day.Equal(dl.day) {
+				tips = dl.tips
+			} else if data := b.Get(timeKey(day)); data != nil {
+				if err := json.Unmarshal(data, &tips); err != nil {
+					return err
+				}
+			}
+
+			reports = append(reports, DailyReport{
+				Day:          day,
+				IrrigTips:    tips.IrrigTips,
+				RunoffTips:   tips.RunoffTips,
+				irrigAdjust:  tips.IrrigAdjust,
+				runoffAdjust: tips.RunoffAdjust,
+			})
+		}
+
+		return nil
+	})
+
+	return reports, err
+}
@@ -0,0 +1,97 @@
+package openminder
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDailyLog(t *testing.T) {
+	Convey("given a daily log with days starting at 6am", t, func() {
+		jdb := newTestDB(t)
+
+		dl, err := newDailyLog(jdb.db, 6)
+		So(err, ShouldBeNil)
+
+		day := time.Date(2018, 3, 10, 6, 0, 0, 0, time.Local)
+
+		Convey("the day should start at 6am", func() {
+			So(dl.dayStart(day.Add(time.Hour)).Equal(day), ShouldBeTrue)
+			So(dl.dayStart(day.Add(23*time.Hour)).Equal(day), ShouldBeTrue)
+			So(dl.dayStart(day.Add(-time.Hour)).Equal(day.AddDate(0, 0, -1)), ShouldBeTrue)
+		})
+
+		Convey("when tips are added over two days", func() {
+			So(dl.AddIrrigTip(day.Add(-time.Hour), 0), ShouldBeNil)
+			So(dl.AddIrrigTip(day.Add(time.Hour), 0), ShouldBeNil)
+			So(dl.AddIrrigTip(day.Add(2*time.Hour), 0), ShouldBeNil)
+			So(dl.AddRunoffTip(day.Add(3*time.Hour), 0), ShouldBeNil)
+
+			Convey("it should report the tips for each day, oldest first", func() {
+				reports, err := dl.Days(day.Add(12*time.Hour), 3)
+				So(err, ShouldBeNil)
+				So(reports, ShouldHaveLength, 3)
+				So(reports[0].Day.Equal(day.AddDate(0, 0, -2)), ShouldBeTrue)
+				So(reports[0].IrrigTips, ShouldEqual, 0)
+				So(reports[1].IrrigTips, ShouldEqual, 1)
+				So(reports[2].IrrigTips, ShouldEqual, 2)
+				So(reports[2].RunoffTips, ShouldEqual, 1)
+			})
+
+			Convey("and the log is flushed and reopened", func() {
+				So(dl.Flush(), ShouldBeNil)
+				dl, err = newDailyLog(jdb.db, 6)
+				So(err, ShouldBeNil)
+
+				Convey("it should carry on from the saved counts", func() {
+					So(dl.AddIrrigTip(day.Add(4*time.Hour), 0.5), ShouldBeNil)
+
+					reports, err := dl.Days(day.Add(12*time.Hour), 2)
+					So(err, ShouldBeNil)
+					So(reports[0].IrrigTips, ShouldEqual, 1)
+					So(reports[1].IrrigTips, ShouldEqual, 3)
+					So(reports[1].RunoffTips, ShouldEqual, 1)
+					So(reports[1].irrigAdjust, ShouldEqual, 0.5)
+				})
+			})
+		})
+	})
+}
+
+func TestDailyReportHandler(t *testing.T) {
+	Convey("given a minder serving the daily report", t, func() {
+		jdb := newTestDB(t)
+
+		dl, err := newDailyLog(jdb.db, 0)
+		So(err, ShouldBeNil)
+
+		mdr := &Minder{
+			tr:     &Translater{jdb},
+			cfg:    &Config{},
+			daily:  dl,
+			errors: newErrorStore(),
+		}
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/reports/daily", mdr.dailyReportHandler())
+
+		get := func(path string) int {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return w.Code
+		}
+
+		Convey("the days should have to be within the limit", func() {
+			So(get("/reports/daily?days=7"), ShouldEqual, 200)
+			So(get(fmt.Sprintf("/reports/daily?days=%d", maxReportDays)), ShouldEqual, 200)
+			So(get(fmt.Sprintf("/reports/daily?days=%d", maxReportDays+1)), ShouldEqual, 400)
+			So(get("/reports/daily?days=100000000"), ShouldEqual, 400)
+			So(get("/reports/daily?days=0"), ShouldEqual, 400)
+		})
+	})
+}
@@ -0,0 +1,20 @@
+package openminder
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB returns a database in a temporary directory that is closed and
+// removed when the test finishes
+func newTestDB(t *testing.T) *BoltedJSON {
+	t.Helper()
+
+	jdb, err := NewBoltedJSON(filepath.Join(t.TempDir(), "test.db"), "minder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jdb.db.Close() })
+
+	return jdb
+}
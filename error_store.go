@@ -27,6 +27,13 @@ func (es *errorStore) Add(err error) {
 	es.store[err.Error()] = time.Now()
 }
 
+// Len returns the number of errors in the store
+func (es *errorStore) Len() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return len(es.store)
+}
+
 func (es *errorStore) Map() map[string]string {
 	data := map[string]string{}
 	for msg, t := range es.store {
@@ -0,0 +1,96 @@
+package openminder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/autogrow/openminder/aslbus"
+)
+
+// The I2C addresses of the ADCs on the OpenMinder hat
+const (
+	IrrigPHADCAddr  = 0x68
+	RunoffPHADCAddr = 0x69
+	MoistureADCAddr = 0x70
+)
+
+// Hardware provides the devices that the minder takes its readings from
+type Hardware interface {
+	// ADC returns the ADC at the given I2C address set to the given gain
+	ADC(addr, gain int) (ADC, error)
+
+	// ContactSwitch returns the contact switch on the given GPIO pin, using edge
+	// detection with the given debounce window if edges is true
+	ContactSwitch(pin string, edges bool, debounce time.Duration) (ContactSwitch, error)
+
+	// Bus returns the ASL bus on the given TTY
+	Bus(tty string) *aslbus.Bus
+}
+
+// HatHardware returns the hardware found on the OpenMinder hat
+func HatHardware() Hardware {
+	return hatHardware{}
+}
+
+type hatHardware struct{}
+
+func (hatHardware) ADC(addr, gain int) (ADC, error) {
+	adc, err := NewMPC3421(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if gain == 1 {
+		return adc, nil
+	}
+
+	if err := adc.SetGain(gain); err != nil {
+		return nil, fmt.Errorf("adc gain error: %s", err)
+	}
+
+	return adc, nil
+}
+
+func (hatHardware) ContactSwitch(pin string, edges bool, debounce time.Duration) (ContactSwitch, error) {
+	if edges {
+		return NewEdgeContactClosure(pin, debounce)
+	}
+
+	return NewContactClosure(pin)
+}
+
+func (hatHardware) Bus(tty string) *aslbus.Bus {
+	return aslbus.New(tty)
+}
+
+// WithBusCapture wraps the given hardware so that the frames seen on its bus
+// are recorded to the given capture
+func WithBusCapture(hw Hardware, c *aslbus.Capture) Hardware {
+	return captureHardware{hw, c}
+}
+
+type captureHardware struct {
+	Hardware
+	capture *aslbus.Capture
+}
+
+func (hw captureHardware) Bus(tty string) *aslbus.Bus {
+	bus := hw.Hardware.Bus(tty)
+	bus.SetCapture(hw.capture)
+	return bus
+}
+
+// WithBusReplay wraps the given hardware so that its bus plays back the given
+// replay instead of using the TTY
+func WithBusReplay(hw Hardware, rp *aslbus.Replay) Hardware {
+	return replayHardware{hw, rp}
+}
+
+type replayHardware struct {
+	Hardware
+	replay *aslbus.Replay
+}
+
+func (hw replayHardware) Bus(tty string) *aslbus.Bus {
+	return aslbus.NewWithOpener(tty, hw.replay.Opener())
+}
@@ -0,0 +1,196 @@
+package openminder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// The defaults for how often readings are added to the history and how long they are kept for
+const (
+	DefaultHistoryInterval  = time.Minute
+	DefaultHistoryRetention = 30 * 24 * time.Hour
+)
+
+// Sample is the values of the readings at a point in time
+type Sample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// History stores samples of the readings keyed by time in a bolt bucket, along
+// with rollups of the readings over each minute, hour and day
+type History struct {
+	db        *bolt.DB
+	bucket    []byte
+	rollups   []*rollup
+	mu        *sync.Mutex
+	Retention time.Duration
+}
+
+// NewHistory returns a history stored in the given bolt database that keeps
+// samples for the given retention period
+func NewHistory(db *bolt.DB, retention time.Duration) (*History, error) {
+	h := &History{db: db, bucket: []byte("history"), mu: new(sync.Mutex), Retention: retention}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(h.bucket)
+		return err
+	})
+
+	if err != nil {
+		return h, err
+	}
+
+	return h, h.initRollups()
+}
+
+// Add will add the given readings to the history at the given time, and remove
+// any samples that are older than the retention period
+func (h *History) Add(t time.Time, r *Readings) error {
+	data, err := json.Marshal(r.Values())
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(h.bucket)
+		if err := b.Put(timeKey(t), data); err != nil {
+			return err
+		}
+
+		if h.Retention <= 0 {
+			return nil
+		}
+
+		return prune(b, t.Add(-h.Retention))
+	})
+}
+
+// Query returns the samples between from and to (inclusive) with only the given
+// fields, or all of them if none are given.  If step is given the samples are
+// averaged over each step.
+func (h *History) Query(fields []string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	samples := []Sample{}
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(h.bucket).Cursor()
+		end := timeKey(to)
+
+		for k, v := c.Seek(timeKey(from)); k != nil && string(k) <= string(end); k, v = c.Next() {
+			values := map[string]float64{}
+			if err := json.Unmarshal(v, &values); err != nil {
+				return err
+			}
+
+			samples = append(samples, Sample{keyTime(k), filterValues(values, fields)})
+		}
+
+		return nil
+	})
+
+	if err != nil || step <= 0 {
+		return samples, err
+	}
+
+	return downsample(samples, step), nil
+}
+
+// prune removes the samples in the bucket before the given time
+func prune(b *bolt.Bucket, before time.Time) error {
+	c := b.Cursor()
+	cutoff := timeKey(before)
+
+	for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downsample averages the samples over each step
+func downsample(samples []Sample, step time.Duration) []Sample {
+	out := []Sample{}
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	var start time.Time
+
+	flush := func() {
+		if len(counts) == 0 {
+			return
+		}
+
+		values := map[string]float64{}
+		for f, sum := range sums {
+			values[f] = sum / float64(counts[f])
+		}
+
+		out = append(out, Sample{start, values})
+		sums = map[string]float64{}
+		counts = map[string]int{}
+	}
+
+	for _, s := range samples {
+		t := s.Time.Truncate(step)
+		if !t.Equal(start) {
+			flush()
+			start = t
+		}
+
+		for f, v := range s.Values {
+			sums[f] += v
+			counts[f]++
+		}
+	}
+
+	flush()
+	return out
+}
+
+func filterValues(values map[string]float64, fields []string) map[string]float64 {
+	if len(fields) == 0 {
+		return values
+	}
+
+	out := map[string]float64{}
+	for _, f := range fields {
+		if v, ok := values[f]; ok {
+			out[f] = v
+		}
+	}
+
+	return out
+}
+
+// timeKey returns a key for the time that sorts in time order
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+}
+
+// checkReadingFields returns an error for the first field that isn't a numeric reading
+func checkReadingFields(fields []string) error {
+	known := map[string]bool{}
+	for _, f := range ReadingFields() {
+		known[f] = true
+	}
+
+	for _, f := range fields {
+		if !known[f] {
+			return fmt.Errorf("unknown field: %s", f)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+	Convey("given a history with a day of retention", t, func() {
+		jdb := newTestDB(t)
+
+		h, err := NewHistory(jdb.db, 24*time.Hour)
+		So(err, ShouldBeNil)
+
+		start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		Convey("when readings are added every minute for an hour", func() {
+			r := newReadings()
+			for i := 0; i < 60; i++ {
+				r.IrrigPH = float64(i)
+				r.IrrigEC.SetValue(2)
+				So(h.Add(start.Add(time.Duration(i)*time.Minute), r), ShouldBeNil)
+			}
+
+			Convey("it should return the samples in the time range", func() {
+				samples, err := h.Query(nil, start.Add(10*time.Minute), start.Add(19*time.Minute), 0)
+				So(err, ShouldBeNil)
+				So(samples, ShouldHaveLength, 10)
+				So(samples[0].Time.Equal(start.Add(10*time.Minute)), ShouldBeTrue)
+				So(samples[0].Values["irrig_ph"], ShouldEqual, 10)
+				So(samples[9].Values["irrig_ph"], ShouldEqual, 19)
+			})
+
+			Convey("it should only return the asked for fields", func() {
+				samples, err := h.Query([]string{"irrig_ec"}, start, start.Add(time.Hour), 0)
+				So(err, ShouldBeNil)
+				So(samples, ShouldHaveLength, 60)
+				So(samples[0].Values, ShouldResemble, map[string]float64{"irrig_ec": 2})
+			})
+
+			Convey("it should average the samples over the step", func() {
+				samples, err := h.Query([]string{"irrig_ph"}, start, start.Add(time.Hour), 10*time.Minute)
+				So(err, ShouldBeNil)
+				So(samples, ShouldHaveLength, 6)
+				So(samples[0].Values["irrig_ph"], ShouldEqual, 4.5)
+				So(samples[5].Time.Equal(start.Add(50*time.Minute)), ShouldBeTrue)
+				So(samples[5].Values["irrig_ph"], ShouldEqual, 54.5)
+			})
+
+			Convey("and a reading is added after the retention period", func() {
+				So(h.Add(start.Add(24*time.Hour+30*time.Minute), r), ShouldBeNil)
+
+				Convey("the older samples should be removed", func() {
+					samples, err := h.Query(nil, start, start.Add(48*time.Hour), 0)
+					So(err, ShouldBeNil)
+					So(samples, ShouldHaveLength, 31)
+					So(samples[0].Time.Equal(start.Add(30*time.Minute)), ShouldBeTrue)
+				})
+			})
+		})
+	})
+}
+
+func TestReadingValues(t *testing.T) {
+	Convey("given some readings with an invalid EC", t, func() {
+		r := newReadings()
+		r.IrrigTips = 3
+		r.RunoffEC.SetValue(1.5)
+
+		Convey("the values should leave out the invalid reading", func() {
+			v := r.Values()
+			So(v["irrig_tips"], ShouldEqual, 3)
+			So(v["runoff_ec"], ShouldEqual, 1.5)
+			So(v, ShouldNotContainKey, "irrig_ec")
+			So(v, ShouldNotContainKey, "counters_reset")
+		})
+	})
+
+	Convey("the reading fields should be checked", t, func() {
+		So(checkReadingFields([]string{"irrig_ec", "runoff_ph"}), ShouldBeNil)
+		So(checkReadingFields([]string{"irrig_ec", "nope"}), ShouldNotBeNil)
+	})
+}
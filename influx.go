@@ -0,0 +1,405 @@
+package openminder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// maxInfluxBacklog is the most batches that are kept on disk while the InfluxDB
+// server can't be reached, a week of batches at the default flush interval
+const maxInfluxBacklog = 7 * 24 * 60
+
+// InfluxConfig is the configuration for writing the readings to InfluxDB
+type InfluxConfig struct {
+	// URL is the address of the InfluxDB server, e.g. http://localhost:8086, the
+	// readings are not written if this is empty
+	URL string `json:"url"`
+
+	// Version is the InfluxDB API version to use, 1 or 2, defaults to 1
+	Version int `json:"version"`
+
+	// Database, RetentionPolicy, Username and Password are used with version 1
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+
+	// Org, Bucket and Token are used with version 2
+	Org    string `json:"org"`
+	Bucket string `json:"bucket"`
+	Token  string `json:"token"`
+
+	// Measurement is the name of the measurement, defaults to openminder
+	Measurement string `json:"measurement"`
+
+	// Device is put in the device tag, defaults to the hostname
+	Device string `json:"device"`
+
+	// Interval is how often in seconds the readings are sampled, defaults to 10
+	Interval int `json:"interval"`
+
+	// FlushInterval is how often in seconds the samples are written, defaults to 60
+	FlushInterval int `json:"flush_interval"`
+
+	// Include and Exclude are lists of reading name patterns (e.g. *_adc) to
+	// write or skip, all the readings are written if Include is empty
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+func (cfg InfluxConfig) withDefaults() InfluxConfig {
+	if cfg.Version == 0 {
+		cfg.Version = 1
+	}
+
+	if cfg.Measurement == "" {
+		cfg.Measurement = "openminder"
+	}
+
+	if cfg.Device == "" {
+		cfg.Device, _ = os.Hostname()
+	}
+
+	if cfg.Device == "" {
+		cfg.Device = "minder"
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 60
+	}
+
+	return cfg
+}
+
+// wants returns true if the reading should be written
+func (cfg InfluxConfig) wants(field string) bool {
+	for _, p := range cfg.Exclude {
+		if ok, _ := path.Match(p, field); ok {
+			return false
+		}
+	}
+
+	if len(cfg.Include) == 0 {
+		return true
+	}
+
+	for _, p := range cfg.Include {
+		if ok, _ := path.Match(p, field); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeURL returns the URL to write the line protocol to
+func (cfg InfluxConfig) writeURL() string {
+	q := url.Values{}
+
+	if cfg.Version == 2 {
+		q.Set("org", cfg.Org)
+		q.Set("bucket", cfg.Bucket)
+		q.Set("precision", "s")
+		return strings.TrimRight(cfg.URL, "/") + "/api/v2/write?" + q.Encode()
+	}
+
+	q.Set("db", cfg.Database)
+	if cfg.RetentionPolicy != "" {
+		q.Set("rp", cfg.RetentionPolicy)
+	}
+	q.Set("precision", "s")
+	return strings.TrimRight(cfg.URL, "/") + "/write?" + q.Encode()
+}
+
+// the sides of the minder that the readings are tagged with
+var influxSides = []string{"irrig", "runoff"}
+
+// influxWriter samples the readings into batches of line protocol and writes
+// them to InfluxDB, keeping the batches on disk until they are written so
+// they survive the server being down
+type influxWriter struct {
+	cfg     InfluxConfig
+	db      *bolt.DB
+	bucket  []byte
+	client  *http.Client
+	values  func() map[string]float64
+	probes  func() map[string]string
+	pending *bytes.Buffer
+	mu      *sync.Mutex
+}
+
+// newInfluxWriter creates a new writer, probes should return the EC probe
+// serial of each side
+func newInfluxWriter(db *bolt.DB, cfg InfluxConfig, values func() map[string]float64, probes func() map[string]string) (*influxWriter, error) {
+	w := &influxWriter{
+		cfg:     cfg.withDefaults(),
+		db:      db,
+		bucket:  []byte("influx_backlog"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		values:  values,
+		probes:  probes,
+		pending: new(bytes.Buffer),
+		mu:      new(sync.Mutex),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(w.bucket)
+		return err
+	})
+
+	return w, err
+}
+
+// Sample adds the current readings to the pending batch, a line is written for
+// each side with the side prefix taken off the field names
+func (w *influxWriter) Sample(t time.Time) {
+	fields := map[string]map[string]float64{}
+
+	for name, v := range w.values() {
+		if !w.cfg.wants(name) || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		side := ""
+		for _, s := range influxSides {
+			if strings.HasPrefix(name, s+"_") {
+				side = s
+				name = strings.TrimPrefix(name, s+"_")
+			}
+		}
+
+		if fields[side] == nil {
+			fields[side] = map[string]float64{}
+		}
+		fields[side][name] = v
+	}
+
+	probes := w.probes()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, side := range append([]string{""}, influxSides...) {
+		if len(fields[side]) == 0 {
+			continue
+		}
+
+		tags := map[string]string{"device": w.cfg.Device}
+		if side != "" {
+			tags["side"] = side
+		}
+		if sn := probes[side]; sn != "" {
+			tags["probe"] = sn
+		}
+
+		w.pending.WriteString(lineProtocol(w.cfg.Measurement, tags, fields[side], t))
+	}
+}
+
+// Flush moves the pending batch to the backlog on disk and then writes the
+// backlog to the server, oldest first, stopping at the first failure
+func (w *influxWriter) Flush() error {
+	if err := w.save(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		var key, batch []byte
+		err := w.db.View(func(tx *bolt.Tx) error {
+			k, v := tx.Bucket(w.bucket).Cursor().First()
+			key = append(key, k...)
+			batch = append(batch, v...)
+			return nil
+		})
+
+		if err != nil || key == nil {
+			return err
+		}
+
+		err = w.write(batch)
+		if err != nil && !isBadBatch(err) {
+			return err
+		}
+
+		if derr := w.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(w.bucket).Delete(key) }); derr != nil {
+			return derr
+		}
+
+		// the server will never accept a bad batch so it is dropped
+		if err != nil {
+			return fmt.Errorf("dropped batch rejected by InfluxDB: %s", err)
+		}
+	}
+}
+
+// save moves the pending batch to the backlog, dropping the oldest batches if
+// the backlog is full
+func (w *influxWriter) save() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending.Len() == 0 {
+		return nil
+	}
+
+	err := w.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(w.bucket)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, w.pending.Bytes()); err != nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for n := b.Stats().KeyN; n >= maxInfluxBacklog; n-- {
+			k, _ := c.First()
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		w.pending.Reset()
+	}
+
+	return err
+}
+
+// Backlog returns the number of batches waiting to be written
+func (w *influxWriter) Backlog() (n int) {
+	w.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(w.bucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// influxError is returned when the server responds with an error status
+type influxError struct {
+	status int
+	msg    string
+}
+
+func (e influxError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.msg)
+}
+
+// isBadBatch returns true if the server rejected the batch because of its contents
+func isBadBatch(err error) bool {
+	ierr, ok := err.(influxError)
+	return ok && ierr.status == http.StatusBadRequest
+}
+
+// write posts the batch to the server
+func (w *influxWriter) write(batch []byte) error {
+	req, err := http.NewRequest("POST", w.cfg.writeURL(), bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Version == 2 {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	} else if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return influxError{res.StatusCode, strings.TrimSpace(string(body))}
+	}
+
+	return nil
+}
+
+// run samples the readings and flushes them to the server at the configured intervals
+func (w *influxWriter) run(onError func(error)) {
+	sample := time.NewTicker(time.Duration(w.cfg.Interval) * time.Second)
+	flush := time.NewTicker(time.Duration(w.cfg.FlushInterval) * time.Second)
+
+	for {
+		select {
+		case t := <-sample.C:
+			w.Sample(t)
+		case <-flush.C:
+			if err := w.Flush(); err != nil {
+				onError(fmt.Errorf("failed to write to InfluxDB %s (%d batches waiting): %s", w.cfg.URL, w.Backlog(), err))
+			}
+		}
+	}
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// lineProtocol returns the InfluxDB line protocol for the given point, with
+// the timestamp in seconds
+func lineProtocol(measurement string, tags map[string]string, fields map[string]float64, t time.Time) string {
+	line := measurementEscaper.Replace(measurement)
+
+	keys := []string{}
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		line += "," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(tags[k])
+	}
+
+	keys = []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		line += sep + keyEscaper.Replace(k) + "=" + strconv.FormatFloat(fields[k], 'f', -1, 64)
+	}
+
+	return line + " " + strconv.FormatInt(t.Unix(), 10) + "\n"
+}
@@ -0,0 +1,137 @@
+package openminder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInfluxWriter(t *testing.T) {
+	Convey("given an InfluxDB server and a writer", t, func() {
+		jdb := newTestDB(t)
+
+		status := 204
+		batches := []string{}
+		var lastReq *http.Request
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			lastReq = r
+			if status == 204 {
+				batches = append(batches, string(body))
+			}
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		values := map[string]float64{"irrig_ec": 2.1, "irrig_adc": 7000, "runoff_ph": 6.4, "moisture": 40}
+		probes := map[string]string{"irrig": "ASL0001", "runoff": ""}
+
+		cfg := InfluxConfig{URL: srv.URL, Database: "greenhouse", Device: "gh 1", Exclude: []string{"*_adc"}}
+		w, err := newInfluxWriter(jdb.db, cfg,
+			func() map[string]float64 { return values },
+			func() map[string]string { return probes },
+		)
+		So(err, ShouldBeNil)
+
+		now := time.Unix(1500000000, 0)
+
+		Convey("when the readings are sampled and flushed", func() {
+			w.Sample(now)
+			So(w.Flush(), ShouldBeNil)
+
+			Convey("they should be written as line protocol tagged by side", func() {
+				So(batches, ShouldHaveLength, 1)
+				So(batches[0], ShouldEqual, ""+
+					"openminder,device=gh\\ 1 moisture=40 1500000000\n"+
+					"openminder,device=gh\\ 1,probe=ASL0001,side=irrig ec=2.1 1500000000\n"+
+					"openminder,device=gh\\ 1,side=runoff ph=6.4 1500000000\n")
+			})
+
+			Convey("they should be written to the v1 endpoint", func() {
+				So(lastReq.URL.Path, ShouldEqual, "/write")
+				So(lastReq.URL.Query().Get("db"), ShouldEqual, "greenhouse")
+				So(lastReq.URL.Query().Get("precision"), ShouldEqual, "s")
+			})
+
+			Convey("the backlog should be empty", func() {
+				So(w.Backlog(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("when the server is down", func() {
+			status = 503
+			w.Sample(now)
+			So(w.Flush(), ShouldNotBeNil)
+			w.Sample(now.Add(time.Minute))
+			So(w.Flush(), ShouldNotBeNil)
+
+			Convey("the batches should be kept on disk", func() {
+				So(w.Backlog(), ShouldEqual, 2)
+				So(batches, ShouldBeEmpty)
+			})
+
+			Convey("and then comes back", func() {
+				status = 204
+				So(w.Flush(), ShouldBeNil)
+
+				Convey("the batches should be written in order", func() {
+					So(batches, ShouldHaveLength, 2)
+					So(batches[0], ShouldContainSubstring, " 1500000000\n")
+					So(batches[1], ShouldContainSubstring, " 1500000060\n")
+					So(w.Backlog(), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("when the server rejects a batch", func() {
+			status = 400
+			w.Sample(now)
+
+			Convey("it should be dropped", func() {
+				So(w.Flush(), ShouldNotBeNil)
+				So(w.Backlog(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("when writing to InfluxDB 2", func() {
+			w.cfg.Version = 2
+			w.cfg.Org = "autogrow"
+			w.cfg.Bucket = "minder"
+			w.cfg.Token = "t0ken"
+			w.Sample(now)
+			So(w.Flush(), ShouldBeNil)
+
+			Convey("it should use the v2 endpoint and token", func() {
+				So(lastReq.URL.Path, ShouldEqual, "/api/v2/write")
+				So(lastReq.URL.Query().Get("org"), ShouldEqual, "autogrow")
+				So(lastReq.URL.Query().Get("bucket"), ShouldEqual, "minder")
+				So(lastReq.Header.Get("Authorization"), ShouldEqual, "Token t0ken")
+			})
+		})
+	})
+}
+
+func TestInfluxConfigFields(t *testing.T) {
+	Convey("given a config with include and exclude patterns", t, func() {
+		cfg := InfluxConfig{Include: []string{"irrig_*", "moisture*"}, Exclude: []string{"*_adc", "*_voltage"}}
+
+		Convey("only the included fields that aren't excluded should be wanted", func() {
+			So(cfg.wants("irrig_ec"), ShouldBeTrue)
+			So(cfg.wants("moisture"), ShouldBeTrue)
+			So(cfg.wants("irrig_adc"), ShouldBeFalse)
+			So(cfg.wants("moisture_voltage"), ShouldBeFalse)
+			So(cfg.wants("runoff_ec"), ShouldBeFalse)
+		})
+	})
+
+	Convey("line protocol special characters should be escaped", t, func() {
+		line := lineProtocol("m x", map[string]string{"a,b": "c=d"}, map[string]float64{"f g": 1}, time.Unix(1, 0))
+		So(strings.TrimSpace(line), ShouldEqual, `m\ x,a\,b=c\=d f\ g=1 1`)
+	})
+}
@@ -0,0 +1,233 @@
+package openminder
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/types"
+	"github.com/boltdb/bolt"
+)
+
+// The defaults for detecting irrigation events
+const (
+	DefaultIrrigationQuiet = 10 * time.Minute
+	DefaultRunoffTimeout   = 20 * time.Minute
+)
+
+// Irrigation is a single irrigation event, from the first irrigation tip until
+// the runoff stops
+type Irrigation struct {
+	Start        time.Time        `json:"start"`
+	End          time.Time        `json:"end"`
+	Active       bool             `json:"active"`
+	IrrigTips    int64            `json:"irrig_tips"`
+	RunoffTips   int64            `json:"runoff_tips"`
+	IrrigVolume  float64          `json:"irrig_volume"`
+	RunoffVolume float64          `json:"runoff_volume"`
+	DrainLag     float64          `json:"drain_lag"` // seconds from the start to the first runoff tip
+	RunoffRatio  float64          `json:"runoff_ratio"`
+	IrrigEC      *types.NullFloat `json:"irrig_ec"`
+	RunoffEC     *types.NullFloat `json:"runoff_ec"`
+	IrrigPH      *types.NullFloat `json:"irrig_ph"`
+	RunoffPH     *types.NullFloat `json:"runoff_ph"`
+
+	// the volumes in mL that the rate correction of the tips adds
+	irrigAdjust  float64
+	runoffAdjust float64
+}
+
+// the readings that are averaged over an irrigation
+var irrigationAverages = []string{"irrig_ec", "runoff_ec", "irrig_ph", "runoff_ph"}
+
+// irrigationLog detects irrigation events from the tips of the tipping buckets
+// and keeps the finished ones in a bolt bucket
+type irrigationLog struct {
+	db            *bolt.DB
+	bucket        []byte
+	quiet         time.Duration
+	runoffTimeout time.Duration
+	fill          func(*Irrigation)
+	onDoneCB      func(Irrigation)
+
+	current      *Irrigation
+	averages     map[string]*Aggregate
+	lastIrrigTip time.Time
+	lastTip      time.Time
+	mu           *sync.Mutex
+}
+
+// newIrrigationLog returns a new irrigation log, an event starts on an irrigation tip
+// that comes after the quiet period and ends when there has been no tips for the
+// runoff timeout.  The fill func is called to fill in the volumes of an event.
+func newIrrigationLog(db *bolt.DB, quiet, runoffTimeout time.Duration, fill func(*Irrigation)) (*irrigationLog, error) {
+	il := &irrigationLog{
+		db:            db,
+		bucket:        []byte("irrigations"),
+		quiet:         quiet,
+		runoffTimeout: runoffTimeout,
+		fill:          fill,
+		onDoneCB:      func(Irrigation) {},
+		mu:            new(sync.Mutex),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(il.bucket)
+		return err
+	})
+
+	return il, err
+}
+
+// OnDone registers a function to be called when an irrigation event ends
+func (il *irrigationLog) OnDone(cb func(Irrigation)) {
+	il.onDoneCB = cb
+}
+
+// IrrigTip records an irrigation tip at the given time with the volume its rate
+// correction adds
+func (il *irrigationLog) IrrigTip(t time.Time, adjust float64) error {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	var err error
+	if il.current != nil && t.Sub(il.lastIrrigTip) >= il.quiet {
+		err = il.finish(il.lastTip)
+	}
+
+	if il.current == nil {
+		il.current = &Irrigation{Start: t, Active: true}
+		il.averages = map[string]*Aggregate{}
+	}
+
+	il.current.IrrigTips++
+	il.current.irrigAdjust += adjust
+	il.lastIrrigTip = t
+	il.lastTip = t
+	return err
+}
+
+// RunoffTip records a runoff tip at the given time with the volume its rate
+// correction adds, runoff outside of an irrigation event is ignored
+func (il *irrigationLog) RunoffTip(t time.Time, adjust float64) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.current == nil {
+		return
+	}
+
+	if il.current.RunoffTips == 0 {
+		il.current.DrainLag = t.Sub(il.current.Start).Seconds()
+	}
+
+	il.current.RunoffTips++
+	il.current.runoffAdjust += adjust
+	il.lastTip = t
+}
+
+// Observe adds the readings to the averages of the current event
+func (il *irrigationLog) Observe(r *Readings) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.current == nil {
+		return
+	}
+
+	values := r.Values()
+	for _, f := range irrigationAverages {
+		v, ok := values[f]
+		if !ok {
+			continue
+		}
+
+		if il.averages[f] == nil {
+			il.averages[f] = &Aggregate{}
+		}
+		il.averages[f].Add(v)
+	}
+}
+
+// Check will end the current event if the runoff has stopped
+func (il *irrigationLog) Check(now time.Time) error {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.current == nil || now.Sub(il.lastTip) < il.runoffTimeout {
+		return nil
+	}
+
+	return il.finish(il.lastTip)
+}
+
+// finish ends the current event at the given time and saves it
+func (il *irrigationLog) finish(end time.Time) error {
+	ev := il.snapshot()
+	ev.End = end
+	ev.Active = false
+	il.current = nil
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	err = il.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(il.bucket).Put(timeKey(ev.Start), data)
+	})
+
+	go il.onDoneCB(ev)
+	return err
+}
+
+// snapshot returns a copy of the current event with its volumes and averages filled in
+func (il *irrigationLog) snapshot() Irrigation {
+	ev := *il.current
+	il.fill(&ev)
+
+	avg := func(f string) *types.NullFloat {
+		nf := &types.NullFloat{}
+		if a := il.averages[f]; a != nil && a.Count > 0 {
+			nf.SetValue(a.Mean)
+		}
+		return nf
+	}
+
+	ev.IrrigEC = avg("irrig_ec")
+	ev.RunoffEC = avg("runoff_ec")
+	ev.IrrigPH = avg("irrig_ph")
+	ev.RunoffPH = avg("runoff_ph")
+	return ev
+}
+
+// Irrigations returns the events that started between from and to (inclusive),
+// including the one in progress
+func (il *irrigationLog) Irrigations(from, to time.Time) ([]Irrigation, error) {
+	events := []Irrigation{}
+
+	err := il.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(il.bucket).Cursor()
+		end := timeKey(to)
+
+		for k, v := c.Seek(timeKey(from)); k != nil && string(k) <= string(end); k, v = c.Next() {
+			ev := Irrigation{}
+			if err := json.Unmarshal(v, &ev); err != nil {
+				return err
+			}
+
+			events = append(events, ev)
+		}
+
+		return nil
+	})
+
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.current != nil && !il.current.Start.Before(from) && !il.current.Start.After(to) {
+		events = append(events, il.snapshot())
+	}
+
+	return events, err
+}
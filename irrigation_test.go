@@ -0,0 +1,105 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIrrigationLog(t *testing.T) {
+	Convey("given an irrigation log with a 10 minute quiet period and 20 minute runoff timeout", t, func() {
+		jdb := newTestDB(t)
+
+		il, err := newIrrigationLog(jdb.db, 10*time.Minute, 20*time.Minute, func(ev *Irrigation) {
+			ev.IrrigVolume = float64(ev.IrrigTips) * 10
+			ev.RunoffVolume = float64(ev.RunoffTips) * 10
+			ev.RunoffRatio = ev.RunoffVolume / ev.IrrigVolume
+		})
+		So(err, ShouldBeNil)
+
+		done := make(chan Irrigation, 2)
+		il.OnDone(func(ev Irrigation) { done <- ev })
+
+		start := time.Now().Add(-24 * time.Hour)
+		at := func(mins float64) time.Time { return start.Add(time.Duration(mins * float64(time.Minute))) }
+		all := func() []Irrigation {
+			events, err := il.Irrigations(start.Add(-time.Hour), start.Add(24*time.Hour))
+			So(err, ShouldBeNil)
+			return events
+		}
+
+		Convey("when there are irrigation tips followed by runoff", func() {
+			r := newReadings()
+			for i := 0; i < 4; i++ {
+				So(il.IrrigTip(at(float64(i)), 0), ShouldBeNil)
+				r.IrrigEC.SetValue(float64(i))
+				il.Observe(r)
+			}
+			il.RunoffTip(at(6), 0)
+			il.RunoffTip(at(7), 0)
+
+			Convey("it should have an active event", func() {
+				events := all()
+				So(events, ShouldHaveLength, 1)
+				So(events[0].Active, ShouldBeTrue)
+				So(events[0].IrrigVolume, ShouldEqual, 40)
+			})
+
+			Convey("and the runoff timeout has not passed", func() {
+				So(il.Check(at(26)), ShouldBeNil)
+
+				Convey("the event should still be active", func() {
+					So(all()[0].Active, ShouldBeTrue)
+				})
+			})
+
+			Convey("and the runoff timeout has passed", func() {
+				So(il.Check(at(28)), ShouldBeNil)
+
+				Convey("the event should be saved with its details", func() {
+					events := all()
+					So(events, ShouldHaveLength, 1)
+
+					ev := events[0]
+					So(ev.Active, ShouldBeFalse)
+					So(ev.Start.Equal(at(0)), ShouldBeTrue)
+					So(ev.End.Equal(at(7)), ShouldBeTrue)
+					So(ev.IrrigTips, ShouldEqual, 4)
+					So(ev.RunoffTips, ShouldEqual, 2)
+					So(ev.RunoffVolume, ShouldEqual, 20)
+					So(ev.RunoffRatio, ShouldEqual, 0.5)
+					So(ev.DrainLag, ShouldEqual, 360)
+					So(ev.IrrigEC.Value(), ShouldEqual, 1.5)
+					So(ev.RunoffEC == nil || !ev.RunoffEC.IsValid(), ShouldBeTrue)
+				})
+
+				Convey("it should call the done callback", func() {
+					So((<-done).IrrigTips, ShouldEqual, 4)
+				})
+
+				Convey("and runoff comes late", func() {
+					il.RunoffTip(at(30), 0)
+
+					Convey("it should not start an event", func() {
+						So(all(), ShouldHaveLength, 1)
+					})
+				})
+			})
+
+			Convey("and there is an irrigation tip after the quiet period", func() {
+				So(il.IrrigTip(at(15), 0), ShouldBeNil)
+
+				Convey("it should end the event and start a new one", func() {
+					events := all()
+					So(events, ShouldHaveLength, 2)
+					So(events[0].Active, ShouldBeFalse)
+					So(events[0].End.Equal(at(7)), ShouldBeTrue)
+					So(events[1].Active, ShouldBeTrue)
+					So(events[1].Start.Equal(at(15)), ShouldBeTrue)
+					So(events[1].DrainLag, ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
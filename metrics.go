@@ -0,0 +1,124 @@
+package openminder
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsContentType is the content type of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// counterFields are the readings that only ever go up, so are exported as
+// counters rather than gauges
+var counterFields = map[string]bool{
+	"irrig_tips":     true,
+	"runoff_tips":    true,
+	"irrig_bounces":  true,
+	"runoff_bounces": true,
+}
+
+// labelEscaper escapes label values as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// AttachMetrics adds the Prometheus metrics endpoint to the given router
+func (mdr *Minder) AttachMetrics(r gin.IRouter) {
+	r.GET("/metrics", mdr.metricsHandler())
+}
+
+func (mdr *Minder) metricsHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		c.Data(200, metricsContentType, mdr.Metrics())
+	}
+}
+
+// Metrics returns the readings and health of the minder in the Prometheus
+// text format
+func (mdr *Minder) Metrics() []byte {
+	pw := newPromWriter()
+
+	values := mdr.Readings.Values()
+	fields := []string{}
+	for f := range values {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	for _, f := range fields {
+		if counterFields[f] {
+			pw.write("openminder_"+f+"_total", "counter", "total "+strings.Replace(f, "_", " ", -1)+" since the counters were reset", values[f])
+			continue
+		}
+		pw.write("openminder_"+f, "gauge", "the "+strings.Replace(f, "_", " ", -1)+" reading", values[f])
+	}
+
+	pw.write("openminder_loop_duration_seconds", "gauge", "time taken by the last minder loop", time.Duration(atomic.LoadInt64(&mdr.loopTime)).Seconds())
+	pw.write("openminder_errors", "gauge", "number of errors in the error store", float64(mdr.errors.Len()))
+
+	if mdr.bus == nil {
+		return pw.Bytes()
+	}
+
+	stats := mdr.bus.Stats()
+	pw.write("openminder_bus_rx_packets_total", "counter", "packets received from the ASL bus", float64(stats.RxPackets))
+	pw.write("openminder_bus_tx_packets_total", "counter", "packets transmitted on the ASL bus", float64(stats.TxPackets))
+	pw.write("openminder_bus_crc_failures_total", "counter", "packets received that failed the CRC check", float64(stats.CRCFailures))
+	pw.write("openminder_bus_parse_errors_total", "counter", "packets received that could not be parsed", float64(stats.ParseErrors))
+	pw.write("openminder_bus_tx_requests_total", "counter", "packets requested to be transmitted by the bus master", float64(stats.TxRequests))
+	pw.write("openminder_bus_tx_sent_total", "counter", "packets queued for transmission by the bus master", float64(stats.TxSent))
+
+	serials := []string{}
+	for sn := range stats.LastSeen {
+		serials = append(serials, sn)
+	}
+	sort.Strings(serials)
+
+	for _, sn := range serials {
+		age := time.Since(stats.LastSeen[sn]).Seconds()
+		pw.write("openminder_probe_last_seen_seconds", "gauge", "seconds since a packet was last received from the probe", age, "serial", sn)
+	}
+
+	return pw.Bytes()
+}
+
+// promWriter writes metrics in the Prometheus text format, writing the help
+// and type lines the first time each metric is seen
+type promWriter struct {
+	buf  *bytes.Buffer
+	seen map[string]bool
+}
+
+func newPromWriter() *promWriter {
+	return &promWriter{new(bytes.Buffer), map[string]bool{}}
+}
+
+// write adds a sample for the given metric, labels are given as name value pairs
+func (pw *promWriter) write(name, kind, help string, value float64, labels ...string) {
+	if !pw.seen[name] {
+		fmt.Fprintf(pw.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		pw.seen[name] = true
+	}
+
+	pw.buf.WriteString(name)
+
+	if len(labels) > 1 {
+		pairs := []string{}
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+		}
+		pw.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	fmt.Fprintf(pw.buf, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Bytes returns the metrics written so far
+func (pw *promWriter) Bytes() []byte {
+	return pw.buf.Bytes()
+}
@@ -0,0 +1,53 @@
+package openminder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/autogrow/openminder/aslbus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("given a minder with some readings", t, func() {
+		mdr := &Minder{
+			Readings: newReadings(),
+			errors:   newErrorStore(),
+			bus:      aslbus.NewBusManager(aslbus.New("/dev/null"), 1),
+		}
+		mdr.Readings.IrrigPH = 6.2
+		mdr.Readings.IrrigTips = 42
+		mdr.Readings.RunoffEC.SetValue(2.5)
+
+		Convey("the metrics should be in the prometheus text format", func() {
+			lines := strings.Split(string(mdr.Metrics()), "\n")
+
+			So(lines, ShouldContain, "# TYPE openminder_irrig_ph gauge")
+			So(lines, ShouldContain, "openminder_irrig_ph 6.2")
+			So(lines, ShouldContain, "openminder_runoff_ec 2.5")
+			So(lines, ShouldContain, "# TYPE openminder_irrig_tips_total counter")
+			So(lines, ShouldContain, "openminder_irrig_tips_total 42")
+			So(lines, ShouldContain, "openminder_errors 0")
+			So(lines, ShouldContain, "openminder_bus_crc_failures_total 0")
+			So(lines, ShouldContain, "openminder_bus_tx_requests_total 0")
+		})
+
+		Convey("invalid readings should be left out", func() {
+			So(string(mdr.Metrics()), ShouldNotContainSubstring, "openminder_irrig_ec ")
+		})
+	})
+
+	Convey("given a prometheus writer", t, func() {
+		pw := newPromWriter()
+
+		Convey("when a metric is written twice with labels", func() {
+			pw.write("probe_age", "gauge", "age of the probe", 1, "serial", "A")
+			pw.write("probe_age", "gauge", "age of the probe", 2.5, "serial", `B"1`)
+
+			Convey("the help and type should only be written once", func() {
+				So(string(pw.Bytes()), ShouldEqual, "# HELP probe_age age of the probe\n# TYPE probe_age gauge\n"+
+					"probe_age{serial=\"A\"} 1\nprobe_age{serial=\"B\\\"1\"} 2.5\n")
+			})
+		})
+	})
+}
@@ -3,23 +3,39 @@ package openminder
 import (
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/autogrow/openminder/aslbus"
+	"github.com/autogrow/openminder/modbus"
+	"github.com/autogrow/openminder/types"
 )
 
 // Minder is a model that holds the objects that when
 // combined make up the minder logic
 type Minder struct {
+	loopTime      int64 // accessed atomically, keep first for alignment on ARM
 	stopped       bool
+	hw            Hardware
 	tr            *Translater
 	cfg           *Config
 	bus           *aslbus.Manager
 	irrigPH       *PHCircuit
 	runoffPH      *PHCircuit
+	phUnrounded   map[string]float64 // the raw pH readings before rounding, for calibration sessions
 	irrigTB       *TippingBucket
 	runoffTB      *TippingBucket
 	moisture      *MoistureCircuit
+	counters      *counterStore
+	history       *History
+	irrigations   *irrigationLog
+	daily         *dailyLog
+	alerts        *alertEngine
+	notifier      *notifier
+	influx        *influxWriter
+	stream        *stream
+	sessions      *calibrationSessions
+	probesOnline  map[string]bool
 	Readings      *Readings
 	errors        *errorStore
 	onCfgChangeCB func(Config)
@@ -27,18 +43,105 @@ type Minder struct {
 
 // NewMinder returns a new minder object with the default comprising
 // objects already setup
-func NewMinder(cfg *Config) (mdr *Minder, err error) {
+func NewMinder(cfg *Config) (*Minder, error) {
+	return NewMinderWithHardware(cfg, HatHardware())
+}
+
+// NewMinderWithHardware returns a new minder object that takes its readings
+// from the given hardware
+func NewMinderWithHardware(cfg *Config, hw Hardware) (mdr *Minder, err error) {
 	mdr = &Minder{
+		hw:            hw,
 		Readings:      newReadings(),
 		cfg:           cfg,
 		onCfgChangeCB: func(cfg Config) {},
 		errors:        newErrorStore(),
+		stream:        newStream(),
+		sessions:      newCalibrationSessions(),
+		phUnrounded:   map[string]float64{},
+	}
+
+	// settings out of range are reported rather than stopping the minder, as
+	// configs from before they were checked still have to run
+	if err := cfg.validate(); err != nil {
+		log.Printf("ERROR: config: %s", err)
+		mdr.errors.Add(fmt.Errorf("config: %s", err))
 	}
 
 	if mdr.tr, err = NewTranslater(); err != nil {
 		return nil, err
 	}
 
+	mdr.migrateECCalibrations()
+
+	mdr.counters = newCounterStore(mdr.tr.jdb)
+	mdr.restoreCounters()
+	go mdr.counters.run(func(err error) {
+		mdr.errors.Add(fmt.Errorf("failed to save counters: %s", err))
+	})
+
+	if mdr.history, err = NewHistory(mdr.tr.jdb.db, cfg.historyRetention()); err != nil {
+		return nil, err
+	}
+
+	mdr.irrigations, err = newIrrigationLog(mdr.tr.jdb.db, cfg.irrigationQuiet(), cfg.runoffTimeout(), mdr.fillIrrigation)
+	if err != nil {
+		return nil, err
+	}
+
+	if mdr.daily, err = newDailyLog(mdr.tr.jdb.db, cfg.DayStartHour); err != nil {
+		return nil, err
+	}
+	go mdr.daily.run(func(err error) {
+		mdr.errors.Add(fmt.Errorf("failed to save daily totals: %s", err))
+	})
+
+	if mdr.notifier, err = newNotifier(mdr.tr.jdb.db, cfg.Webhooks); err != nil {
+		return nil, err
+	}
+	go mdr.notifier.run(func(err error) {
+		mdr.errors.Add(fmt.Errorf("failed to send notifications: %s", err))
+	})
+
+	if mdr.alerts, err = newAlertEngine(mdr.tr.jdb.db); err != nil {
+		return nil, err
+	}
+
+	mdr.alerts.OnAlert(func(a Alert) {
+		if a.Active {
+			log.Printf("ALERT: %s: %s (%s = %0.2f)", a.Severity, a.Name, a.Field, a.Value)
+			mdr.notify(EventAlertRaised, a)
+		} else {
+			log.Printf("ALERT CLEARED: %s: %s", a.Severity, a.Name)
+			mdr.notify(EventAlertCleared, a)
+		}
+	})
+
+	if cfg.MQTT.Host != "" {
+		pub := newMQTTPublisher(cfg.MQTT, mdr.Readings.Values)
+		pub.OnError(func(err error) {
+			log.Printf("ERROR: %s", err)
+			mdr.errors.Add(err)
+		})
+		go pub.run()
+	}
+
+	if cfg.Influx.URL != "" {
+		mdr.influx, err = newInfluxWriter(mdr.tr.jdb.db, cfg.Influx, mdr.Readings.Values, mdr.probeSerials)
+		if err != nil {
+			return nil, err
+		}
+
+		go mdr.influx.run(func(err error) {
+			log.Printf("ERROR: %s", err)
+			mdr.errors.Add(err)
+		})
+	}
+
+	if cfg.Modbus.Listen != "" {
+		go mdr.serveModbus()
+	}
+
 	mdr.init()
 
 	return mdr, nil
@@ -49,6 +152,25 @@ func (mdr *Minder) OnConfigChange(cb func(Config)) {
 	mdr.onCfgChangeCB = cb
 }
 
+// serveModbus serves the readings over Modbus TCP
+func (mdr *Minder) serveModbus() {
+	srv := modbus.NewServer(modbusHandler{mdr}, byte(mdr.cfg.Modbus.UnitID))
+	srv.OnError(func(err error) {
+		log.Printf("ERROR: %s", err)
+	})
+
+	log.Printf("serving modbus on %s", mdr.cfg.Modbus.Listen)
+	if err := srv.ListenAndServe(mdr.cfg.Modbus.Listen); err != nil {
+		log.Printf("ERROR: modbus server stopped: %s", err)
+		mdr.errors.Add(fmt.Errorf("modbus server stopped: %s", err))
+	}
+}
+
+// probeSerials returns the serial of the EC probe on each side
+func (mdr *Minder) probeSerials() map[string]string {
+	return map[string]string{"irrig": mdr.cfg.IrrigECProbe, "runoff": mdr.cfg.RunoffECProbe}
+}
+
 func (mdr *Minder) init() {
 	mdr.initBus()
 	mdr.initTBs()
@@ -56,13 +178,21 @@ func (mdr *Minder) init() {
 }
 
 func (mdr *Minder) initBus() {
-	mdr.bus = aslbus.NewManager(mdr.cfg.TTY, mdr.cfg.ScanTimeout, mdr.cfg.IrrigECProbe, mdr.cfg.RunoffECProbe)
+	mdr.bus = aslbus.NewBusManager(mdr.hw.Bus(mdr.cfg.TTY), mdr.cfg.ScanTimeout, mdr.cfg.IrrigECProbe, mdr.cfg.RunoffECProbe)
 
 	mdr.bus.OnError(func(err error) {
 		log.Printf("ERROR: bus: %s", err)
 		mdr.errors.Add(fmt.Errorf("bus error: %s", err))
 	})
 
+	mdr.bus.OnScanStart(func() {
+		mdr.publish(EventScanStarted, nil)
+	})
+
+	mdr.bus.OnDetect(func(serial string) {
+		mdr.publish(EventProbeDetected, map[string]string{"serial": serial})
+	})
+
 	mdr.bus.OnScanDone(func(serials []string, err error) {
 		if err != nil {
 			err = fmt.Errorf("scan failed: %s", err)
@@ -71,7 +201,14 @@ func (mdr *Minder) initBus() {
 		}
 
 		mdr.cfg.AssignProbeSerials(serials...)
+		mdr.migrateECCalibrations()
 		go mdr.onCfgChangeCB(*mdr.cfg)
+
+		data := map[string]interface{}{"serials": serials, "error": nil}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		mdr.notify(EventScanDone, data)
 	})
 
 	go mdr.bus.Run()
@@ -80,52 +217,193 @@ func (mdr *Minder) initBus() {
 func (mdr *Minder) initTBs() {
 	go func() {
 		for {
-			tb, err := NewTippingBucket(mdr.cfg.IrrigTBGPIO)
+			cc, err := mdr.hw.ContactSwitch(mdr.cfg.IrrigTBGPIO, mdr.cfg.TBEdgeDetect, mdr.tbDebounce())
 			if err != nil {
 				mdr.errors.Add(fmt.Errorf("ERROR: failed to connect to TB on %s: %s", mdr.cfg.IrrigTBGPIO, err))
 				time.Sleep(time.Second)
 				continue
 			}
-			mdr.irrigTB = tb
+			mdr.irrigTB = NewTippingBucketWithSwitch(cc)
 
 			break
 		}
 
-		mdr.irrigTB.OnTip(func() {
-			var err error
-			mdr.Readings.IrrigTips++
-			mdr.Readings.IrrigVolume, err = mdr.tr.Translate("irrig_volume", float64(mdr.Readings.IrrigTips))
+		mdr.irrigTB.OnTip(func(cl Closure) {
+			// the water poured through while calibrating the bucket isn't irrigation
+			if mdr.sessions.Tip("irrig", cl.Time) {
+				return
+			}
+
+			adjust := mdr.tipAdjust("irrig_volume", mdr.irrigTB.PourRate())
+			mdr.errors.Add(mdr.irrigations.IrrigTip(cl.Time, adjust))
+			mdr.errors.Add(mdr.daily.AddIrrigTip(cl.Time, adjust))
+			mdr.Readings.IrrigTips = mdr.counters.AddIrrigTip()
+			mdr.counters.AddIrrigAdjust(adjust)
+			mdr.updateIrrigVolume()
 			CalculateRunoffRatio(mdr.Readings, *mdr.cfg)
-			mdr.errors.Add(err)
+			mdr.publishTip("irrig", cl, mdr.Readings.IrrigTips, mdr.Readings.IrrigVolume)
 		})
 	}()
 
 	go func() {
 		for {
-			tb, err := NewTippingBucket(mdr.cfg.RunoffTBGPIO)
+			cc, err := mdr.hw.ContactSwitch(mdr.cfg.RunoffTBGPIO, mdr.cfg.TBEdgeDetect, mdr.tbDebounce())
 			if err != nil {
 				mdr.errors.Add(fmt.Errorf("ERROR: failed to connect to TB on %s: %s", mdr.cfg.RunoffTBGPIO, err))
 				time.Sleep(time.Second)
 				continue
 			}
-			mdr.runoffTB = tb
+			mdr.runoffTB = NewTippingBucketWithSwitch(cc)
 			break
 		}
 
-		mdr.runoffTB.OnTip(func() {
-			var err error
-			mdr.Readings.RunoffTips++
-			mdr.Readings.RunoffVolume, err = mdr.tr.Translate("irrig_volume", float64(mdr.Readings.RunoffTips))
+		mdr.runoffTB.OnTip(func(cl Closure) {
+			if mdr.sessions.Tip("runoff", cl.Time) {
+				return
+			}
+
+			adjust := mdr.tipAdjust(mdr.runoffVolumeField(), mdr.runoffTB.PourRate())
+			mdr.irrigations.RunoffTip(cl.Time, adjust)
+			mdr.errors.Add(mdr.daily.AddRunoffTip(cl.Time, adjust))
+			mdr.Readings.RunoffTips = mdr.counters.AddRunoffTip()
+			mdr.counters.AddRunoffAdjust(adjust)
+			mdr.updateRunoffVolume()
 			CalculateRunoffRatio(mdr.Readings, *mdr.cfg)
-			mdr.errors.Add(err)
+			mdr.publishTip("runoff", cl, mdr.Readings.RunoffTips, mdr.Readings.RunoffVolume)
 		})
 	}()
 }
 
+func (mdr *Minder) updateIrrigVolume() {
+	mdr.Readings.IrrigVolume = mdr.irrigVolume(mdr.Readings.IrrigTips) + mdr.counters.Counters().IrrigAdjust
+}
+
+func (mdr *Minder) updateRunoffVolume() {
+	mdr.Readings.RunoffVolume = mdr.runoffVolume(mdr.Readings.RunoffTips) + mdr.counters.Counters().RunoffAdjust
+}
+
+// tipAdjust returns the volume to add to a tip at the tip rate from the rate
+// correction of the tipping bucket's calibration, there is nothing to add
+// until the rate is known
+func (mdr *Minder) tipAdjust(field string, rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+
+	c, err := mdr.tr.getCalibration(field)
+	if err != nil {
+		return 0
+	}
+
+	return c.tipAdjust(rate)
+}
+
+// runoffVolumeField returns the calibration to use for the runoff tipping
+// bucket, until it has been calibrated it uses the irrigation one which used
+// to be used for both
+func (mdr *Minder) runoffVolumeField() string {
+	if _, err := mdr.tr.getCalibration("runoff_volume"); err != nil {
+		return "irrig_volume"
+	}
+
+	return "runoff_volume"
+}
+
+// irrigVolume returns the volume of the given number of irrigation tips
+func (mdr *Minder) irrigVolume(tips int64) float64 {
+	v, err := mdr.tr.Translate("irrig_volume", float64(tips))
+	mdr.errors.Add(err)
+	return v
+}
+
+// runoffVolume returns the volume of the given number of runoff tips
+func (mdr *Minder) runoffVolume(tips int64) float64 {
+	v, err := mdr.tr.Translate(mdr.runoffVolumeField(), float64(tips))
+	mdr.errors.Add(err)
+	return v
+}
+
+// fillIrrigation fills in the volumes and runoff ratio of the irrigation event
+func (mdr *Minder) fillIrrigation(ev *Irrigation) {
+	r := &Readings{
+		IrrigVolume:  mdr.irrigVolume(ev.IrrigTips) + ev.irrigAdjust,
+		RunoffVolume: mdr.runoffVolume(ev.RunoffTips) + ev.runoffAdjust,
+	}
+
+	CalculateRunoffRatio(r, *mdr.cfg)
+	ev.IrrigVolume = r.IrrigVolume
+	ev.RunoffVolume = r.RunoffVolume
+	ev.RunoffRatio = r.RunoffRatio
+}
+
+// DailyReport returns the water balance for the given number of days up to and
+// including today, oldest first
+func (mdr *Minder) DailyReport(days int) ([]DailyReport, error) {
+	reports, err := mdr.daily.Days(time.Now(), days)
+	if err != nil {
+		return reports, err
+	}
+
+	for i := range reports {
+		mdr.fillDailyReport(&reports[i])
+	}
+
+	return reports, nil
+}
+
+// fillDailyReport fills in the volumes and per plant figures of the report from its tips
+func (mdr *Minder) fillDailyReport(rpt *DailyReport) {
+	r := &Readings{
+		IrrigVolume:  mdr.irrigVolume(rpt.IrrigTips) + rpt.irrigAdjust,
+		RunoffVolume: mdr.runoffVolume(rpt.RunoffTips) + rpt.runoffAdjust,
+	}
+
+	CalculateRunoffRatio(r, *mdr.cfg)
+	rpt.IrrigVolume = r.IrrigVolume
+	rpt.RunoffVolume = r.RunoffVolume
+	rpt.RunoffRatio = r.RunoffRatio
+	rpt.IrrigPerPlant = &types.NullFloat{}
+	rpt.RunoffPerPlant = &types.NullFloat{}
+	rpt.UptakePerPlant = &types.NullFloat{}
+
+	if mdr.cfg.IrrigDrippers == 0 || mdr.cfg.RunoffDrippers == 0 || mdr.cfg.DrippersPerPlant == 0 {
+		return
+	}
+
+	irrig := (r.IrrigVolume / float64(mdr.cfg.IrrigDrippers)) * float64(mdr.cfg.DrippersPerPlant)
+	runoff := (r.RunoffVolume / float64(mdr.cfg.RunoffDrippers)) * float64(mdr.cfg.DrippersPerPlant)
+	rpt.IrrigPerPlant.SetValue(irrig)
+	rpt.RunoffPerPlant.SetValue(runoff)
+	rpt.UptakePerPlant.SetValue(irrig - runoff)
+}
+
+// restoreCounters puts the stored counters into the readings
+func (mdr *Minder) restoreCounters() {
+	c := mdr.counters.Counters()
+	mdr.Readings.IrrigTips = c.IrrigTips
+	mdr.Readings.RunoffTips = c.RunoffTips
+	mdr.Readings.CountersReset = c.ResetAt
+	mdr.updateIrrigVolume()
+	mdr.updateRunoffVolume()
+	mdr.Readings.RunoffRatio = 0
+	CalculateRunoffRatio(mdr.Readings, *mdr.cfg)
+}
+
+// ResetCounters zeros the tip counters and volumes
+func (mdr *Minder) ResetCounters() (Counters, error) {
+	c, err := mdr.counters.Reset()
+	mdr.restoreCounters()
+	return c, err
+}
+
+func (mdr *Minder) tbDebounce() time.Duration {
+	return time.Duration(mdr.cfg.TBDebounce) * time.Millisecond
+}
+
 func (mdr *Minder) initADCs() {
 	go func() {
 		for {
-			adc, err := NewMPC3421(0x68)
+			adc, err := mdr.hw.ADC(IrrigPHADCAddr, 1)
 			if err != nil {
 				mdr.errors.Add(fmt.Errorf("ERROR: failed to connect to ADC 1: %s", err))
 				time.Sleep(time.Second)
@@ -138,7 +416,7 @@ func (mdr *Minder) initADCs() {
 
 	go func() {
 		for {
-			adc, err := NewMPC3421(0x69)
+			adc, err := mdr.hw.ADC(RunoffPHADCAddr, 1)
 			if err != nil {
 				mdr.errors.Add(fmt.Errorf("ERROR: failed to connect to ADC 2: %s", err))
 				time.Sleep(time.Second)
@@ -151,37 +429,163 @@ func (mdr *Minder) initADCs() {
 
 	go func() {
 		for {
-			adc, err := NewMPC3421(0x70)
+			adc, err := mdr.hw.ADC(MoistureADCAddr, mdr.cfg.MoistureGain)
 			if err != nil {
 				mdr.errors.Add(fmt.Errorf("ERROR: failed to connect to ADC 3: %s", err))
 				time.Sleep(time.Second)
 				continue
 			}
-			err = adc.SetGain(mdr.cfg.MoistureGain)
-			mdr.errors.Add(fmt.Errorf("ERROR: moisture adc gain error: %s", err))
 			mdr.moisture = NewMoistureCircuit(adc)
 			return
 		}
 	}()
 }
 
+// notify sends the event to the webhooks
+func (mdr *Minder) notify(typ string, data interface{}) {
+	mdr.publish(typ, data)
+	if err := mdr.notifier.Notify(typ, data); err != nil {
+		mdr.errors.Add(fmt.Errorf("failed to queue %s notification: %s", typ, err))
+	}
+}
+
+// publish sends the event to the clients of the readings stream
+func (mdr *Minder) publish(typ string, data interface{}) {
+	if err := mdr.stream.Publish(typ, data); err != nil {
+		mdr.errors.Add(fmt.Errorf("failed to stream %s event: %s", typ, err))
+	}
+}
+
+// publishTip sends a tip from the given side to the readings stream
+func (mdr *Minder) publishTip(side string, cl Closure, tips int64, volume float64) {
+	mdr.publish(EventTip, map[string]interface{}{
+		"side":     side,
+		"time":     cl.Time,
+		"duration": cl.Duration.Seconds(),
+		"tips":     tips,
+		"volume":   volume,
+	})
+}
+
+// SetCalibration sets the calibration for the given field
+func (mdr *Minder) SetCalibration(field string, scale, offset float64) error {
+	_, err := mdr.Calibrate(CalibrationRecord{Field: field, Scale: scale, Offset: offset})
+	return err
+}
+
+// Calibrate saves the calibration and sends a notification that it changed
+func (mdr *Minder) Calibrate(rec CalibrationRecord) (CalibrationRecord, error) {
+	rec.Probe = mdr.ecProbeSerial(rec.Field)
+	rec, err := mdr.tr.Calibrate(rec)
+	if err != nil {
+		return rec, err
+	}
+
+	mdr.notify(EventCalibrationChanged, rec)
+	return rec, nil
+}
+
+// CalibrationHistory returns the calibrations that have been used for the
+// field, for EC fields this is the history of the probe on that side
+func (mdr *Minder) CalibrationHistory(field string) ([]CalibrationRecord, error) {
+	if !isTranslatable(field) {
+		return nil, ErrNotTranslatable
+	}
+
+	return mdr.tr.CalibrationHistory(mdr.calibrationKey(field))
+}
+
+// ecProbeSerial returns the serial of the EC probe on the side of the field,
+// or an empty string if it isn't an EC field
+func (mdr *Minder) ecProbeSerial(field string) string {
+	switch field {
+	case "irrig_ec":
+		return mdr.cfg.IrrigECProbe
+	case "runoff_ec":
+		return mdr.cfg.RunoffECProbe
+	}
+
+	return ""
+}
+
+// calibrationKey returns the key that the calibration for the field is stored
+// under, EC calibrations belong to the probe so they follow it between sides
+func (mdr *Minder) calibrationKey(field string) string {
+	return CalibrationRecord{Field: field, Probe: mdr.ecProbeSerial(field)}.key()
+}
+
+// migrateECCalibrations moves any EC calibrations stored by side to the probes
+// that are on those sides
+func (mdr *Minder) migrateECCalibrations() {
+	err := mdr.tr.migrateECCalibrations(map[string]string{
+		"irrig_ec":  mdr.cfg.IrrigECProbe,
+		"runoff_ec": mdr.cfg.RunoffECProbe,
+	})
+
+	if err != nil {
+		mdr.errors.Add(fmt.Errorf("failed to move the EC calibrations to the probes: %s", err))
+	}
+}
+
+// RollbackCalibration goes back to a calibration from the history of the field
+func (mdr *Minder) RollbackCalibration(field string, id uint64, note string) (CalibrationRecord, error) {
+	rec, err := mdr.tr.Rollback(mdr.calibrationKey(field), id, note)
+	if err != nil {
+		return rec, err
+	}
+
+	mdr.notify(EventCalibrationChanged, rec)
+	return rec, nil
+}
+
 // Start the minder loop
 func (mdr *Minder) Start() {
+	go mdr.recordHistory()
+
 	for {
 		if mdr.stopped {
 			return
 		}
 
+		start := time.Now()
 		mdr.readECProbes()
 		mdr.readPHProbes()
 		mdr.readMoistureProbe()
+		mdr.readTippingBuckets()
+		mdr.sessions.Observe(time.Now(), mdr.sessionValues())
+		mdr.errors.Add(mdr.history.Observe(time.Now(), mdr.Readings))
+		mdr.irrigations.Observe(mdr.Readings)
+		mdr.errors.Add(mdr.irrigations.Check(time.Now()))
+		mdr.errors.Add(mdr.alerts.Evaluate(time.Now(), mdr.Readings.Values()))
+		atomic.StoreInt64(&mdr.loopTime, int64(time.Since(start)))
+		mdr.publish(EventReadings, mdr.Readings)
 		time.Sleep(time.Second)
 	}
 }
 
+// recordHistory saves the readings to the history at the configured interval
+func (mdr *Minder) recordHistory() {
+	for {
+		time.Sleep(mdr.cfg.historyInterval())
+		if mdr.stopped {
+			return
+		}
+
+		if err := mdr.history.Add(time.Now(), mdr.Readings); err != nil {
+			mdr.errors.Add(fmt.Errorf("failed to save readings history: %s", err))
+		}
+	}
+}
+
 // Stop the minder loop
 func (mdr *Minder) Stop() {
 	mdr.stopped = true
+	mdr.errors.Add(mdr.counters.Flush())
+	mdr.errors.Add(mdr.history.Flush())
+	mdr.errors.Add(mdr.daily.Flush())
+	if mdr.influx != nil {
+		mdr.errors.Add(mdr.influx.save())
+	}
 }
 
 func (mdr *Minder) readPHProbes() {
@@ -192,8 +596,12 @@ func (mdr *Minder) readPHProbes() {
 		mdr.errors.Add(err)
 		mdr.Readings.IrrigPHVoltage, err = mdr.irrigPH.Read()
 		mdr.errors.Add(err)
-		mdr.Readings.IrrigPHRaw, err = mdr.irrigPH.Value()
+		temp, mode := mdr.cfg.PHTempComp.temp(mdr.Readings.IrrigECTemp)
+		setPHTemp(mdr.Readings.IrrigPHTemp, temp, mode)
+		mdr.Readings.IrrigPHComp = mode
+		mdr.phUnrounded["irrig_ph_raw"], err = mdr.irrigPH.UnroundedAt(temp)
 		mdr.errors.Add(err)
+		mdr.Readings.IrrigPHRaw = roundPH(mdr.phUnrounded["irrig_ph_raw"])
 		mdr.Readings.IrrigPH, err = mdr.tr.Translate("irrig_ph", mdr.Readings.IrrigPHRaw)
 		mdr.errors.Add(err)
 	}
@@ -203,13 +611,28 @@ func (mdr *Minder) readPHProbes() {
 		mdr.errors.Add(err)
 		mdr.Readings.RunoffPHVoltage, err = mdr.runoffPH.Read()
 		mdr.errors.Add(err)
-		mdr.Readings.RunoffPHRaw, err = mdr.runoffPH.Value()
+		temp, mode := mdr.cfg.PHTempComp.temp(mdr.Readings.RunoffECTemp)
+		setPHTemp(mdr.Readings.RunoffPHTemp, temp, mode)
+		mdr.Readings.RunoffPHComp = mode
+		mdr.phUnrounded["runoff_ph_raw"], err = mdr.runoffPH.UnroundedAt(temp)
 		mdr.errors.Add(err)
+		mdr.Readings.RunoffPHRaw = roundPH(mdr.phUnrounded["runoff_ph_raw"])
 		mdr.Readings.RunoffPH, err = mdr.tr.Translate("runoff_ph", mdr.Readings.RunoffPHRaw)
 		mdr.errors.Add(err)
 	}
 }
 
+// sessionValues returns the readings for the calibration sessions, which take
+// the raw pH before it is rounded so the points are finer than 0.1 pH
+func (mdr *Minder) sessionValues() map[string]float64 {
+	values := mdr.Readings.Values()
+	for field, v := range mdr.phUnrounded {
+		values[field] = v
+	}
+
+	return values
+}
+
 func (mdr *Minder) readMoistureProbe() {
 	var err error
 
@@ -223,20 +646,62 @@ func (mdr *Minder) readMoistureProbe() {
 	}
 }
 
+func (mdr *Minder) readTippingBuckets() {
+	if mdr.irrigTB != nil {
+		mdr.Readings.IrrigTipRate = mdr.irrigTB.Rate()
+		mdr.Readings.IrrigBounces = mdr.irrigTB.Bounces()
+	}
+
+	if mdr.runoffTB != nil {
+		mdr.Readings.RunoffTipRate = mdr.runoffTB.Rate()
+		mdr.Readings.RunoffBounces = mdr.runoffTB.Bounces()
+	}
+}
+
 func (mdr *Minder) readECProbes() {
 	var err error
 
 	mdr.Readings.IrrigECRaw, mdr.Readings.IrrigECTemp = mdr.bus.ProbeReadings(mdr.cfg.IrrigECProbe)
-	ec, err := mdr.tr.Translate("irrig_ec", mdr.Readings.IrrigECRaw.Value())
+	ec, err := mdr.tr.Translate(mdr.calibrationKey("irrig_ec"), mdr.Readings.IrrigECRaw.Value())
 	mdr.errors.Add(err)
 	mdr.Readings.IrrigEC.SetValue(ec)
 	mdr.Readings.IrrigEC.Valid = mdr.Readings.IrrigECRaw.IsValid()
 
 	mdr.Readings.RunoffECRaw, mdr.Readings.RunoffECTemp = mdr.bus.ProbeReadings(mdr.cfg.RunoffECProbe)
-	ec, err = mdr.tr.Translate("runoff_ec", mdr.Readings.RunoffECRaw.Value())
+	ec, err = mdr.tr.Translate(mdr.calibrationKey("runoff_ec"), mdr.Readings.RunoffECRaw.Value())
 	mdr.errors.Add(err)
 	mdr.Readings.RunoffEC.SetValue(ec)
 	mdr.Readings.RunoffEC.Valid = mdr.Readings.RunoffECRaw.IsValid()
+
+	mdr.checkProbeOnline("irrig", mdr.cfg.IrrigECProbe, mdr.Readings.IrrigECRaw.IsValid())
+	mdr.checkProbeOnline("runoff", mdr.cfg.RunoffECProbe, mdr.Readings.RunoffECRaw.IsValid())
+}
+
+// checkProbeOnline sends a notification when a probe drops off the bus, and
+// when it comes back again
+func (mdr *Minder) checkProbeOnline(side, sn string, online bool) {
+	if mdr.probesOnline == nil {
+		mdr.probesOnline = map[string]bool{}
+	}
+
+	// nothing to report until the probe has been on the bus
+	was, seen := mdr.probesOnline[sn]
+	if sn == "" || (!seen && !online) {
+		return
+	}
+
+	mdr.probesOnline[sn] = online
+
+	data := map[string]string{"side": side, "serial": sn}
+	switch {
+	case was && !online:
+		log.Printf("%s EC probe %s has dropped off the bus", side, sn)
+		mdr.notify(EventProbeOffline, data)
+
+	case seen && !was && online:
+		log.Printf("%s EC probe %s is back on the bus", side, sn)
+		mdr.notify(EventProbeOnline, data)
+	}
 }
 
 func (mdr *Minder) swapECProbes() {
@@ -0,0 +1,147 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is a Modbus TCP client
+type Client struct {
+	conn    net.Conn
+	unitID  byte
+	tid     uint16
+	Timeout time.Duration
+	mu      *sync.Mutex
+}
+
+// Dial connects to the Modbus TCP server at the address, sending requests to
+// the given unit ID
+func Dial(addr string, unitID byte) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, unitID: unitID, Timeout: 10 * time.Second, mu: new(sync.Mutex)}, nil
+}
+
+// Close closes the connection to the server
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ReadDiscreteInputs reads count discrete inputs starting at addr
+func (c *Client) ReadDiscreteInputs(addr, count uint16) ([]bool, error) {
+	res, err := c.do(FuncReadDiscreteInputs, addrCount(addr, count))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) < 1 || len(res)-1 != int(res[0]) || int(res[0]) < (int(count)+7)/8 {
+		return nil, fmt.Errorf("bad discrete inputs response")
+	}
+
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = res[1+i/8]&(1<<uint(i%8)) != 0
+	}
+
+	return bits, nil
+}
+
+// ReadInputRegisters reads count input registers starting at addr
+func (c *Client) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	return c.readRegisters(FuncReadInputRegisters, addr, count)
+}
+
+// ReadHoldingRegisters reads count holding registers starting at addr
+func (c *Client) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
+	return c.readRegisters(FuncReadHoldingRegisters, addr, count)
+}
+
+// WriteRegister writes a single holding register
+func (c *Client) WriteRegister(addr, value uint16) error {
+	_, err := c.do(FuncWriteSingleRegister, addrCount(addr, value))
+	return err
+}
+
+// WriteRegisters writes the values to the holding registers starting at addr
+func (c *Client) WriteRegisters(addr uint16, values []uint16) error {
+	data := append(addrCount(addr, uint16(len(values))), byte(len(values)*2))
+	for _, v := range values {
+		data = append(data, byte(v>>8), byte(v))
+	}
+
+	_, err := c.do(FuncWriteMultipleRegisters, data)
+	return err
+}
+
+func (c *Client) readRegisters(fn byte, addr, count uint16) ([]uint16, error) {
+	res, err := c.do(fn, addrCount(addr, count))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) != 1+int(count)*2 || int(res[0]) != int(count)*2 {
+		return nil, fmt.Errorf("bad registers response")
+	}
+
+	regs := make([]uint16, count)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(res[1+i*2:])
+	}
+
+	return regs, nil
+}
+
+// do sends the request and returns the data of the response
+func (c *Client) do(fn byte, data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tid++
+	req := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(req, c.tid)
+	binary.BigEndian.PutUint16(req[4:], uint16(len(data)+2))
+	req[6] = c.unitID
+	req[7] = fn
+	req = append(req, data...)
+
+	c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint16(header[4:])
+	if binary.BigEndian.Uint16(header) != c.tid || length < 2 {
+		return nil, fmt.Errorf("bad response header")
+	}
+
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(c.conn, pdu); err != nil {
+		return nil, err
+	}
+
+	if pdu[0] == fn|0x80 && len(pdu) == 2 {
+		return nil, Exception(pdu[1])
+	}
+
+	if pdu[0] != fn {
+		return nil, fmt.Errorf("response for function 0x%02X, expected 0x%02X", pdu[0], fn)
+	}
+
+	return pdu[1:], nil
+}
+
+func addrCount(addr, count uint16) []byte {
+	return []byte{byte(addr >> 8), byte(addr), byte(count >> 8), byte(count)}
+}
@@ -0,0 +1,317 @@
+// Package modbus is a small Modbus TCP server and client supporting the
+// discrete input and register function codes
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// The function codes that are supported
+const (
+	FuncReadDiscreteInputs     = 0x02
+	FuncReadHoldingRegisters   = 0x03
+	FuncReadInputRegisters     = 0x04
+	FuncWriteSingleRegister    = 0x06
+	FuncWriteMultipleRegisters = 0x10
+)
+
+// the most values that can be read or written in one request
+const (
+	maxReadBits       = 2000
+	maxReadRegisters  = 125
+	maxWriteRegisters = 123
+)
+
+// idleTimeout is how long a client connection can be idle before it is closed
+const idleTimeout = 5 * time.Minute
+
+// Exception is a Modbus exception code that is returned to the client
+type Exception byte
+
+// The exceptions that can be returned by the handlers
+const (
+	ErrIllegalFunction    Exception = 0x01
+	ErrIllegalAddress     Exception = 0x02
+	ErrIllegalValue       Exception = 0x03
+	ErrServerDeviceFailed Exception = 0x04
+)
+
+var exceptionNames = map[Exception]string{
+	ErrIllegalFunction:    "illegal function",
+	ErrIllegalAddress:     "illegal data address",
+	ErrIllegalValue:       "illegal data value",
+	ErrServerDeviceFailed: "server device failure",
+}
+
+func (e Exception) Error() string {
+	if name, ok := exceptionNames[e]; ok {
+		return "modbus exception: " + name
+	}
+	return fmt.Sprintf("modbus exception 0x%02X", byte(e))
+}
+
+// Handler provides the data for a server, returning an Exception from any of
+// the methods sends it to the client, any other error is sent as a server
+// device failure
+type Handler interface {
+	ReadDiscreteInputs(addr, count uint16) ([]bool, error)
+	ReadInputRegisters(addr, count uint16) ([]uint16, error)
+	ReadHoldingRegisters(addr, count uint16) ([]uint16, error)
+	WriteHoldingRegisters(addr uint16, values []uint16) error
+}
+
+// Float32Registers returns the IEEE 754 float as two registers, high word first
+func Float32Registers(v float32) []uint16 {
+	bits := math.Float32bits(v)
+	return []uint16{uint16(bits >> 16), uint16(bits)}
+}
+
+// RegistersFloat32 returns the IEEE 754 float held in two registers, high word first
+func RegistersFloat32(regs []uint16) float32 {
+	return math.Float32frombits(uint32(regs[0])<<16 | uint32(regs[1]))
+}
+
+// Server answers Modbus TCP requests using the handler
+type Server struct {
+	handler   Handler
+	unitID    byte
+	ln        net.Listener
+	conns     map[net.Conn]bool
+	onErrorCB func(error)
+	mu        *sync.Mutex
+}
+
+// NewServer returns a new server for the handler, it only answers requests for
+// the given unit ID, or all of them if it is 0
+func NewServer(h Handler, unitID byte) *Server {
+	return &Server{
+		handler:   h,
+		unitID:    unitID,
+		conns:     map[net.Conn]bool{},
+		onErrorCB: func(error) {},
+		mu:        new(sync.Mutex),
+	}
+}
+
+// OnError registers a function to call when a client connection fails
+func (s *Server) OnError(cb func(error)) {
+	s.onErrorCB = cb
+}
+
+// ListenAndServe listens on the TCP address and serves the clients that connect
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve accepts connections from the listener until it is closed
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener and closes the client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	if s.ln == nil {
+		return nil
+	}
+
+	return s.ln.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		// MBAP header: transaction ID, protocol ID, length, unit ID
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if err != io.EOF {
+				s.onErrorCB(fmt.Errorf("modbus client %s: %s", conn.RemoteAddr(), err))
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint16(header[4:])
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+			s.onErrorCB(fmt.Errorf("modbus client %s: bad frame header", conn.RemoteAddr()))
+			return
+		}
+
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			s.onErrorCB(fmt.Errorf("modbus client %s: %s", conn.RemoteAddr(), err))
+			return
+		}
+
+		unit := header[6]
+		if s.unitID != 0 && unit != s.unitID {
+			continue
+		}
+
+		res := s.handle(pdu)
+
+		binary.BigEndian.PutUint16(header[4:], uint16(len(res)+1))
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write(append(header, res...)); err != nil {
+			s.onErrorCB(fmt.Errorf("modbus client %s: %s", conn.RemoteAddr(), err))
+			return
+		}
+	}
+}
+
+// handle returns the response PDU for the request PDU
+func (s *Server) handle(pdu []byte) []byte {
+	fn := pdu[0]
+	res, err := s.dispatch(fn, pdu[1:])
+	if err == nil {
+		return append([]byte{fn}, res...)
+	}
+
+	ex, ok := err.(Exception)
+	if !ok {
+		s.onErrorCB(fmt.Errorf("modbus function 0x%02X failed: %s", fn, err))
+		ex = ErrServerDeviceFailed
+	}
+
+	return []byte{fn | 0x80, byte(ex)}
+}
+
+func (s *Server) dispatch(fn byte, data []byte) ([]byte, error) {
+	switch fn {
+	case FuncReadDiscreteInputs, FuncReadHoldingRegisters, FuncReadInputRegisters:
+		if len(data) != 4 {
+			return nil, ErrIllegalValue
+		}
+
+		addr := binary.BigEndian.Uint16(data)
+		count := binary.BigEndian.Uint16(data[2:])
+
+		if fn == FuncReadDiscreteInputs {
+			if count == 0 || count > maxReadBits {
+				return nil, ErrIllegalValue
+			}
+
+			bits, err := s.handler.ReadDiscreteInputs(addr, count)
+			if err != nil {
+				return nil, err
+			}
+
+			return packBits(bits), nil
+		}
+
+		if count == 0 || count > maxReadRegisters {
+			return nil, ErrIllegalValue
+		}
+
+		var regs []uint16
+		var err error
+		if fn == FuncReadInputRegisters {
+			regs, err = s.handler.ReadInputRegisters(addr, count)
+		} else {
+			regs, err = s.handler.ReadHoldingRegisters(addr, count)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return packRegisters(regs), nil
+
+	case FuncWriteSingleRegister:
+		if len(data) != 4 {
+			return nil, ErrIllegalValue
+		}
+
+		addr := binary.BigEndian.Uint16(data)
+		if err := s.handler.WriteHoldingRegisters(addr, []uint16{binary.BigEndian.Uint16(data[2:])}); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+
+	case FuncWriteMultipleRegisters:
+		if len(data) < 5 {
+			return nil, ErrIllegalValue
+		}
+
+		addr := binary.BigEndian.Uint16(data)
+		count := binary.BigEndian.Uint16(data[2:])
+		if count == 0 || count > maxWriteRegisters || int(data[4]) != int(count)*2 || len(data) != 5+int(count)*2 {
+			return nil, ErrIllegalValue
+		}
+
+		values := make([]uint16, count)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(data[5+i*2:])
+		}
+
+		if err := s.handler.WriteHoldingRegisters(addr, values); err != nil {
+			return nil, err
+		}
+
+		return data[:4], nil
+	}
+
+	return nil, ErrIllegalFunction
+}
+
+// packBits returns the byte count followed by the bits packed LSB first
+func packBits(bits []bool) []byte {
+	data := make([]byte, 1+(len(bits)+7)/8)
+	data[0] = byte(len(data) - 1)
+	for i, b := range bits {
+		if b {
+			data[1+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// packRegisters returns the byte count followed by the registers
+func packRegisters(regs []uint16) []byte {
+	data := make([]byte, 1+len(regs)*2)
+	data[0] = byte(len(regs) * 2)
+	for i, r := range regs {
+		binary.BigEndian.PutUint16(data[1+i*2:], r)
+	}
+	return data
+}
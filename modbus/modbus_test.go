@@ -0,0 +1,113 @@
+package modbus
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeHandler has 10 discrete inputs and input and holding registers
+type fakeHandler struct {
+	bits    []bool
+	inputs  []uint16
+	holding []uint16
+}
+
+func (h *fakeHandler) ReadDiscreteInputs(addr, count uint16) ([]bool, error) {
+	if int(addr+count) > len(h.bits) {
+		return nil, ErrIllegalAddress
+	}
+	return h.bits[addr : addr+count], nil
+}
+
+func (h *fakeHandler) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	if int(addr+count) > len(h.inputs) {
+		return nil, ErrIllegalAddress
+	}
+	return h.inputs[addr : addr+count], nil
+}
+
+func (h *fakeHandler) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
+	if int(addr+count) > len(h.holding) {
+		return nil, ErrIllegalAddress
+	}
+	return h.holding[addr : addr+count], nil
+}
+
+func (h *fakeHandler) WriteHoldingRegisters(addr uint16, values []uint16) error {
+	if int(addr)+len(values) > len(h.holding) {
+		return ErrIllegalAddress
+	}
+	copy(h.holding[addr:], values)
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	Convey("given a server and a client connected to it", t, func() {
+		h := &fakeHandler{
+			bits:    []bool{true, false, true, true, false, false, false, false, false, true},
+			inputs:  append([]uint16{1, 2, 3}, Float32Registers(6.25)...),
+			holding: make([]uint16, 4),
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		srv := NewServer(h, 1)
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		c, err := Dial(ln.Addr().String(), 1)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		Convey("the discrete inputs should be read", func() {
+			bits, err := c.ReadDiscreteInputs(0, 10)
+			So(err, ShouldBeNil)
+			So(bits, ShouldResemble, h.bits)
+		})
+
+		Convey("the input registers should be read", func() {
+			regs, err := c.ReadInputRegisters(1, 4)
+			So(err, ShouldBeNil)
+			So(regs[:2], ShouldResemble, []uint16{2, 3})
+			So(RegistersFloat32(regs[2:]), ShouldEqual, 6.25)
+		})
+
+		Convey("the holding registers should be written and read back", func() {
+			So(c.WriteRegister(0, 7), ShouldBeNil)
+			So(c.WriteRegisters(2, []uint16{8, 9}), ShouldBeNil)
+
+			regs, err := c.ReadHoldingRegisters(0, 4)
+			So(err, ShouldBeNil)
+			So(regs, ShouldResemble, []uint16{7, 0, 8, 9})
+		})
+
+		Convey("reading past the end should return an illegal address exception", func() {
+			_, err := c.ReadInputRegisters(4, 2)
+			So(err, ShouldEqual, ErrIllegalAddress)
+		})
+
+		Convey("reading too many registers should return an illegal value exception", func() {
+			_, err := c.ReadInputRegisters(0, 126)
+			So(err, ShouldEqual, ErrIllegalValue)
+		})
+
+		Convey("an unsupported function should return an illegal function exception", func() {
+			_, err := c.do(0x01, addrCount(0, 1))
+			So(err, ShouldEqual, ErrIllegalFunction)
+		})
+	})
+}
+
+func TestFloat32Registers(t *testing.T) {
+	Convey("floats should survive being split into registers", t, func() {
+		for _, v := range []float32{0, -1.5, 3.14159, 123456.7} {
+			So(RegistersFloat32(Float32Registers(v)), ShouldEqual, v)
+		}
+
+		So(math.IsNaN(float64(RegistersFloat32(Float32Registers(float32(math.NaN()))))), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,194 @@
+package openminder
+
+import (
+	"log"
+	"math"
+
+	"github.com/autogrow/openminder/modbus"
+)
+
+// ModbusConfig is the configuration for the Modbus TCP server
+type ModbusConfig struct {
+	// Listen is the address to serve Modbus TCP on, e.g. :502, the server is
+	// not started if this is empty
+	Listen string `json:"listen"`
+
+	// UnitID is the unit ID to answer to, all unit IDs are answered if this is 0
+	UnitID int `json:"unit_id"`
+}
+
+const (
+	// modbusFloatBase is the address of the first float input register
+	modbusFloatBase = 1000
+
+	// modbusNoValue is put in the scaled register of a reading that isn't
+	// valid, it is -32768 when read as a signed register
+	modbusNoValue = 0x8000
+)
+
+// the discrete inputs
+const (
+	modbusIrrigECValid = iota
+	modbusRunoffECValid
+	modbusAlertActive
+	modbusDiscreteInputs
+)
+
+// the holding registers, writing 1 to them carries out the action
+const (
+	modbusResetCounters = iota
+	modbusRescanBus
+	modbusSwapProbes
+	modbusHoldingRegisters
+)
+
+// modbusRegister is a reading and the scale applied to it in its scaled register
+type modbusRegister struct {
+	field string
+	scale float64
+}
+
+// modbusRegisters is the input register map, the reading at index i is in the
+// scaled register i and the float registers 1000+2i and 1000+2i+1, new
+// readings must be added to the end so the addresses don't change.  The scaled
+// registers are clamped to 32767, which the counts and volumes pass once there
+// have been enough tips, so they need to be read from the float registers
+var modbusRegisters = []modbusRegister{
+	{"irrig_ec", 100},
+	{"runoff_ec", 100},
+	{"irrig_ph", 100},
+	{"runoff_ph", 100},
+	{"irrig_ectemp", 10},
+	{"runoff_ectemp", 10},
+	{"irrig_volume", 1},
+	{"runoff_volume", 1},
+	{"irrig_tips", 1},
+	{"runoff_tips", 1},
+	{"runoff_ratio", 1000},
+	{"moisture", 100},
+	{"irrig_tip_rate", 10},
+	{"runoff_tip_rate", 10},
+	{"irrig_bounces", 1},
+	{"runoff_bounces", 1},
+	{"irrig_ec_raw", 100},
+	{"runoff_ec_raw", 100},
+	{"irrig_ph_raw", 100},
+	{"runoff_ph_raw", 100},
+	{"irrig_ph_voltage", 1000},
+	{"runoff_ph_voltage", 1000},
+	{"moisture_voltage", 1000},
+	{"irrig_adc", 1},
+	{"runoff_adc", 1},
+	{"moisture_adc", 1},
+	{"irrig_ph_temp", 10},
+	{"runoff_ph_temp", 10},
+}
+
+// scaledRegister returns the reading multiplied by the scale as a signed 16 bit
+// register, clamped to the range of the register
+func scaledRegister(v float64, scale float64) uint16 {
+	v = math.Floor(v*scale + 0.5)
+	switch {
+	case v > math.MaxInt16:
+		v = math.MaxInt16
+	case v < math.MinInt16+1:
+		v = math.MinInt16 + 1
+	}
+	return uint16(int16(v))
+}
+
+// modbusHandler answers the Modbus requests with the minder's readings
+type modbusHandler struct {
+	mdr *Minder
+}
+
+func (h modbusHandler) ReadDiscreteInputs(addr, count uint16) ([]bool, error) {
+	if int(addr)+int(count) > modbusDiscreteInputs {
+		return nil, modbus.ErrIllegalAddress
+	}
+
+	r := h.mdr.Readings
+	bits := []bool{
+		r.IrrigECRaw != nil && r.IrrigECRaw.IsValid(),
+		r.RunoffECRaw != nil && r.RunoffECRaw.IsValid(),
+		h.mdr.alerts != nil && len(h.mdr.alerts.Active()) > 0,
+	}
+
+	return bits[addr : addr+count], nil
+}
+
+func (h modbusHandler) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	values := h.mdr.Readings.Values()
+	regs := make([]uint16, count)
+
+	for i := range regs {
+		a := int(addr) + i
+
+		switch {
+		case a < len(modbusRegisters):
+			reg := modbusRegisters[a]
+			v, ok := values[reg.field]
+			if !ok {
+				regs[i] = modbusNoValue
+				continue
+			}
+			regs[i] = scaledRegister(v, reg.scale)
+
+		case a >= modbusFloatBase && a < modbusFloatBase+2*len(modbusRegisters):
+			v, ok := values[modbusRegisters[(a-modbusFloatBase)/2].field]
+			if !ok {
+				v = math.NaN()
+			}
+			regs[i] = modbus.Float32Registers(float32(v))[(a-modbusFloatBase)%2]
+
+		default:
+			return nil, modbus.ErrIllegalAddress
+		}
+	}
+
+	return regs, nil
+}
+
+func (h modbusHandler) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
+	if int(addr)+int(count) > modbusHoldingRegisters {
+		return nil, modbus.ErrIllegalAddress
+	}
+
+	return make([]uint16, count), nil
+}
+
+func (h modbusHandler) WriteHoldingRegisters(addr uint16, values []uint16) error {
+	if int(addr)+len(values) > modbusHoldingRegisters {
+		return modbus.ErrIllegalAddress
+	}
+
+	for _, v := range values {
+		if v > 1 {
+			return modbus.ErrIllegalValue
+		}
+	}
+
+	for i, v := range values {
+		if v == 0 {
+			continue
+		}
+
+		switch int(addr) + i {
+		case modbusResetCounters:
+			log.Printf("resetting the counters from modbus")
+			if _, err := h.mdr.ResetCounters(); err != nil {
+				return err
+			}
+
+		case modbusRescanBus:
+			log.Printf("rescanning the bus from modbus")
+			go h.mdr.bus.Rescan()
+
+		case modbusSwapProbes:
+			log.Printf("swapping the EC probes from modbus")
+			h.mdr.swapECProbes()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package openminder
+
+import (
+	"net"
+	"testing"
+
+	"github.com/autogrow/openminder/modbus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestModbusRegisters(t *testing.T) {
+	Convey("every reading should have a register", t, func() {
+		mapped := map[string]bool{}
+		for _, reg := range modbusRegisters {
+			So(mapped[reg.field], ShouldBeFalse)
+			mapped[reg.field] = true
+		}
+
+		for _, f := range ReadingFields() {
+			So(mapped, ShouldContainKey, f)
+		}
+	})
+
+	Convey("scaled registers should be rounded and clamped", t, func() {
+		So(int16(scaledRegister(2.345, 100)), ShouldEqual, 235)
+		So(int16(scaledRegister(-1.5, 10)), ShouldEqual, -15)
+		So(int16(scaledRegister(100000, 1)), ShouldEqual, 32767)
+		So(int16(scaledRegister(-100000, 1)), ShouldEqual, -32767)
+	})
+}
+
+func TestModbusServer(t *testing.T) {
+	Convey("given a minder served over modbus", t, func() {
+		jdb := newTestDB(t)
+
+		mdr := &Minder{
+			Readings:      newReadings(),
+			cfg:           &Config{IrrigECProbe: "A", RunoffECProbe: "B"},
+			tr:            &Translater{jdb},
+			counters:      newCounterStore(jdb),
+			errors:        newErrorStore(),
+			onCfgChangeCB: func(Config) {},
+		}
+		mdr.Readings.IrrigEC.SetValue(2.15)
+		mdr.Readings.IrrigECRaw.SetValue(2.1)
+		mdr.Readings.IrrigPH = 5.8
+		mdr.Readings.IrrigTips = 12
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		srv := modbus.NewServer(modbusHandler{mdr}, 0)
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		c, err := modbus.Dial(ln.Addr().String(), 1)
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		Convey("the scaled registers should hold the readings", func() {
+			regs, err := c.ReadInputRegisters(0, 3)
+			So(err, ShouldBeNil)
+			So(regs[0], ShouldEqual, 215)
+			So(regs[1], ShouldEqual, modbusNoValue)
+			So(regs[2], ShouldEqual, 580)
+		})
+
+		Convey("the float registers should hold the readings", func() {
+			regs, err := c.ReadInputRegisters(modbusFloatBase+2*8, 2)
+			So(err, ShouldBeNil)
+			So(modbus.RegistersFloat32(regs), ShouldEqual, 12)
+		})
+
+		Convey("a volume too big for its scaled register should still be in the float ones", func() {
+			mdr.Readings.IrrigVolume = 123456
+
+			regs, err := c.ReadInputRegisters(6, 1)
+			So(err, ShouldBeNil)
+			So(regs[0], ShouldEqual, 32767)
+
+			regs, err = c.ReadInputRegisters(modbusFloatBase+2*6, 2)
+			So(err, ShouldBeNil)
+			So(modbus.RegistersFloat32(regs), ShouldEqual, 123456)
+		})
+
+		Convey("reading past the registers should fail", func() {
+			_, err := c.ReadInputRegisters(uint16(len(modbusRegisters)), 1)
+			So(err, ShouldEqual, modbus.ErrIllegalAddress)
+		})
+
+		Convey("the status bits should show which probes are valid", func() {
+			bits, err := c.ReadDiscreteInputs(0, 3)
+			So(err, ShouldBeNil)
+			So(bits, ShouldResemble, []bool{true, false, false})
+		})
+
+		Convey("writing to the reset counters register should reset them", func() {
+			mdr.counters.AddIrrigTip()
+			So(c.WriteRegister(modbusResetCounters, 1), ShouldBeNil)
+			So(mdr.Readings.IrrigTips, ShouldEqual, 0)
+		})
+
+		Convey("writing to the swap probes register should swap them", func() {
+			So(c.WriteRegister(modbusSwapProbes, 1), ShouldBeNil)
+			So(mdr.cfg.IrrigECProbe, ShouldEqual, "B")
+			So(mdr.cfg.RunoffECProbe, ShouldEqual, "A")
+		})
+
+		Convey("writing other values should fail", func() {
+			So(c.WriteRegister(modbusResetCounters, 2), ShouldEqual, modbus.ErrIllegalValue)
+		})
+	})
+}
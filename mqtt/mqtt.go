@@ -0,0 +1,396 @@
+// Package mqtt is a small MQTT 3.1.1 client that can publish messages at QoS 0 or 1
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The MQTT control packet types used by the client
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// ErrClosed is returned when the connection to the broker has been closed
+var ErrClosed = errors.New("mqtt connection closed")
+
+// connackErrors are the reasons the broker can give for refusing the connection
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Options are the options for connecting to a broker
+type Options struct {
+	// Addr is the host:port of the broker
+	Addr string
+
+	// TLS is used to connect to the broker over TLS if it is not nil
+	TLS *tls.Config
+
+	ClientID string
+	Username string
+	Password string
+
+	// KeepAlive is how often to ping the broker, defaults to a minute
+	KeepAlive time.Duration
+
+	// Timeout is how long to wait for the broker to respond, defaults to 10 seconds
+	Timeout time.Duration
+
+	// The will is published by the broker when the client disconnects unexpectedly
+	WillTopic   string
+	WillPayload []byte
+	WillRetain  bool
+}
+
+// Client is a connection to an MQTT broker
+type Client struct {
+	conn     net.Conn
+	opts     Options
+	mu       *sync.Mutex
+	packetID uint16
+	acks     map[uint16]chan struct{}
+	done     chan struct{}
+	err      error
+}
+
+// Dial connects to the broker with the given options
+func Dial(opts Options) (*Client, error) {
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if opts.Timeout == 0 {
+		dialer.Timeout = 10 * time.Second
+	}
+
+	var conn net.Conn
+	var err error
+	if opts.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", opts.Addr, opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", opts.Addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, opts)
+	if err != nil {
+		conn.Close()
+	}
+
+	return c, err
+}
+
+// NewClient connects to the broker over the given connection
+func NewClient(conn net.Conn, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = time.Minute
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	c := &Client{
+		conn: conn,
+		opts: opts,
+		mu:   new(sync.Mutex),
+		acks: map[uint16]chan struct{}{},
+		done: make(chan struct{}),
+	}
+
+	conn.SetDeadline(time.Now().Add(opts.Timeout))
+	if _, err := conn.Write(connectPacket(opts)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	typ, body, err := readPacket(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != typeConnack || len(body) != 2 {
+		return nil, fmt.Errorf("expected connack, got packet type %d", typ)
+	}
+
+	if code := body[1]; code != 0 {
+		if msg, ok := connackErrors[code]; ok {
+			return nil, fmt.Errorf("connection refused: %s", msg)
+		}
+		return nil, fmt.Errorf("connection refused: code %d", code)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	go c.readLoop(r)
+	go c.pingLoop()
+
+	return c, nil
+}
+
+// Publish sends the message to the broker, waiting for it to be acknowledged
+// if the QoS is 1.  QoS 2 is not supported.
+func (c *Client) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return fmt.Errorf("qos %d is not supported", qos)
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+
+	var id uint16
+	var ack chan struct{}
+	if qos == 1 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+
+		id = c.packetID
+		ack = make(chan struct{})
+		c.acks[id] = ack
+	}
+
+	err := c.write(publishPacket(topic, payload, qos, retain, id))
+	c.mu.Unlock()
+
+	if err != nil {
+		c.fail(err)
+		return err
+	}
+
+	if qos == 0 {
+		return nil
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return c.error()
+	case <-time.After(c.opts.Timeout):
+		c.mu.Lock()
+		delete(c.acks, id)
+		c.mu.Unlock()
+		return fmt.Errorf("timed out waiting for puback")
+	}
+}
+
+// Done returns a channel that is closed when the connection is lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.write([]byte{typeDisconnect << 4, 0})
+	}
+	c.mu.Unlock()
+
+	c.fail(ErrClosed)
+	return nil
+}
+
+// write the packet to the connection, this should be called with the lock held
+func (c *Client) write(pkt []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+func (c *Client) error() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fail closes the connection with the given error, if it's not already closed
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.conn.Close()
+	close(c.done)
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		typ, body, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		switch typ {
+		case typePuback:
+			if len(body) != 2 {
+				continue
+			}
+
+			id := uint16(body[0])<<8 | uint16(body[1])
+			c.mu.Lock()
+			if ack, ok := c.acks[id]; ok {
+				close(ack)
+				delete(c.acks, id)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Client) pingLoop() {
+	t := time.NewTicker(c.opts.KeepAlive / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.mu.Lock()
+			err := c.write([]byte{typePingreq << 4, 0})
+			c.mu.Unlock()
+
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func connectPacket(opts Options) []byte {
+	var flags byte = 0x02 // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+
+	payload := appendString(nil, opts.ClientID)
+
+	if opts.WillTopic != "" {
+		flags |= 0x04
+		if opts.WillRetain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.WillTopic)
+		payload = appendBytes(payload, opts.WillPayload)
+	}
+
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+	}
+
+	if opts.Password != "" {
+		flags |= 0x40
+		payload = appendString(payload, opts.Password)
+	}
+
+	keepAlive := uint16(opts.KeepAlive / time.Second)
+	body = append(body, flags, byte(keepAlive>>8), byte(keepAlive))
+	return packet(typeConnect<<4, append(body, payload...))
+}
+
+func publishPacket(topic string, payload []byte, qos byte, retain bool, id uint16) []byte {
+	header := byte(typePublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendString(nil, topic)
+	if qos > 0 {
+		body = append(body, byte(id>>8), byte(id))
+	}
+
+	return packet(header, append(body, payload...))
+}
+
+// packet builds a packet with the fixed header and remaining length
+func packet(header byte, body []byte) []byte {
+	pkt := []byte{header}
+
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	return append(pkt, body...)
+}
+
+// readPacket reads a packet returning its type and the body after the fixed header
+func readPacket(r io.ByteReader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	for mult := 1; ; mult *= 128 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+
+		if mult > 128*128*128 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	for i := range body {
+		if body[i], err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return header >> 4, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,141 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readString reads a length prefixed string from the body
+func readString(body []byte) (string, []byte) {
+	n := int(body[0])<<8 | int(body[1])
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+func TestClient(t *testing.T) {
+	Convey("given a connection to a broker", t, func() {
+		client, broker := net.Pipe()
+		defer broker.Close()
+		r := bufio.NewReader(broker)
+
+		opts := Options{
+			ClientID:    "minder",
+			Username:    "user",
+			Password:    "pass",
+			WillTopic:   "om/status",
+			WillPayload: []byte("offline"),
+			WillRetain:  true,
+			Timeout:     time.Second,
+		}
+
+		Convey("when the broker accepts the connection", func() {
+			connect := make(chan []byte, 1)
+			go func() {
+				typ, body, err := readPacket(r)
+				if err == nil && typ == typeConnect {
+					connect <- body
+				}
+				broker.Write([]byte{typeConnack << 4, 2, 0, 0})
+			}()
+
+			c, err := NewClient(client, opts)
+			So(err, ShouldBeNil)
+			defer c.Close()
+
+			Convey("it should have sent the connect packet", func() {
+				body := <-connect
+				proto, body := readString(body)
+				So(proto, ShouldEqual, "MQTT")
+				So(body[0], ShouldEqual, 4)
+				So(body[1], ShouldEqual, 0x80|0x40|0x20|0x04|0x02)
+				So(int(body[2])<<8|int(body[3]), ShouldEqual, 60)
+
+				id, body := readString(body[4:])
+				So(id, ShouldEqual, "minder")
+				will, body := readString(body)
+				So(will, ShouldEqual, "om/status")
+				payload, body := readString(body)
+				So(payload, ShouldEqual, "offline")
+				user, body := readString(body)
+				So(user, ShouldEqual, "user")
+				pass, _ := readString(body)
+				So(pass, ShouldEqual, "pass")
+			})
+
+			Convey("and a message is published at QoS 1", func() {
+				<-connect
+				published := make(chan []byte, 1)
+				go func() {
+					typ, body, err := readPacket(r)
+					if err != nil || typ != typePublish {
+						return
+					}
+					published <- body
+					_, rest := readString(body)
+					broker.Write([]byte{typePuback << 4, 2, rest[0], rest[1]})
+				}()
+
+				err := c.Publish("om/irrig_ec", []byte("2.1"), 1, true)
+
+				Convey("it should be acknowledged", func() {
+					So(err, ShouldBeNil)
+
+					body := <-published
+					topic, rest := readString(body)
+					So(topic, ShouldEqual, "om/irrig_ec")
+					So(string(rest[2:]), ShouldEqual, "2.1")
+				})
+			})
+
+			Convey("and the broker goes away", func() {
+				<-connect
+				broker.Close()
+
+				Convey("the client should be done", func() {
+					select {
+					case <-c.Done():
+					case <-time.After(time.Second):
+					}
+					So(c.Publish("om/irrig_ec", []byte("2.1"), 0, false), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("when the broker refuses the connection", func() {
+			go func() {
+				readPacket(r)
+				broker.Write([]byte{typeConnack << 4, 2, 0, 4})
+			}()
+
+			_, err := NewClient(client, opts)
+
+			Convey("it should return the reason", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "bad user name or password")
+			})
+		})
+	})
+}
+
+func TestPacket(t *testing.T) {
+	Convey("given a packet with a long body", t, func() {
+		body := make([]byte, 321)
+		pkt := packet(typePublish<<4, body)
+
+		Convey("the remaining length should be encoded over two bytes", func() {
+			So(pkt[1], ShouldEqual, 321%128|0x80)
+			So(pkt[2], ShouldEqual, 321/128)
+		})
+
+		Convey("it should be read back", func() {
+			typ, read, err := readPacket(bytes.NewReader(pkt))
+			So(err, ShouldBeNil)
+			So(typ, ShouldEqual, typePublish)
+			So(read, ShouldHaveLength, 321)
+		})
+	})
+}
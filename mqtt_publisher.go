@@ -0,0 +1,278 @@
+package openminder
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/autogrow/openminder/mqtt"
+)
+
+// MQTTConfig is the configuration for publishing the readings to an MQTT broker
+type MQTTConfig struct {
+	// Host is the host:port of the broker, the readings are not published if this is empty
+	Host string `json:"host"`
+
+	// TLS connects to the broker over TLS, TLSInsecure skips the certificate checks
+	TLS         bool `json:"tls"`
+	TLSInsecure bool `json:"tls_insecure"`
+
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// ClientID defaults to openminder-<hostname>
+	ClientID string `json:"client_id"`
+
+	// Prefix is put before the topics, defaults to openminder/<hostname>
+	Prefix string `json:"prefix"`
+
+	// QoS is the QoS to publish with, 0 or 1
+	QoS int `json:"qos"`
+
+	// Retain sets the retain flag on the readings
+	Retain bool `json:"retain"`
+
+	// Interval is how often in seconds all the readings are published, changed
+	// readings are published straight away
+	Interval int `json:"interval"`
+
+	// Discovery publishes the Home Assistant discovery config for the readings
+	Discovery bool `json:"discovery"`
+
+	// DiscoveryPrefix is the Home Assistant discovery prefix, defaults to homeassistant
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+func (cfg MQTTConfig) withDefaults() MQTTConfig {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "minder"
+	}
+
+	if cfg.ClientID == "" {
+		cfg.ClientID = "openminder-" + host
+	}
+
+	if cfg.Prefix == "" {
+		cfg.Prefix = "openminder/" + host
+	}
+
+	// QoS 2 isn't supported so settle for at least once
+	if cfg.QoS > 1 {
+		cfg.QoS = 1
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = 60
+	}
+
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = "homeassistant"
+	}
+
+	return cfg
+}
+
+// discoverySensor describes a reading to Home Assistant
+type discoverySensor struct {
+	field       string
+	name        string
+	unit        string
+	deviceClass string
+}
+
+// the readings that are given to Home Assistant as sensors
+var discoverySensors = []discoverySensor{
+	{"irrig_ec", "Irrigation EC", "mS/cm", ""},
+	{"runoff_ec", "Runoff EC", "mS/cm", ""},
+	{"irrig_ectemp", "Irrigation Temperature", "°C", "temperature"},
+	{"runoff_ectemp", "Runoff Temperature", "°C", "temperature"},
+	{"irrig_ph", "Irrigation pH", "pH", ""},
+	{"runoff_ph", "Runoff pH", "pH", ""},
+	{"irrig_volume", "Irrigation Volume", "mL", ""},
+	{"runoff_volume", "Runoff Volume", "mL", ""},
+	{"runoff_ratio", "Runoff Ratio", "", ""},
+	{"moisture", "Moisture", "%", "moisture"},
+}
+
+// mqttPublisher publishes the readings to an MQTT broker, reconnecting if the
+// connection is lost
+type mqttPublisher struct {
+	cfg       MQTTConfig
+	values    func() map[string]float64
+	onErrorCB func(error)
+	client    *mqtt.Client
+	last      map[string]float64
+}
+
+func newMQTTPublisher(cfg MQTTConfig, values func() map[string]float64) *mqttPublisher {
+	return &mqttPublisher{
+		cfg:       cfg.withDefaults(),
+		values:    values,
+		onErrorCB: func(error) {},
+	}
+}
+
+// OnError registers a function to call when there is an error publishing
+func (pub *mqttPublisher) OnError(cb func(error)) {
+	pub.onErrorCB = cb
+}
+
+func (pub *mqttPublisher) statusTopic() string {
+	return pub.cfg.Prefix + "/status"
+}
+
+func (pub *mqttPublisher) topic(field string) string {
+	return pub.cfg.Prefix + "/" + field
+}
+
+func (pub *mqttPublisher) connect() error {
+	opts := mqtt.Options{
+		Addr:        pub.cfg.Host,
+		ClientID:    pub.cfg.ClientID,
+		Username:    pub.cfg.Username,
+		Password:    pub.cfg.Password,
+		WillTopic:   pub.statusTopic(),
+		WillPayload: []byte("offline"),
+		WillRetain:  true,
+	}
+
+	if pub.cfg.TLS {
+		opts.TLS = &tls.Config{InsecureSkipVerify: pub.cfg.TLSInsecure}
+	}
+
+	c, err := mqtt.Dial(opts)
+	if err != nil {
+		return err
+	}
+
+	pub.client = c
+	pub.last = map[string]float64{}
+
+	if err := c.Publish(pub.statusTopic(), []byte("online"), byte(pub.cfg.QoS), true); err != nil {
+		return err
+	}
+
+	if pub.cfg.Discovery {
+		return pub.publishDiscovery()
+	}
+
+	return nil
+}
+
+// run connects to the broker and publishes the readings every second if they have
+// changed, and all of them at the interval
+func (pub *mqttPublisher) run() {
+	wait := time.Second
+
+	for {
+		if err := pub.connect(); err != nil {
+			pub.onErrorCB(fmt.Errorf("failed to connect to MQTT broker %s: %s", pub.cfg.Host, err))
+			if pub.client != nil {
+				pub.client.Close()
+			}
+
+			time.Sleep(wait)
+			if wait < time.Minute {
+				wait *= 2
+			}
+			continue
+		}
+
+		log.Printf("publishing readings to MQTT broker %s under %s", pub.cfg.Host, pub.cfg.Prefix)
+		wait = time.Second
+
+		if err := pub.publishLoop(); err != nil {
+			pub.onErrorCB(fmt.Errorf("failed to publish to MQTT broker %s: %s", pub.cfg.Host, err))
+		}
+
+		pub.client.Close()
+	}
+}
+
+func (pub *mqttPublisher) publishLoop() error {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	interval := time.Duration(pub.cfg.Interval) * time.Second
+	var lastAll time.Time
+
+	for {
+		all := time.Since(lastAll) >= interval
+		if all {
+			lastAll = time.Now()
+		}
+
+		if err := pub.publish(all); err != nil {
+			return err
+		}
+
+		select {
+		case <-pub.client.Done():
+			return fmt.Errorf("connection lost")
+		case <-tick.C:
+		}
+	}
+}
+
+// publish the readings that have changed, or all of them
+func (pub *mqttPublisher) publish(all bool) error {
+	for field, v := range pub.values() {
+		if last, ok := pub.last[field]; ok && last == v && !all {
+			continue
+		}
+
+		payload := strconv.FormatFloat(v, 'f', -1, 64)
+		if err := pub.client.Publish(pub.topic(field), []byte(payload), byte(pub.cfg.QoS), pub.cfg.Retain); err != nil {
+			return err
+		}
+
+		pub.last[field] = v
+	}
+
+	return nil
+}
+
+// publishDiscovery publishes the Home Assistant MQTT discovery config for the sensors
+func (pub *mqttPublisher) publishDiscovery() error {
+	device := map[string]interface{}{
+		"identifiers":  []string{pub.cfg.ClientID},
+		"name":         "OpenMinder",
+		"manufacturer": "Autogrow",
+		"model":        "OpenMinder",
+	}
+
+	for _, s := range discoverySensors {
+		cfg := map[string]interface{}{
+			"name":               s.name,
+			"state_topic":        pub.topic(s.field),
+			"availability_topic": pub.statusTopic(),
+			"unique_id":          pub.cfg.ClientID + "_" + s.field,
+			"device":             device,
+		}
+
+		if s.unit != "" {
+			cfg["unit_of_measurement"] = s.unit
+		}
+
+		if s.deviceClass != "" {
+			cfg["device_class"] = s.deviceClass
+		}
+
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", pub.cfg.DiscoveryPrefix, pub.cfg.ClientID, s.field)
+		if err := pub.client.Publish(topic, data, byte(pub.cfg.QoS), true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package openminder
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeBroker accepts a connection and sends the topics and payloads it is published
+func fakeBroker(ln net.Listener, msgs chan [2]string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		length, mult := 0, 1
+		for {
+			b, _ := r.ReadByte()
+			length += int(b&0x7f) * mult
+			mult *= 128
+			if b&0x80 == 0 {
+				break
+			}
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1:
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3:
+			n := int(body[0])<<8 | int(body[1])
+			msgs <- [2]string{string(body[2 : 2+n]), string(body[2+n:])}
+		}
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	Convey("given a publisher connected to a broker", t, func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer ln.Close()
+
+		msgs := make(chan [2]string, 100)
+		go fakeBroker(ln, msgs)
+
+		values := map[string]float64{"irrig_ec": 2.1, "runoff_ph": 6.2}
+		pub := newMQTTPublisher(MQTTConfig{Host: ln.Addr().String(), Prefix: "om", ClientID: "om1", Discovery: true}, func() map[string]float64 {
+			return values
+		})
+
+		So(pub.connect(), ShouldBeNil)
+		defer pub.client.Close()
+
+		received := func() map[string]string {
+			got := map[string]string{}
+			for {
+				select {
+				case m := <-msgs:
+					got[m[0]] = m[1]
+				case <-time.After(100 * time.Millisecond):
+					return got
+				}
+			}
+		}
+
+		Convey("it should publish its status and the Home Assistant discovery config", func() {
+			got := received()
+			So(got["om/status"], ShouldEqual, "online")
+
+			cfg := map[string]interface{}{}
+			So(json.Unmarshal([]byte(got["homeassistant/sensor/om1/irrig_ec/config"]), &cfg), ShouldBeNil)
+			So(cfg["state_topic"], ShouldEqual, "om/irrig_ec")
+			So(cfg["unique_id"], ShouldEqual, "om1_irrig_ec")
+
+			So(json.Unmarshal([]byte(got["homeassistant/sensor/om1/moisture/config"]), &cfg), ShouldBeNil)
+			So(cfg["device_class"], ShouldEqual, "moisture")
+		})
+
+		Convey("when the readings are published", func() {
+			received()
+			So(pub.publish(false), ShouldBeNil)
+
+			Convey("it should publish each reading to its own topic", func() {
+				got := received()
+				So(got, ShouldResemble, map[string]string{"om/irrig_ec": "2.1", "om/runoff_ph": "6.2"})
+			})
+
+			Convey("and one of them changes", func() {
+				received()
+				values["runoff_ph"] = 6.3
+				So(pub.publish(false), ShouldBeNil)
+
+				Convey("only the changed one should be published", func() {
+					So(received(), ShouldResemble, map[string]string{"om/runoff_ph": "6.3"})
+				})
+
+				Convey("unless all the readings are asked for", func() {
+					So(pub.publish(true), ShouldBeNil)
+					So(received(), ShouldHaveLength, 2)
+				})
+			})
+		})
+	})
+}
+
+func TestMQTTConfigDefaults(t *testing.T) {
+	Convey("given an MQTT config with no settings", t, func() {
+		cfg := MQTTConfig{QoS: 2}.withDefaults()
+
+		Convey("it should fill in the defaults", func() {
+			So(cfg.ClientID, ShouldStartWith, "openminder-")
+			So(strings.HasPrefix(cfg.Prefix, "openminder/"), ShouldBeTrue)
+			So(cfg.Interval, ShouldEqual, 60)
+			So(cfg.DiscoveryPrefix, ShouldEqual, "homeassistant")
+			So(cfg.QoS, ShouldEqual, 1)
+		})
+	})
+}
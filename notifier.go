@@ -0,0 +1,325 @@
+package openminder
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// The types of event that are sent to the webhooks
+const (
+	EventAlertRaised        = "alert.raised"
+	EventAlertCleared       = "alert.cleared"
+	EventProbeOffline       = "probe.offline"
+	EventProbeOnline        = "probe.online"
+	EventScanDone           = "bus.scan_done"
+	EventCalibrationChanged = "calibration.changed"
+)
+
+// SignatureHeader is the header that holds the HMAC-SHA256 signature of the payload
+const SignatureHeader = "X-OpenMinder-Signature"
+
+const (
+	// maxOutboxAge is how long a notification is retried for before it is dropped
+	maxOutboxAge = 72 * time.Hour
+
+	// maxBackoff is the longest time to wait between retries
+	maxBackoff = 10 * time.Minute
+)
+
+// WebhookConfig is the configuration for a webhook that events are posted to
+type WebhookConfig struct {
+	// URL is where the events are posted to
+	URL string `json:"url"`
+
+	// Secret is used to sign the payloads, they are not signed if this is empty
+	Secret string `json:"secret"`
+
+	// Events is the types of event to send, all events are sent if this is empty
+	Events []string `json:"events"`
+}
+
+func (wh WebhookConfig) wants(typ string) bool {
+	if len(wh.Events) == 0 {
+		return true
+	}
+
+	for _, e := range wh.Events {
+		if e == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event is the payload that is posted to the webhooks
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// delivery is a payload waiting in the outbox to be posted to a webhook
+type delivery struct {
+	URL         string          `json:"url"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of the payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifier posts events to the webhooks, keeping them in an outbox on disk
+// until they are delivered so they survive network outages and restarts
+type notifier struct {
+	db     *bolt.DB
+	bucket []byte
+	hooks  []WebhookConfig
+	client *http.Client
+	wake   chan struct{}
+	mu     *sync.Mutex
+}
+
+func newNotifier(db *bolt.DB, hooks []WebhookConfig) (*notifier, error) {
+	n := &notifier{
+		db:     db,
+		bucket: []byte("outbox"),
+		hooks:  hooks,
+		client: &http.Client{Timeout: 10 * time.Second},
+		wake:   make(chan struct{}, 1),
+		mu:     new(sync.Mutex),
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(n.bucket)
+		return err
+	})
+
+	return n, err
+}
+
+// Notify puts the event in the outbox for each webhook that wants it
+func (n *notifier) Notify(typ string, data interface{}) error {
+	now := time.Now()
+
+	payload, err := json.Marshal(Event{typ, now, data})
+	if err != nil {
+		return err
+	}
+
+	err = n.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(n.bucket)
+
+		for _, wh := range n.hooks {
+			if !wh.wants(typ) {
+				continue
+			}
+
+			data, err := json.Marshal(delivery{URL: wh.URL, Type: typ, Payload: payload, Created: now, NextAttempt: now})
+			if err != nil {
+				return err
+			}
+
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+
+	return err
+}
+
+// run delivers the notifications in the outbox as they become due
+func (n *notifier) run(onError func(error)) {
+	for {
+		if err := n.deliverDue(time.Now()); err != nil {
+			onError(err)
+		}
+
+		select {
+		case <-n.wake:
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+// deliverDue tries to post the notifications that are due, rescheduling the
+// ones that fail with an exponential backoff.  The notifications for each
+// webhook are posted in the order they were sent, so once one of them is
+// waiting to be retried the ones after it wait too
+func (n *notifier) deliverDue(now time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	type outboxed struct {
+		key []byte
+		delivery
+	}
+
+	// the keys are sequence numbers so the outbox is walked in the order the
+	// notifications were sent
+	due := []outboxed{}
+	waiting := map[string]bool{}
+	err := n.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(n.bucket).ForEach(func(k, v []byte) error {
+			d := delivery{}
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+
+			if d.NextAttempt.After(now) {
+				waiting[d.URL] = true
+			}
+
+			if !waiting[d.URL] {
+				due = append(due, outboxed{append([]byte{}, k...), d})
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	// post outside of the transaction so the database isn't held up by the network
+	done := []outboxed{}
+	retry := []outboxed{}
+	failed := map[string]bool{}
+	for _, o := range due {
+		if failed[o.URL] {
+			continue
+		}
+
+		hook, ok := n.hook(o.URL)
+		if !ok {
+			log.Printf("dropping %s notification for %s as it is no longer configured", o.Type, o.URL)
+			done = append(done, o)
+			continue
+		}
+
+		err := n.post(hook, o.delivery)
+		if err == nil {
+			done = append(done, o)
+			continue
+		}
+
+		// don't post anything after it to the webhook until it gets through
+		failed[o.URL] = true
+
+		o.Attempts++
+		if now.Sub(o.Created) > maxOutboxAge {
+			log.Printf("dropping %s notification for %s after %d attempts: %s", o.Type, o.URL, o.Attempts, err)
+			done = append(done, o)
+			continue
+		}
+
+		o.NextAttempt = now.Add(backoff(o.Attempts))
+		retry = append(retry, o)
+	}
+
+	return n.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(n.bucket)
+
+		for _, o := range done {
+			if err := b.Delete(o.key); err != nil {
+				return err
+			}
+		}
+
+		for _, o := range retry {
+			data, err := json.Marshal(o.delivery)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(o.key, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (n *notifier) hook(url string) (WebhookConfig, bool) {
+	for _, wh := range n.hooks {
+		if wh.URL == url {
+			return wh, true
+		}
+	}
+
+	return WebhookConfig{}, false
+}
+
+func (n *notifier) post(wh WebhookConfig, d delivery) error {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OpenMinder-Event", d.Type)
+	if wh.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(wh.Secret, d.Payload))
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected http status: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff returns how long to wait before the next attempt
+func backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return d
+}
@@ -0,0 +1,154 @@
+package openminder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotifier(t *testing.T) {
+	Convey("given a webhook server and a notifier", t, func() {
+		jdb := newTestDB(t)
+
+		status := 200
+		type request struct {
+			event     Event
+			signature string
+			valid     bool
+		}
+		requests := []request{}
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			ev := Event{}
+			json.Unmarshal(body, &ev)
+
+			sig := r.Header.Get(SignatureHeader)
+			requests = append(requests, request{ev, sig, sig == "sha256="+Sign("s3cret", body)})
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		n, err := newNotifier(jdb.db, []WebhookConfig{
+			{URL: srv.URL + "/all", Secret: "s3cret"},
+			{URL: srv.URL + "/alerts", Events: []string{EventAlertRaised}},
+		})
+		So(err, ShouldBeNil)
+
+		outbox := func() int {
+			count := 0
+			jdb.db.View(func(tx *bolt.Tx) error {
+				count = tx.Bucket(n.bucket).Stats().KeyN
+				return nil
+			})
+			return count
+		}
+
+		Convey("when an event is sent that only one webhook wants", func() {
+			So(n.Notify(EventScanDone, map[string]string{"a": "b"}), ShouldBeNil)
+			So(n.deliverDue(time.Now()), ShouldBeNil)
+
+			Convey("it should post a signed payload to that webhook", func() {
+				So(requests, ShouldHaveLength, 1)
+				So(requests[0].event.Type, ShouldEqual, EventScanDone)
+				So(requests[0].valid, ShouldBeTrue)
+				So(outbox(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("when an event is sent that both webhooks want", func() {
+			So(n.Notify(EventAlertRaised, Alert{Name: "test"}), ShouldBeNil)
+			So(n.deliverDue(time.Now()), ShouldBeNil)
+
+			Convey("it should post to both webhooks, only signing when there is a secret", func() {
+				So(requests, ShouldHaveLength, 2)
+				for _, r := range requests {
+					So(r.event.Type, ShouldEqual, EventAlertRaised)
+					if r.signature != "" {
+						So(r.valid, ShouldBeTrue)
+					}
+				}
+			})
+		})
+
+		Convey("when the webhook is failing", func() {
+			status = 500
+			So(n.Notify(EventScanDone, nil), ShouldBeNil)
+			now := time.Now()
+			So(n.deliverDue(now), ShouldBeNil)
+
+			Convey("it should keep the notification in the outbox", func() {
+				So(requests, ShouldHaveLength, 1)
+				So(outbox(), ShouldEqual, 1)
+
+				Convey("and not retry until the backoff has passed", func() {
+					So(n.deliverDue(now.Add(time.Second/2)), ShouldBeNil)
+					So(requests, ShouldHaveLength, 1)
+				})
+
+				Convey("and deliver it once the webhook is working again", func() {
+					status = 200
+					So(n.deliverDue(now.Add(2*time.Second)), ShouldBeNil)
+					So(requests, ShouldHaveLength, 2)
+					So(outbox(), ShouldEqual, 0)
+				})
+
+				Convey("and drop it when it is too old", func() {
+					So(n.deliverDue(now.Add(maxOutboxAge+time.Hour)), ShouldBeNil)
+					So(outbox(), ShouldEqual, 0)
+				})
+
+				Convey("and hold back the notifications sent after it", func() {
+					status = 200
+					So(n.Notify(EventProbeOnline, nil), ShouldBeNil)
+					So(n.deliverDue(now.Add(time.Second/2)), ShouldBeNil)
+					So(requests, ShouldHaveLength, 1)
+
+					So(n.deliverDue(now.Add(2*time.Second)), ShouldBeNil)
+					So(requests, ShouldHaveLength, 3)
+					So(requests[1].event.Type, ShouldEqual, EventScanDone)
+					So(requests[2].event.Type, ShouldEqual, EventProbeOnline)
+				})
+			})
+		})
+
+		Convey("when a lot of events are sent", func() {
+			types := []string{EventProbeOffline, EventProbeOnline, EventScanDone, EventCalibrationChanged}
+			for i := 0; i < 5; i++ {
+				for _, typ := range types {
+					So(n.Notify(typ, i), ShouldBeNil)
+				}
+			}
+
+			Convey("they should be posted in the order they were sent", func() {
+				So(n.deliverDue(time.Now()), ShouldBeNil)
+				So(requests, ShouldHaveLength, 20)
+				for i, r := range requests {
+					So(r.event.Type, ShouldEqual, types[i%len(types)])
+				}
+			})
+
+			Convey("the webhook should not be posted to again after it fails", func() {
+				status = 500
+				So(n.deliverDue(time.Now()), ShouldBeNil)
+				So(requests, ShouldHaveLength, 1)
+				So(outbox(), ShouldEqual, 20)
+			})
+		})
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	Convey("the backoff should double up to the maximum", t, func() {
+		So(backoff(1), ShouldEqual, time.Second)
+		So(backoff(2), ShouldEqual, 2*time.Second)
+		So(backoff(5), ShouldEqual, 16*time.Second)
+		So(backoff(50), ShouldEqual, maxBackoff)
+	})
+}
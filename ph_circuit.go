@@ -1,13 +1,65 @@
 package openminder
 
-import "math"
+import (
+	"math"
+
+	"github.com/autogrow/openminder/types"
+)
 
 const (
 	phPerVolt = 0.059
 	phRef     = 1.220
 	opampGain = 2
+
+	// phTempRef is the temperature in °C that phPerVolt is the slope at
+	phTempRef = 25.0
 )
 
+// The ways that the pH readings can be compensated for temperature
+const (
+	PHCompNone   = "none"
+	PHCompProbe  = "probe"
+	PHCompManual = "manual"
+)
+
+// PHTempCompConfig is the configuration for compensating the pH readings for
+// the temperature of the water
+type PHTempCompConfig struct {
+	// Mode is how the temperature is found, "probe" uses the temperature from the
+	// EC probe on the same side and "manual" uses ManualTemp, anything else turns
+	// the compensation off
+	Mode string `json:"mode"`
+
+	// ManualTemp is the temperature of the water in °C for the manual mode, it is
+	// also used in the probe mode when the EC probe has no reading.  It is a
+	// pointer so that 0°C can be told apart from it not being set
+	ManualTemp *float64 `json:"manual_temp,omitempty"`
+}
+
+// temp returns the temperature to compensate the pH readings for and the mode
+// that it came from, given the temperature from the EC probe on the same side
+func (cfg PHTempCompConfig) temp(probeTemp *types.NullFloat) (float64, string) {
+	switch {
+	case cfg.Mode == PHCompProbe && probeTemp != nil && probeTemp.IsValid():
+		return probeTemp.Value(), PHCompProbe
+	case (cfg.Mode == PHCompProbe || cfg.Mode == PHCompManual) && cfg.ManualTemp != nil:
+		return *cfg.ManualTemp, PHCompManual
+	}
+
+	return phTempRef, PHCompNone
+}
+
+// setPHTemp sets the reading of the temperature the pH was compensated for, it
+// is left without a value when the pH isn't compensated
+func setPHTemp(reading *types.NullFloat, temp float64, mode string) {
+	if mode == PHCompNone {
+		reading.SetInvalid()
+		return
+	}
+
+	reading.SetValue(temp)
+}
+
 // PHCircuit models a PH circuit that contains an ADC
 type PHCircuit struct {
 	ADC
@@ -20,18 +72,38 @@ func NewPHCircuit(adc ADC) *PHCircuit {
 
 // Value returns the pH value that this circuit reports
 func (phc *PHCircuit) Value() (float64, error) {
-	var ph float64
+	return phc.ValueAt(phTempRef)
+}
+
+// ValueAt returns the pH value that this circuit reports with the slope of the
+// probe compensated for the temperature of the water in °C, rounded to 0.1 pH
+func (phc *PHCircuit) ValueAt(temp float64) (float64, error) {
+	ph, err := phc.UnroundedAt(temp)
+	return roundPH(ph), err
+}
+
+// UnroundedAt returns the pH value the same as ValueAt but without rounding it,
+// so that calibration points can be taken more finely than the readings
+func (phc *PHCircuit) UnroundedAt(temp float64) (float64, error) {
 	volts, err := phc.Read()
 	if err != nil {
-		return ph, err
+		return 0, err
 	}
 
 	volts = (volts * -1) / opampGain
-	ph = voltsToPH(phPerVolt, volts)
-	return ph, nil
+	return voltsToPH(phPerVoltAt(temp), volts), nil
+}
+
+// phPerVoltAt returns the slope of a pH probe at the temperature in °C, which
+// goes up in proportion to the absolute temperature
+func phPerVoltAt(temp float64) float64 {
+	return phPerVolt * (temp + 273.15) / (phTempRef + 273.15)
 }
 
 func voltsToPH(phPerVolt, volts float64) float64 {
-	ph := 7.0 + (volts / phPerVolt)
-	return math.Abs(math.Round(ph/0.1) * 0.1)
+	return math.Abs(7.0 + (volts / phPerVolt))
+}
+
+func roundPH(ph float64) float64 {
+	return math.Round(ph/0.1) * 0.1
 }
@@ -0,0 +1,103 @@
+package openminder
+
+import (
+	"testing"
+
+	"github.com/autogrow/openminder/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPHCircuit(t *testing.T) {
+	Convey("given a pH circuit with the probe in pH4 at 25°C", t, func() {
+		fadc := &fakeADC{v: 3 * phPerVolt * opampGain}
+		phc := NewPHCircuit(fadc)
+
+		Convey("the uncompensated value should be pH4", func() {
+			ph, err := phc.Value()
+			So(err, ShouldBeNil)
+			So(ph, ShouldAlmostEqual, 4)
+		})
+
+		Convey("the value compensated for 25°C should be the same", func() {
+			ph, err := phc.ValueAt(25)
+			So(err, ShouldBeNil)
+			So(ph, ShouldAlmostEqual, 4)
+		})
+
+		Convey("when the water is at 15°C the same voltage is further from pH7", func() {
+			ph, err := phc.ValueAt(15)
+			So(err, ShouldBeNil)
+			So(ph, ShouldAlmostEqual, 3.9)
+
+			Convey("but only rounded to 0.1 pH", func() {
+				ph, err := phc.UnroundedAt(15)
+				So(err, ShouldBeNil)
+				So(ph, ShouldAlmostEqual, 7-3*298.15/288.15)
+			})
+		})
+	})
+
+	Convey("the slope should follow the absolute temperature", t, func() {
+		So(phPerVoltAt(25), ShouldAlmostEqual, phPerVolt)
+		So(phPerVoltAt(35), ShouldAlmostEqual, phPerVolt*308.15/298.15)
+	})
+}
+
+func TestPHTempComp(t *testing.T) {
+	manual := 21.0
+
+	Convey("given a temperature from the EC probe", t, func() {
+		probe := &types.NullFloat{}
+		probe.SetValue(18.5)
+
+		Convey("the probe mode should use it", func() {
+			temp, mode := PHTempCompConfig{Mode: PHCompProbe}.temp(probe)
+			So(temp, ShouldEqual, 18.5)
+			So(mode, ShouldEqual, PHCompProbe)
+		})
+
+		Convey("the manual mode should use the manual temperature", func() {
+			temp, mode := PHTempCompConfig{Mode: PHCompManual, ManualTemp: &manual}.temp(probe)
+			So(temp, ShouldEqual, 21)
+			So(mode, ShouldEqual, PHCompManual)
+		})
+
+		Convey("no mode should not compensate", func() {
+			temp, mode := PHTempCompConfig{ManualTemp: &manual}.temp(probe)
+			So(temp, ShouldEqual, phTempRef)
+			So(mode, ShouldEqual, PHCompNone)
+		})
+	})
+
+	Convey("a manual temperature of 0°C should be used", t, func() {
+		zero := 0.0
+		temp, mode := PHTempCompConfig{Mode: PHCompManual, ManualTemp: &zero}.temp(nil)
+		So(temp, ShouldEqual, 0)
+		So(mode, ShouldEqual, PHCompManual)
+	})
+
+	Convey("the temperature reading should only have a value when the pH is compensated", t, func() {
+		reading := &types.NullFloat{}
+		setPHTemp(reading, 21, PHCompManual)
+		So(reading.Value(), ShouldEqual, 21)
+
+		setPHTemp(reading, phTempRef, PHCompNone)
+		So(reading.IsValid(), ShouldBeFalse)
+	})
+
+	Convey("given an EC probe without a reading", t, func() {
+		probe := &types.NullFloat{}
+
+		Convey("the probe mode should fall back to the manual temperature", func() {
+			temp, mode := PHTempCompConfig{Mode: PHCompProbe, ManualTemp: &manual}.temp(probe)
+			So(temp, ShouldEqual, 21)
+			So(mode, ShouldEqual, PHCompManual)
+		})
+
+		Convey("the probe mode should not compensate without a manual temperature", func() {
+			temp, mode := PHTempCompConfig{Mode: PHCompProbe}.temp(probe)
+			So(temp, ShouldEqual, phTempRef)
+			So(mode, ShouldEqual, PHCompNone)
+		})
+	})
+}
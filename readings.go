@@ -1,6 +1,12 @@
 package openminder
 
-import "github.com/autogrow/openminder/types"
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/autogrow/openminder/types"
+)
 
 // Readings represents the readings kept by the minder
 type Readings struct {
@@ -8,14 +14,23 @@ type Readings struct {
 	IrrigPHVoltage  float64          `json:"irrig_ph_voltage"`
 	IrrigPHRaw      float64          `json:"irrig_ph_raw"`
 	IrrigPH         float64          `json:"irrig_ph"`
+	IrrigPHTemp     *types.NullFloat `json:"irrig_ph_temp"`
+	IrrigPHComp     string           `json:"irrig_ph_comp"`
 	RunoffADC       int              `json:"runoff_adc"`
 	RunoffPHVoltage float64          `json:"runoff_ph_voltage"`
 	RunoffPHRaw     float64          `json:"runoff_ph_raw"`
 	RunoffPH        float64          `json:"runoff_ph"`
+	RunoffPHTemp    *types.NullFloat `json:"runoff_ph_temp"`
+	RunoffPHComp    string           `json:"runoff_ph_comp"`
 	IrrigTips       int64            `json:"irrig_tips"`
 	IrrigVolume     float64          `json:"irrig_volume"`
 	RunoffTips      int64            `json:"runoff_tips"`
 	RunoffVolume    float64          `json:"runoff_volume"`
+	IrrigTipRate    float64          `json:"irrig_tip_rate"`
+	IrrigBounces    int64            `json:"irrig_bounces"`
+	RunoffTipRate   float64          `json:"runoff_tip_rate"`
+	RunoffBounces   int64            `json:"runoff_bounces"`
+	CountersReset   time.Time        `json:"counters_reset"`
 	IrrigEC         *types.NullFloat `json:"irrig_ec"`
 	IrrigECRaw      *types.NullFloat `json:"irrig_ec_raw"`
 	IrrigECTemp     *types.NullFloat `json:"irrig_ectemp"`
@@ -30,6 +45,8 @@ type Readings struct {
 
 func newReadings() *Readings {
 	return &Readings{
+		IrrigPHTemp:  &types.NullFloat{},
+		RunoffPHTemp: &types.NullFloat{},
 		IrrigEC:      &types.NullFloat{},
 		IrrigECRaw:   &types.NullFloat{},
 		IrrigECTemp:  &types.NullFloat{},
@@ -38,3 +55,45 @@ func newReadings() *Readings {
 		RunoffECTemp: &types.NullFloat{},
 	}
 }
+
+// Values returns the numeric readings keyed by their JSON names, readings
+// that are not valid are left out
+func (r *Readings) Values() map[string]float64 {
+	values := map[string]float64{}
+	v := reflect.ValueOf(r).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("json")
+
+		switch f := v.Field(i).Interface().(type) {
+		case float64:
+			values[name] = f
+		case int:
+			values[name] = float64(f)
+		case int64:
+			values[name] = float64(f)
+		case *types.NullFloat:
+			if f != nil && f.IsValid() {
+				values[name] = f.Value()
+			}
+		}
+	}
+
+	return values
+}
+
+// ReadingFields returns the names of the numeric readings
+func ReadingFields() []string {
+	fields := []string{}
+	t := reflect.TypeOf(Readings{})
+
+	for i := 0; i < t.NumField(); i++ {
+		switch t.Field(i).Type {
+		case reflect.TypeOf(float64(0)), reflect.TypeOf(int(0)), reflect.TypeOf(int64(0)), reflect.TypeOf(&types.NullFloat{}):
+			fields = append(fields, t.Field(i).Tag.Get("json"))
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
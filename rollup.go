@@ -0,0 +1,276 @@
+package openminder
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Aggregate summarises the values of a reading over a period
+type Aggregate struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Last  float64 `json:"last"`
+	Count int     `json:"count"`
+}
+
+// Add will add the value to the aggregate
+func (a *Aggregate) Add(v float64) {
+	if a.Count == 0 || v < a.Min {
+		a.Min = v
+	}
+
+	if a.Count == 0 || v > a.Max {
+		a.Max = v
+	}
+
+	a.Count++
+	a.Mean += (v - a.Mean) / float64(a.Count)
+	a.Last = v
+}
+
+// Rollup is the aggregated readings over the period starting at its time
+type Rollup struct {
+	Time   time.Time             `json:"time"`
+	Values map[string]*Aggregate `json:"values"`
+}
+
+func (ru *Rollup) add(values map[string]float64) {
+	for f, v := range values {
+		a, ok := ru.Values[f]
+		if !ok {
+			a = &Aggregate{}
+			ru.Values[f] = a
+		}
+
+		a.Add(v)
+	}
+}
+
+// copy returns the rollup with its own copy of the aggregates
+func (ru Rollup) copy() Rollup {
+	out := Rollup{ru.Time, make(map[string]*Aggregate, len(ru.Values))}
+	for f, a := range ru.Values {
+		c := *a
+		out.Values[f] = &c
+	}
+
+	return out
+}
+
+func (ru Rollup) filter(fields []string) Rollup {
+	if len(fields) == 0 {
+		return ru
+	}
+
+	out := Rollup{ru.Time, map[string]*Aggregate{}}
+	for _, f := range fields {
+		if a, ok := ru.Values[f]; ok {
+			out.Values[f] = a
+		}
+	}
+
+	return out
+}
+
+// rollup keeps the rollups for one period in its own bucket
+type rollup struct {
+	name      string
+	period    time.Duration
+	retention time.Duration
+	bucket    []byte
+	current   *Rollup
+}
+
+// ErrUnknownAggregation is returned when the rollups asked for aren't kept
+var ErrUnknownAggregation = fmt.Errorf("agg must be one of 1m, 1h or 1d")
+
+// The rollups that are kept, and how long they are kept for
+var rollupPeriods = []struct {
+	name      string
+	period    time.Duration
+	retention time.Duration
+}{
+	{"1m", time.Minute, 7 * 24 * time.Hour},
+	{"1h", time.Hour, 365 * 24 * time.Hour},
+	{"1d", 24 * time.Hour, 0},
+}
+
+// start returns the start of the period the time is in, days start at midnight local time
+func (ru *rollup) start(t time.Time) time.Time {
+	if ru.period == 24*time.Hour {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+
+	return t.Truncate(ru.period)
+}
+
+// load the latest rollup from the bucket so it can be carried on with
+func (ru *rollup) load(b *bolt.Bucket) error {
+	k, v := b.Cursor().Last()
+	if k == nil {
+		return nil
+	}
+
+	ru.current = &Rollup{keyTime(k), map[string]*Aggregate{}}
+	return json.Unmarshal(v, &ru.current.Values)
+}
+
+func (ru *rollup) save(b *bolt.Bucket) error {
+	if ru.current == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(ru.current.Values)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(timeKey(ru.current.Time), data); err != nil {
+		return err
+	}
+
+	if ru.retention <= 0 {
+		return nil
+	}
+
+	return prune(b, ru.current.Time.Add(-ru.retention))
+}
+
+func (h *History) initRollups() error {
+	for _, p := range rollupPeriods {
+		h.rollups = append(h.rollups, &rollup{
+			name:      p.name,
+			period:    p.period,
+			retention: p.retention,
+			bucket:    []byte("rollup_" + p.name),
+		})
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for _, ru := range h.rollups {
+			b, err := tx.CreateBucketIfNotExists(ru.bucket)
+			if err != nil {
+				return err
+			}
+
+			if err := ru.load(b); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Observe adds the readings to the rollups.  The rollups are kept in memory and
+// saved whenever a minute has passed so this can be called as often as the
+// readings are taken.
+func (h *History) Observe(t time.Time, r *Readings) error {
+	values := r.Values()
+	save := false
+
+	h.mu.Lock()
+	for _, ru := range h.rollups {
+		start := ru.start(t)
+		if ru.current != nil && ru.current.Time.Equal(start) {
+			ru.current.add(values)
+			continue
+		}
+
+		// a period has ended so everything needs saving, including
+		// the one that just ended before it gets replaced
+		if ru.current != nil && !save {
+			save = true
+			if err := h.saveRollups(); err != nil {
+				h.mu.Unlock()
+				return err
+			}
+		}
+
+		ru.current = &Rollup{start, map[string]*Aggregate{}}
+		ru.current.add(values)
+	}
+	h.mu.Unlock()
+
+	return nil
+}
+
+// Flush saves the rollups that are still in progress
+func (h *History) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.saveRollups()
+}
+
+func (h *History) saveRollups() error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for _, ru := range h.rollups {
+			if err := ru.save(tx.Bucket(ru.bucket)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Rollups returns the rollups of the given period (1m, 1h or 1d) that start
+// between from and to (inclusive) with only the given fields, or all of them
+// if none are given
+func (h *History) Rollups(agg string, fields []string, from, to time.Time) ([]Rollup, error) {
+	rollups := []Rollup{}
+
+	var ru *rollup
+	for _, r := range h.rollups {
+		if r.name == agg {
+			ru = r
+		}
+	}
+
+	if ru == nil {
+		return rollups, ErrUnknownAggregation
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(ru.bucket).Cursor()
+		end := timeKey(to)
+
+		for k, v := c.Seek(timeKey(from)); k != nil && string(k) <= string(end); k, v = c.Next() {
+			r := Rollup{keyTime(k), map[string]*Aggregate{}}
+			if err := json.Unmarshal(v, &r.Values); err != nil {
+				return err
+			}
+
+			rollups = append(rollups, r)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return rollups, err
+	}
+
+	// the rollup in progress is likely to be newer than the saved one
+	if cur := ru.current; cur != nil && !cur.Time.Before(from) && !cur.Time.After(to) {
+		if n := len(rollups); n > 0 && rollups[n-1].Time.Equal(cur.Time) {
+			rollups = rollups[:n-1]
+		}
+		// the aggregates carry on changing after the lock is released
+		rollups = append(rollups, cur.copy())
+	}
+
+	for i := range rollups {
+		rollups[i] = rollups[i].filter(fields)
+	}
+
+	return rollups, nil
+}
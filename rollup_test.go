@@ -0,0 +1,122 @@
+package openminder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregate(t *testing.T) {
+	Convey("given an aggregate with some values added", t, func() {
+		a := &Aggregate{}
+		for _, v := range []float64{3, 1, 5, 2} {
+			a.Add(v)
+		}
+
+		Convey("it should summarise the values", func() {
+			So(a.Min, ShouldEqual, 1)
+			So(a.Max, ShouldEqual, 5)
+			So(a.Mean, ShouldEqual, 2.75)
+			So(a.Last, ShouldEqual, 2)
+			So(a.Count, ShouldEqual, 4)
+		})
+	})
+}
+
+func TestRollups(t *testing.T) {
+	Convey("given a history", t, func() {
+		jdb := newTestDB(t)
+
+		h, err := NewHistory(jdb.db, 0)
+		So(err, ShouldBeNil)
+
+		start := time.Date(2018, 1, 1, 10, 0, 0, 0, time.Local)
+		end := start.Add(24 * time.Hour)
+
+		Convey("when readings are observed every 10 seconds for 3 minutes", func() {
+			r := newReadings()
+			for i := 0; i < 18; i++ {
+				r.IrrigPH = float64(i)
+				r.RunoffEC.SetValue(1)
+				So(h.Observe(start.Add(time.Duration(i)*10*time.Second), r), ShouldBeNil)
+			}
+
+			Convey("it should give a rollup for each minute", func() {
+				rollups, err := h.Rollups("1m", []string{"irrig_ph"}, start, end)
+				So(err, ShouldBeNil)
+				So(rollups, ShouldHaveLength, 3)
+				So(rollups[0].Time.Equal(start), ShouldBeTrue)
+				So(rollups[0].Values, ShouldHaveLength, 1)
+				So(*rollups[0].Values["irrig_ph"], ShouldResemble, Aggregate{Min: 0, Max: 5, Mean: 2.5, Last: 5, Count: 6})
+				So(rollups[2].Values["irrig_ph"].Last, ShouldEqual, 17)
+			})
+
+			Convey("it should give a rollup for the hour and day", func() {
+				rollups, err := h.Rollups("1h", nil, start, end)
+				So(err, ShouldBeNil)
+				So(rollups, ShouldHaveLength, 1)
+				So(rollups[0].Values["irrig_ph"].Count, ShouldEqual, 18)
+				So(rollups[0].Values["runoff_ec"].Mean, ShouldEqual, 1)
+
+				rollups, err = h.Rollups("1d", nil, start.Add(-12*time.Hour), end)
+				So(err, ShouldBeNil)
+				So(rollups, ShouldHaveLength, 1)
+				So(rollups[0].Time.Hour(), ShouldEqual, 0)
+			})
+
+			Convey("and the history is flushed and reopened", func() {
+				So(h.Flush(), ShouldBeNil)
+				h, err = NewHistory(jdb.db, 0)
+				So(err, ShouldBeNil)
+
+				Convey("it should carry on with the rollups in progress", func() {
+					So(h.Observe(start.Add(3*time.Minute), r), ShouldBeNil)
+
+					rollups, err := h.Rollups("1h", nil, start, end)
+					So(err, ShouldBeNil)
+					So(rollups, ShouldHaveLength, 1)
+					So(rollups[0].Values["irrig_ph"].Count, ShouldEqual, 19)
+				})
+			})
+
+			Convey("the rollup in progress should not change once it has been returned", func() {
+				rollups, err := h.Rollups("1h", nil, start, end)
+				So(err, ShouldBeNil)
+				So(h.Observe(start.Add(3*time.Minute), r), ShouldBeNil)
+				So(rollups[0].Values["irrig_ph"].Count, ShouldEqual, 18)
+
+				// run with -race to check the rollups can be read while readings are observed
+				stop := make(chan bool)
+				done := make(chan bool)
+				go func() {
+					defer close(done)
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+							h.Observe(start.Add(3*time.Minute+time.Duration(i)*time.Millisecond), r)
+						}
+					}
+				}()
+
+				for i := 0; i < 100; i++ {
+					rollups, err := h.Rollups("1h", []string{"irrig_ph"}, start, end)
+					So(err, ShouldBeNil)
+					_, err = json.Marshal(rollups)
+					So(err, ShouldBeNil)
+				}
+
+				close(stop)
+				<-done
+			})
+
+			Convey("it should not give rollups for an unknown period", func() {
+				_, err := h.Rollups("1w", nil, start, end)
+				So(err, ShouldEqual, ErrUnknownAggregation)
+			})
+		})
+	})
+}
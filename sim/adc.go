@@ -0,0 +1,44 @@
+package sim
+
+import (
+	"math"
+
+	"github.com/autogrow/openminder"
+)
+
+// these mirror the pH circuit on the hat
+const (
+	phPerVolt = 0.059
+	opampGain = 2
+)
+
+// phToVolts returns the voltage the pH circuit would put on the ADC for the given pH
+func phToVolts(ph float64) float64 {
+	return -(ph - 7.0) * phPerVolt * opampGain
+}
+
+// adc is a simulated MPC3421 ADC
+type adc struct {
+	gain  int
+	volts func() float64
+}
+
+func newADC(gain int, volts func() float64) *adc {
+	if gain <= 0 {
+		gain = 1
+	}
+
+	return &adc{gain, volts}
+}
+
+// Read returns the simulated voltage
+func (a *adc) Read() (float64, error) {
+	return a.volts(), nil
+}
+
+// AnalogRead returns the 18 bit twos complement value the ADC would give for the
+// simulated voltage
+func (a *adc) AnalogRead() (int, error) {
+	bits := int(math.Round((a.volts() * float64(a.gain) / openminder.MPC3421VRef) * math.Pow(2, 17)))
+	return bits & 0x3FFFF, nil
+}
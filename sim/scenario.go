@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Scenario describes the conditions that the simulator should generate
+// readings for
+type Scenario struct {
+	// Seed is the seed for the random noise added to the signals
+	Seed int64 `json:"seed"`
+
+	// Speed is how many times faster than real time the simulation runs
+	Speed float64 `json:"speed"`
+
+	Irrigation Irrigation `json:"irrigation"`
+	Moisture   Moisture   `json:"moisture"`
+	IrrigPH    Signal     `json:"irrig_ph"`
+	RunoffPH   Signal     `json:"runoff_ph"`
+	IrrigEC    Signal     `json:"irrig_ec"`
+	RunoffEC   Signal     `json:"runoff_ec"`
+	IrrigTemp  Signal     `json:"irrig_temp"`
+	RunoffTemp Signal     `json:"runoff_temp"`
+}
+
+// Irrigation describes the irrigation events that produce the tips
+type Irrigation struct {
+	// Interval is the number of seconds between the start of each irrigation
+	Interval int `json:"interval"`
+
+	// Duration is the number of seconds each irrigation runs for
+	Duration int `json:"duration"`
+
+	// Volume is the number of mLs that pass through the irrigation bucket each irrigation
+	Volume float64 `json:"volume"`
+
+	// MLPerTip is the number of mLs it takes to tip either bucket
+	MLPerTip float64 `json:"ml_per_tip"`
+
+	// RunoffFraction is the fraction of the irrigation volume that ends up as runoff
+	RunoffFraction float64 `json:"runoff_fraction"`
+
+	// DrainLag is the number of seconds between the irrigation and runoff starting
+	DrainLag int `json:"drain_lag"`
+}
+
+func (irr Irrigation) interval() time.Duration {
+	return time.Duration(irr.Interval) * time.Second
+}
+
+func (irr Irrigation) duration() time.Duration {
+	return time.Duration(irr.Duration) * time.Second
+}
+
+func (irr Irrigation) drainLag() time.Duration {
+	return time.Duration(irr.DrainLag) * time.Second
+}
+
+// delivered returns the total volume delivered by the irrigations up until
+// the given time, with irrigations starting at time zero
+func (irr Irrigation) delivered(t, lag time.Duration, volume float64) float64 {
+	t -= lag
+	if t < 0 || irr.Interval <= 0 {
+		return 0
+	}
+
+	done := float64(t / irr.interval())
+	into := t % irr.interval()
+
+	frac := 1.0
+	if into < irr.duration() {
+		frac = float64(into) / float64(irr.duration())
+	}
+
+	return (done + frac) * volume
+}
+
+// IrrigVolume returns the total irrigation volume up until the given time
+func (irr Irrigation) IrrigVolume(t time.Duration) float64 {
+	return irr.delivered(t, 0, irr.Volume)
+}
+
+// RunoffVolume returns the total runoff volume up until the given time
+func (irr Irrigation) RunoffVolume(t time.Duration) float64 {
+	return irr.delivered(t, irr.drainLag(), irr.Volume*irr.RunoffFraction)
+}
+
+// Moisture describes the voltage produced by the moisture probe as the
+// media wets up during an irrigation and dries out afterwards
+type Moisture struct {
+	// Dry is the voltage of the probe in dry media
+	Dry float64 `json:"dry"`
+
+	// Wet is the voltage of the probe in saturated media
+	Wet float64 `json:"wet"`
+
+	// DecayPerHour is the rate that the media dries out after an irrigation
+	DecayPerHour float64 `json:"decay_per_hour"`
+}
+
+// Voltage returns the voltage of the moisture probe at the given time
+func (m Moisture) Voltage(t time.Duration, irr Irrigation) float64 {
+	since := t
+	if irr.Interval > 0 {
+		since = t % irr.interval()
+	}
+
+	saturation := math.Exp(-m.DecayPerHour * since.Hours())
+	return m.Dry + ((m.Wet - m.Dry) * saturation)
+}
+
+// Signal describes a reading that drifts over time, swings over the day and
+// has some noise on it
+type Signal struct {
+	// Start is the value of the signal at the start of the simulation
+	Start float64 `json:"start"`
+
+	// DriftPerHour is how much the signal changes each hour
+	DriftPerHour float64 `json:"drift_per_hour"`
+
+	// Diurnal is the amplitude of the daily swing of the signal
+	Diurnal float64 `json:"diurnal"`
+
+	// Noise is the standard deviation of the noise on the signal
+	Noise float64 `json:"noise"`
+}
+
+// Value returns the value of the signal at the given time
+func (s Signal) Value(t time.Duration, rnd *rand.Rand) float64 {
+	h := t.Hours()
+	v := s.Start + (s.DriftPerHour * h) + (s.Diurnal * math.Sin(2*math.Pi*h/24))
+
+	if s.Noise != 0 && rnd != nil {
+		v += rnd.NormFloat64() * s.Noise
+	}
+
+	return v
+}
+
+// DefaultScenario returns a scenario that irrigates every 2 hours with 20%
+// runoff and steady readings
+func DefaultScenario() Scenario {
+	return Scenario{
+		Seed:  1,
+		Speed: 1,
+		Irrigation: Irrigation{
+			Interval:       7200,
+			Duration:       300,
+			Volume:         500,
+			MLPerTip:       5,
+			RunoffFraction: 0.2,
+			DrainLag:       180,
+		},
+		Moisture:   Moisture{Dry: 0.4, Wet: 1.8, DecayPerHour: 0.15},
+		IrrigPH:    Signal{Start: 6.0, Noise: 0.02},
+		RunoffPH:   Signal{Start: 6.3, Noise: 0.02},
+		IrrigEC:    Signal{Start: 2.0, Noise: 0.02},
+		RunoffEC:   Signal{Start: 2.6, Noise: 0.03},
+		IrrigTemp:  Signal{Start: 20, Diurnal: 3, Noise: 0.1},
+		RunoffTemp: Signal{Start: 22, Diurnal: 5, Noise: 0.1},
+	}
+}
+
+// LoadScenario loads the scenario from the given file, any values not in the
+// file are taken from the default scenario.  The default scenario is returned
+// if the filename is empty.
+func LoadScenario(fn string) (Scenario, error) {
+	scn := DefaultScenario()
+	if fn == "" {
+		return scn, nil
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return scn, err
+	}
+
+	err = json.Unmarshal(data, &scn)
+	return scn, err
+}
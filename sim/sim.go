@@ -0,0 +1,115 @@
+// Package sim provides simulated hardware for the minder so that it can be run
+// without the OpenMinder hat
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder"
+	"github.com/autogrow/openminder/aslbus"
+	"github.com/autogrow/openminder/aslbus/emulator"
+)
+
+// The serials used for the simulated EC probes when none are configured
+const (
+	DefaultIrrigSerial  = "ASL0000000001"
+	DefaultRunoffSerial = "ASL0000000002"
+)
+
+// Simulator provides simulated hardware that generates readings as described
+// by a scenario
+type Simulator struct {
+	scn       Scenario
+	start     time.Time
+	rnd       *rand.Rand
+	mu        *sync.Mutex
+	irrigPin  string
+	runoffPin string
+	irrigSN   string
+	runoffSN  string
+}
+
+// New returns a new simulator for the given scenario.  The serials for the simulated
+// EC probes are taken from the config, or set in the config if they are empty
+func New(scn Scenario, cfg *openminder.Config) *Simulator {
+	if scn.Speed <= 0 {
+		scn.Speed = 1
+	}
+
+	if cfg.IrrigECProbe == "" {
+		cfg.IrrigECProbe = DefaultIrrigSerial
+	}
+
+	if cfg.RunoffECProbe == "" {
+		cfg.RunoffECProbe = DefaultRunoffSerial
+	}
+
+	return &Simulator{
+		scn:       scn,
+		start:     time.Now(),
+		rnd:       rand.New(rand.NewSource(scn.Seed)),
+		mu:        new(sync.Mutex),
+		irrigPin:  cfg.IrrigTBGPIO,
+		runoffPin: cfg.RunoffTBGPIO,
+		irrigSN:   cfg.IrrigECProbe,
+		runoffSN:  cfg.RunoffECProbe,
+	}
+}
+
+// Elapsed returns the amount of simulated time since the simulator was created
+func (sim *Simulator) Elapsed() time.Duration {
+	return time.Duration(float64(time.Since(sim.start)) * sim.scn.Speed)
+}
+
+// value returns the value of the given signal at the current simulated time
+func (sim *Simulator) value(s Signal) float64 {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return s.Value(sim.Elapsed(), sim.rnd)
+}
+
+// ADC returns a simulated ADC for the given address
+func (sim *Simulator) ADC(addr, gain int) (openminder.ADC, error) {
+	switch addr {
+	case openminder.IrrigPHADCAddr:
+		return newADC(gain, func() float64 { return phToVolts(sim.value(sim.scn.IrrigPH)) }), nil
+
+	case openminder.RunoffPHADCAddr:
+		return newADC(gain, func() float64 { return phToVolts(sim.value(sim.scn.RunoffPH)) }), nil
+
+	case openminder.MoistureADCAddr:
+		return newADC(gain, func() float64 { return sim.scn.Moisture.Voltage(sim.Elapsed(), sim.scn.Irrigation) }), nil
+	}
+
+	return nil, fmt.Errorf("no simulated ADC at address 0x%x", addr)
+}
+
+// ContactSwitch returns a simulated tipping bucket switch for the given pin
+func (sim *Simulator) ContactSwitch(pin string, edges bool, debounce time.Duration) (openminder.ContactSwitch, error) {
+	switch pin {
+	case sim.irrigPin:
+		return newTipSwitch(sim, sim.scn.Irrigation.IrrigVolume), nil
+
+	case sim.runoffPin:
+		return newTipSwitch(sim, sim.scn.Irrigation.RunoffVolume), nil
+	}
+
+	return nil, fmt.Errorf("no simulated tipping bucket on pin %s", pin)
+}
+
+// Bus returns an ASL bus with simulated EC probes attached to it
+func (sim *Simulator) Bus(tty string) *aslbus.Bus {
+	eb := emulator.New(
+		emulator.NewProbe(sim.irrigSN, func() (float64, float64) {
+			return sim.value(sim.scn.IrrigEC), sim.value(sim.scn.IrrigTemp)
+		}),
+		emulator.NewProbe(sim.runoffSN, func() (float64, float64) {
+			return sim.value(sim.scn.RunoffEC), sim.value(sim.scn.RunoffTemp)
+		}),
+	)
+
+	return aslbus.NewWithOpener(tty, eb.Opener())
+}
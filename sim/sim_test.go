@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/autogrow/openminder"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIrrigation(t *testing.T) {
+	Convey("given an irrigation every hour for 10 minutes", t, func() {
+		irr := Irrigation{
+			Interval:       3600,
+			Duration:       600,
+			Volume:         100,
+			RunoffFraction: 0.5,
+			DrainLag:       60,
+		}
+
+		Convey("the irrigation volume should build up during the irrigation", func() {
+			So(irr.IrrigVolume(0), ShouldEqual, 0)
+			So(irr.IrrigVolume(5*time.Minute), ShouldEqual, 50)
+			So(irr.IrrigVolume(30*time.Minute), ShouldEqual, 100)
+			So(irr.IrrigVolume(65*time.Minute), ShouldEqual, 150)
+		})
+
+		Convey("the runoff volume should lag behind the irrigation", func() {
+			So(irr.RunoffVolume(time.Minute), ShouldEqual, 0)
+			So(irr.RunoffVolume(6*time.Minute), ShouldEqual, 25)
+			So(irr.RunoffVolume(30*time.Minute), ShouldEqual, 50)
+		})
+	})
+}
+
+func TestSignal(t *testing.T) {
+	Convey("given a drifting signal", t, func() {
+		s := Signal{Start: 6, DriftPerHour: 0.1}
+
+		Convey("it should drift over time", func() {
+			So(s.Value(0, nil), ShouldEqual, 6)
+			So(s.Value(2*time.Hour, nil), ShouldAlmostEqual, 6.2, 0.0001)
+		})
+	})
+}
+
+func TestSimulatedADC(t *testing.T) {
+	Convey("given a simulated pH ADC", t, func() {
+		a := newADC(1, func() float64 { return phToVolts(5.8) })
+
+		Convey("the pH circuit should read the simulated pH", func() {
+			ph, err := openminder.NewPHCircuit(a).Value()
+			So(err, ShouldBeNil)
+			So(ph, ShouldAlmostEqual, 5.8, 0.0001)
+		})
+	})
+}
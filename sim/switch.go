@@ -0,0 +1,64 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/autogrow/openminder"
+)
+
+// tipDuration is how long the simulated bucket holds the contact closed
+const tipDuration = 150 * time.Millisecond
+
+// tipSwitch is a simulated tipping bucket switch that closes each time the
+// simulated volume fills the bucket
+type tipSwitch struct {
+	sim         *Simulator
+	volume      func(time.Duration) float64
+	onClosureCB func(openminder.Closure)
+	stop        bool
+	tips        int
+}
+
+func newTipSwitch(sim *Simulator, volume func(time.Duration) float64) *tipSwitch {
+	return &tipSwitch{sim: sim, volume: volume, onClosureCB: func(openminder.Closure) {}}
+}
+
+// OnClosure takes a function to call when the bucket tips
+func (ts *tipSwitch) OnClosure(cb func(openminder.Closure)) {
+	ts.onClosureCB = cb
+}
+
+// Stop will stop the bucket from tipping
+func (ts *tipSwitch) Stop() {
+	ts.stop = true
+}
+
+// Start will start the bucket tipping, tips before the start are not reported
+func (ts *tipSwitch) Start() {
+	ts.stop = false
+	ts.tips = ts.count()
+	go ts.loop()
+}
+
+func (ts *tipSwitch) count() int {
+	mlPerTip := ts.sim.scn.Irrigation.MLPerTip
+	if mlPerTip <= 0 {
+		return 0
+	}
+
+	return int(ts.volume(ts.sim.Elapsed()) / mlPerTip)
+}
+
+func (ts *tipSwitch) loop() {
+	for {
+		if ts.stop {
+			return
+		}
+
+		for n := ts.count(); ts.tips < n; ts.tips++ {
+			ts.onClosureCB(openminder.Closure{Time: time.Now(), Duration: tipDuration})
+		}
+
+		time.Sleep(time.Second / 10)
+	}
+}
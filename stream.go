@@ -0,0 +1,195 @@
+package openminder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autogrow/openminder/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+// The types of event that are only sent to the readings stream
+const (
+	EventReadings      = "readings"
+	EventTip           = "tip"
+	EventScanStarted   = "bus.scan_started"
+	EventProbeDetected = "bus.probe_detected"
+)
+
+const (
+	// streamBuffer is how many events are queued for a slow subscriber before
+	// events start being dropped
+	streamBuffer = 32
+
+	// streamKeepAlive is how often a comment is sent to idle SSE clients
+	streamKeepAlive = 15 * time.Second
+)
+
+// streamMessage is an event encoded ready to be sent to the subscribers
+type streamMessage struct {
+	typ  string
+	data []byte
+}
+
+// stream fans out the readings and events to the clients of the readings stream
+type stream struct {
+	subs map[chan streamMessage]bool
+	mu   *sync.Mutex
+}
+
+func newStream() *stream {
+	return &stream{
+		subs: map[chan streamMessage]bool{},
+		mu:   new(sync.Mutex),
+	}
+}
+
+// Subscribe returns a channel that receives the published events
+func (s *stream) Subscribe() chan streamMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan streamMessage, streamBuffer)
+	s.subs[ch] = true
+	return ch
+}
+
+// Unsubscribe stops the channel receiving events
+func (s *stream) Unsubscribe(ch chan streamMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+}
+
+// count returns how many subscribers there are
+func (s *stream) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// Publish sends the event to the subscribers, the data is encoded straight away
+// so they all get the same snapshot, subscribers that aren't keeping up miss out
+func (s *stream) Publish(typ string, data interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.subs) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(Event{typ, time.Now(), data})
+	if err != nil {
+		return err
+	}
+
+	msg := streamMessage{typ, payload}
+	for ch := range s.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// streamFilter returns a func that checks if an event was asked for in the
+// comma separated events query param, all events are wanted if it is empty
+func streamFilter(events string) func(string) bool {
+	wanted := map[string]bool{}
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			wanted[e] = true
+		}
+	}
+
+	return func(typ string) bool {
+		return len(wanted) == 0 || wanted[typ]
+	}
+}
+
+// readingsStreamHandler streams the readings and events as Server-Sent Events,
+// or over a WebSocket if the client asks to upgrade
+func (mdr *Minder) readingsStreamHandler() func(*gin.Context) {
+	return func(c *gin.Context) {
+		if websocket.IsUpgrade(c.Request) {
+			mdr.streamWebSocket(c)
+			return
+		}
+
+		wants := streamFilter(c.Query("events"))
+		ch := mdr.stream.Subscribe()
+		defer mdr.stream.Unsubscribe(ch)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(200)
+		c.Writer.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		gone := c.Writer.CloseNotify()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-gone:
+				return false
+			case msg := <-ch:
+				if wants(msg.typ) {
+					_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.typ, msg.data)
+					return err == nil
+				}
+			case <-keepAlive.C:
+				_, err := io.WriteString(w, ": keep-alive\n\n")
+				return err == nil
+			}
+			return true
+		})
+	}
+}
+
+// streamWebSocket sends the readings and events to the client as JSON text
+// messages until the client goes away
+func (mdr *Minder) streamWebSocket(c *gin.Context) {
+	conn, err := websocket.Upgrade(c.Writer, c.Request)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	wants := streamFilter(c.Query("events"))
+	ch := mdr.stream.Subscribe()
+	defer mdr.stream.Unsubscribe(ch)
+
+	// nothing is expected from the client, but reading notices when it leaves
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case msg := <-ch:
+			if !wants(msg.typ) {
+				continue
+			}
+
+			if err := conn.WriteText(msg.data); err != nil {
+				return
+			}
+		}
+	}
+}
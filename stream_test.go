@@ -0,0 +1,156 @@
+package openminder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStream(t *testing.T) {
+	Convey("given a stream with two subscribers", t, func() {
+		s := newStream()
+		a := s.Subscribe()
+		b := s.Subscribe()
+
+		Convey("when an event is published", func() {
+			So(s.Publish(EventTip, map[string]string{"side": "irrig"}), ShouldBeNil)
+
+			Convey("both subscribers should get it", func() {
+				for _, ch := range []chan streamMessage{a, b} {
+					msg := <-ch
+					So(msg.typ, ShouldEqual, EventTip)
+
+					ev := Event{}
+					So(json.Unmarshal(msg.data, &ev), ShouldBeNil)
+					So(ev.Type, ShouldEqual, EventTip)
+					So(ev.Data, ShouldResemble, map[string]interface{}{"side": "irrig"})
+				}
+			})
+		})
+
+		Convey("when a subscriber is not keeping up", func() {
+			for i := 0; i < streamBuffer*2; i++ {
+				s.Publish(EventReadings, i)
+			}
+
+			Convey("the extra events should be dropped", func() {
+				So(len(a), ShouldEqual, streamBuffer)
+			})
+		})
+
+		Convey("when a subscriber unsubscribes", func() {
+			s.Unsubscribe(a)
+			s.Publish(EventReadings, 1)
+
+			Convey("it should not get any more events", func() {
+				So(len(a), ShouldEqual, 0)
+				So(len(b), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("an empty filter should want every event", t, func() {
+		So(streamFilter("")(EventTip), ShouldBeTrue)
+		So(streamFilter("readings, tip")(EventTip), ShouldBeTrue)
+		So(streamFilter("readings")(EventTip), ShouldBeFalse)
+	})
+}
+
+func TestReadingsStreamHandler(t *testing.T) {
+	Convey("given a minder serving the readings stream", t, func() {
+		mdr := &Minder{stream: newStream(), errors: newErrorStore()}
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/readings/stream", mdr.readingsStreamHandler())
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		Convey("when a client connects for tip events", func() {
+			res, err := http.Get(srv.URL + "/readings/stream?events=tip")
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+
+			So(res.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+			// wait for the handler to subscribe
+			for i := 0; i < 100 && mdr.stream.count() == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			mdr.publish(EventReadings, newReadings())
+			mdr.publishTip("irrig", Closure{Time: time.Now()}, 3, 6)
+
+			Convey("it should only be sent the tips", func() {
+				lines := bufio.NewReader(res.Body)
+				event, _ := lines.ReadString('\n')
+				data, _ := lines.ReadString('\n')
+
+				So(event, ShouldEqual, "event: tip\n")
+				So(data, ShouldStartWith, "data: {")
+				So(data, ShouldContainSubstring, `"side":"irrig"`)
+				So(strings.Contains(data, `"tips":3`), ShouldBeTrue)
+			})
+		})
+
+		Convey("when a client connects over a websocket", func() {
+			conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			conn.Write([]byte("GET /readings/stream HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+
+			r := bufio.NewReader(conn)
+			res, err := http.ReadResponse(r, nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, 101)
+
+			for i := 0; i < 100 && mdr.stream.count() == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			mdr.publishTip("runoff", Closure{Time: time.Now()}, 1, 2)
+
+			Convey("it should be sent the events as text messages", func() {
+				head := make([]byte, 2)
+				_, err := io.ReadFull(r, head)
+				So(err, ShouldBeNil)
+				So(head[0], ShouldEqual, 0x81)
+				So(head[1]&0x80, ShouldEqual, 0)
+
+				// the length is in the header, or in the next 2 or 8 bytes
+				size := uint64(head[1] & 0x7f)
+				switch size {
+				case 126:
+					ext := make([]byte, 2)
+					_, err = io.ReadFull(r, ext)
+					size = uint64(binary.BigEndian.Uint16(ext))
+				case 127:
+					ext := make([]byte, 8)
+					_, err = io.ReadFull(r, ext)
+					size = binary.BigEndian.Uint64(ext)
+				}
+				So(err, ShouldBeNil)
+
+				msg := make([]byte, size)
+				_, err = io.ReadFull(r, msg)
+				So(err, ShouldBeNil)
+
+				ev := Event{}
+				So(json.Unmarshal(msg, &ev), ShouldBeNil)
+				So(ev.Type, ShouldEqual, EventTip)
+			})
+		})
+	})
+}
@@ -1,18 +1,131 @@
 package openminder
 
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecentTips is the number of tips the tipping bucket remembers
+	maxRecentTips = 100
+
+	// tipRateWindow is the window over which the tip rate is calculated
+	tipRateWindow = 5 * time.Minute
+)
+
 // TippingBucket models a tipping bucket for recording volume
 type TippingBucket struct {
-	cc *ContactClosure
+	cc      ContactSwitch
+	tips    []Closure
+	bounces int64
+	mu      *sync.Mutex
 }
 
 // NewTippingBucket creates a new tipping bucket
 func NewTippingBucket(pin string) (*TippingBucket, error) {
 	cc, err := NewContactClosure(pin)
-	return &TippingBucket{cc}, err
+	return NewTippingBucketWithSwitch(cc), err
 }
 
-// OnTip will fire the given func when a tip is recorded
-func (tb *TippingBucket) OnTip(cb func()) {
+// NewTippingBucketWithSwitch creates a new tipping bucket that records tips from
+// the given contact switch
+func NewTippingBucketWithSwitch(cc ContactSwitch) *TippingBucket {
+	return &TippingBucket{cc: cc, mu: new(sync.Mutex)}
+}
+
+// OnTip will fire the given func when a tip is recorded.  Contact bounces
+// are counted but are not reported as tips.
+func (tb *TippingBucket) OnTip(cb func(Closure)) {
+	tb.cc.OnClosure(func(cl Closure) {
+		if !tb.record(cl) {
+			return
+		}
+
+		cb(cl)
+	})
+
 	go tb.cc.Start()
-	tb.cc.OnClosure(cb)
+}
+
+// record the closure, returning true if it was a tip
+func (tb *TippingBucket) record(cl Closure) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if cl.Bounce {
+		tb.bounces++
+		return false
+	}
+
+	tb.tips = append(tb.tips, cl)
+	if len(tb.tips) > maxRecentTips {
+		tb.tips = tb.tips[len(tb.tips)-maxRecentTips:]
+	}
+
+	return true
+}
+
+// Tips returns the most recent tips
+func (tb *TippingBucket) Tips() []Closure {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return append([]Closure{}, tb.tips...)
+}
+
+// Bounces returns the number of contact bounces that have been detected
+func (tb *TippingBucket) Bounces() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.bounces
+}
+
+// Rate returns the number of tips per minute over the last few minutes
+func (tb *TippingBucket) Rate() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	count := 0
+	for _, cl := range tb.tips {
+		if time.Since(cl.Time) <= tipRateWindow {
+			count++
+		}
+	}
+
+	return float64(count) / tipRateWindow.Minutes()
+}
+
+// PourRate returns the number of tips per minute of the tips in the last few
+// minutes, worked out from the time between the first and last of them the
+// same way as the pours of a calibration session.  It is 0 until there has
+// been more than one tip
+func (tb *TippingBucket) PourRate() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if len(tb.tips) == 0 {
+		return 0
+	}
+
+	last := tb.tips[len(tb.tips)-1].Time
+	first, n := last, 0
+	for _, cl := range tb.tips {
+		if last.Sub(cl.Time) <= tipRateWindow {
+			if n == 0 {
+				first = cl.Time
+			}
+			n++
+		}
+	}
+
+	return tipRate(n, first, last)
+}
+
+// tipRate returns how fast n tips from first to last came in tips per minute
+func tipRate(n int, first, last time.Time) float64 {
+	d := last.Sub(first).Minutes()
+	if n < 2 || d <= 0 {
+		return 0
+	}
+
+	return float64(n-1) / d
 }
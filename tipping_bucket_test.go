@@ -0,0 +1,82 @@
+package openminder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeSwitch struct {
+	onClosureCB func(Closure)
+}
+
+func (fs *fakeSwitch) OnClosure(cb func(Closure)) { fs.onClosureCB = cb }
+func (fs *fakeSwitch) Start()                     {}
+func (fs *fakeSwitch) Stop()                      {}
+
+func TestTippingBucket(t *testing.T) {
+	Convey("given a tipping bucket", t, func() {
+		sw := &fakeSwitch{}
+		tb := NewTippingBucketWithSwitch(sw)
+
+		tips := 0
+		tb.OnTip(func(Closure) { tips++ })
+
+		Convey("when the switch reports closures and bounces", func() {
+			now := time.Now()
+			sw.onClosureCB(Closure{Time: now.Add(-10 * time.Minute), Duration: time.Second / 10})
+			sw.onClosureCB(Closure{Time: now.Add(-2 * time.Minute), Duration: time.Second / 10})
+			sw.onClosureCB(Closure{Time: now.Add(-time.Minute), Duration: time.Millisecond, Bounce: true})
+			sw.onClosureCB(Closure{Time: now, Duration: time.Second / 10})
+
+			Convey("it should only count the tips", func() {
+				So(tips, ShouldEqual, 3)
+				So(tb.Tips(), ShouldHaveLength, 3)
+			})
+
+			Convey("it should count the bounces", func() {
+				So(tb.Bounces(), ShouldEqual, 1)
+			})
+
+			Convey("it should give the tip rate over the recent tips", func() {
+				So(tb.Rate(), ShouldAlmostEqual, 2/tipRateWindow.Minutes())
+			})
+
+			Convey("it should give the pour rate from the time between the recent tips", func() {
+				So(tb.PourRate(), ShouldAlmostEqual, 0.5)
+			})
+		})
+
+		Convey("when a single tip is reported", func() {
+			sw.onClosureCB(Closure{Time: time.Now()})
+
+			Convey("it should not have a pour rate yet", func() {
+				So(tb.PourRate(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("when tips are reported a second apart", func() {
+			now := time.Now()
+			for i := 0; i < 10; i++ {
+				sw.onClosureCB(Closure{Time: now.Add(time.Duration(i) * time.Second)})
+			}
+
+			Convey("the pour rate should be the same as a calibration pour's", func() {
+				So(tb.PourRate(), ShouldAlmostEqual, 60)
+				So(tb.PourRate(), ShouldAlmostEqual, tipRate(10, now, now.Add(9*time.Second)))
+			})
+		})
+
+		Convey("when more tips than are remembered are reported", func() {
+			for i := 0; i < maxRecentTips+10; i++ {
+				sw.onClosureCB(Closure{Time: time.Now()})
+			}
+
+			Convey("it should only keep the most recent tips", func() {
+				So(tips, ShouldEqual, maxRecentTips+10)
+				So(tb.Tips(), ShouldHaveLength, maxRecentTips)
+			})
+		})
+	})
+}
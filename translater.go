@@ -2,6 +2,8 @@ package openminder
 
 import (
 	"fmt"
+	"math"
+	"sort"
 )
 
 var translatableFields = []string{
@@ -32,40 +34,155 @@ type Translater struct {
 	jdb *BoltedJSON
 }
 
+// The models that a calibration can use to translate a reading
+const (
+	// CalibrationModelLinear multiplies the reading by the scale and adds the offset
+	CalibrationModelLinear = "linear"
+
+	// CalibrationModelPercent gives the reading as a percentage of the scale
+	// above the offset, as used by the moisture sensor
+	CalibrationModelPercent = "percent"
+
+	// CalibrationModelTable interpolates between the points of a lookup table
+	CalibrationModelTable = "table"
+
+	// CalibrationModelPolynomial uses a polynomial of up to the 3rd order
+	CalibrationModelPolynomial = "polynomial"
+)
+
+// maxPolynomialOrder is the highest order a polynomial calibration can have
+const maxPolynomialOrder = 3
+
 type calibration struct {
+	Model  string  `json:"model,omitempty"`
 	Scale  float64 `json:"scale"`
 	Offset float64 `json:"offset"`
+
+	// Table is the lookup table of a table model, mapping each reading to the
+	// buffer value, sorted by the reading
+	Table []CalibrationPoint `json:"table,omitempty"`
+
+	// Coeffs are the coefficients of a polynomial model, lowest order first
+	Coeffs []float64 `json:"coeffs,omitempty"`
+
+	// RateRef and RateCoef correct the volume of each tip of a tipping bucket
+	// for how fast it is tipping
+	RateRef  float64 `json:"rate_ref,omitempty"`
+	RateCoef float64 `json:"rate_coef,omitempty"`
 }
 
-func (c calibration) Transform(v float64) float64 {
-	return (v * c.Scale) + c.Offset
+// defaultCalibrationModel returns the model used by calibrations of the key
+// that don't give one, which includes those saved before there were models
+func defaultCalibrationModel(key string) string {
+	if key == "moisture" {
+		return CalibrationModelPercent
+	}
+
+	return CalibrationModelLinear
 }
 
-func (c calibration) TransformPercent(v float64) float64 {
-	return ((v - c.Offset) / c.Scale) * 100
+// InvalidCalibrationError is returned when a calibration doesn't have what its
+// model needs
+type InvalidCalibrationError struct {
+	Model  string
+	Reason string
 }
 
-// SetCalibration sets the calibration for the given field
-func (tr *Translater) SetCalibration(field string, scale, offset float64) error {
-	var translatable bool
-	for _, f := range translatableFields {
-		if f == field {
-			translatable = true
+func (e InvalidCalibrationError) Error() string {
+	return fmt.Sprintf("invalid %s calibration: %s", e.Model, e.Reason)
+}
+
+// validate checks that the calibration has what its model needs
+func (c calibration) validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return InvalidCalibrationError{c.Model, fmt.Sprintf(format, args...)}
+	}
+
+	switch c.Model {
+	case CalibrationModelLinear:
+	case CalibrationModelPercent:
+		if c.Scale == 0 {
+			return invalid("the scale can't be 0")
+		}
+	case CalibrationModelTable:
+		if len(c.Table) < 2 {
+			return invalid("need at least 2 table points, got %d", len(c.Table))
+		}
+
+		for i := 1; i < len(c.Table); i++ {
+			if c.Table[i].Reading <= c.Table[i-1].Reading {
+				return invalid("the table readings must be in strictly increasing order")
+			}
+		}
+	case CalibrationModelPolynomial:
+		if len(c.Coeffs) == 0 || len(c.Coeffs) > maxPolynomialOrder+1 {
+			return invalid("need 1 to %d coefficients, got %d", maxPolynomialOrder+1, len(c.Coeffs))
 		}
+	default:
+		return invalid("unknown model")
 	}
 
-	if !translatable {
-		return ErrNotTranslatable
+	return nil
+}
+
+// tipAdjust returns how much more volume a tip at the rate in tips per minute
+// holds than the scale, never taking the tip below nothing
+func (c calibration) tipAdjust(rate float64) float64 {
+	if c.RateCoef == 0 {
+		return 0
+	}
+
+	return math.Max(c.RateCoef*(rate-c.RateRef), -c.Scale)
+}
+
+// Transform translates the reading using the model of the calibration
+func (c calibration) Transform(v float64) float64 {
+	switch c.Model {
+	case CalibrationModelPercent:
+		return ((v - c.Offset) / c.Scale) * 100
+	case CalibrationModelTable:
+		return c.interpolate(v)
+	case CalibrationModelPolynomial:
+		y := 0.0
+		for i := len(c.Coeffs) - 1; i >= 0; i-- {
+			y = y*v + c.Coeffs[i]
+		}
+		return y
 	}
 
-	return tr.jdb.Set(field, calibration{scale, offset})
+	return (v * c.Scale) + c.Offset
+}
+
+// interpolate finds the value for the reading between the table points, the
+// first and last segments are carried on for readings outside the table
+func (c calibration) interpolate(v float64) float64 {
+	i := sort.Search(len(c.Table)-1, func(i int) bool { return c.Table[i+1].Reading >= v })
+	if i == len(c.Table)-1 {
+		i--
+	}
+
+	a, b := c.Table[i], c.Table[i+1]
+	return a.Buffer + (v-a.Reading)*(b.Buffer-a.Buffer)/(b.Reading-a.Reading)
+}
+
+// SetCalibration sets the calibration for the given field
+func (tr *Translater) SetCalibration(field string, scale, offset float64) error {
+	_, err := tr.Calibrate(CalibrationRecord{Field: field, Scale: scale, Offset: offset})
+	return err
 }
 
 // getCalibration gets the calibration for the given field
 func (tr *Translater) getCalibration(field string) (calibration, error) {
 	calib := calibration{}
-	err := tr.jdb.Get(field, &calib)
-	return calib, err
+	if err := tr.jdb.Get(field, &calib); err != nil {
+		return calib, err
+	}
+
+	if calib.Model == "" {
+		calib.Model = defaultCalibrationModel(field)
+	}
+
+	return calib, nil
 }
 
 // Translate will convert the given value as per the calibrations stored for the given field
@@ -75,9 +192,5 @@ func (tr *Translater) Translate(field string, value float64) (float64, error) {
 		return value, fmt.Errorf("can't find calibration constant for %s", field)
 	}
 
-	if field == "moisture" {
-		return c.TransformPercent(value), nil
-	}
-
-	return c.Transform(float64(value)), nil
+	return c.Transform(value), nil
 }
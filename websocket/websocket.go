@@ -0,0 +1,261 @@
+// Package websocket is a minimal server side implementation of the WebSocket
+// protocol (RFC 6455), enough to push messages to browsers and read what
+// they send back.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The opcodes of the frames
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// MaxMessageSize is the largest message that will be read from a client
+const MaxMessageSize = 64 * 1024
+
+// acceptGUID is appended to the client's key to create the accept key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout is how long a frame can take to be written
+const writeTimeout = 10 * time.Second
+
+// Conn is a WebSocket connection to a client
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	mu     *sync.Mutex
+	closed bool
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for the given client key
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains returns true if the comma separated header contains the token
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsUpgrade returns true if the request is asking for a WebSocket
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade takes over the connection of the request and completes the WebSocket
+// handshake, an error response is sent if the request is not a valid upgrade
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	switch {
+	case r.Method != "GET":
+		http.Error(w, "websocket requests must be a GET", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("bad method %s", r.Method)
+	case !IsUpgrade(r) || key == "":
+		http.Error(w, "not a websocket upgrade request", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket upgrade request")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version %s", r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets are not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response writer can't be hijacked")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	res := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(res)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, r: rw.Reader, mu: new(sync.Mutex)}, nil
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(msg []byte) error {
+	return c.WriteMessage(OpText, msg)
+}
+
+// WriteMessage sends a message in a single frame, server frames are not masked
+func (c *Conn) WriteMessage(op byte, msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+
+	header := []byte{0x80 | op}
+	switch n := len(msg); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, msg...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// readFrame reads a single frame, unmasking the payload
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	head := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, head); err != nil {
+		return
+	}
+
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+
+	if n > MaxMessageSize {
+		err = fmt.Errorf("frame of %d bytes is too big", n)
+		return
+	}
+
+	// clients must mask the frames they send
+	if !masked {
+		err = fmt.Errorf("frame from client is not masked")
+		return
+	}
+
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(c.r, mask); err != nil {
+		return
+	}
+
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return
+}
+
+// ReadMessage reads the next text or binary message from the client, answering
+// pings along the way, io.EOF is returned when the client closes the connection
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var op byte
+	var msg []byte
+
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch fop {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+
+		case OpPong:
+			continue
+
+		case OpClose:
+			c.WriteMessage(OpClose, payload)
+			c.Close()
+			return 0, nil, io.EOF
+
+		case OpText, OpBinary:
+			op = fop
+			msg = payload
+
+		case OpContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > MaxMessageSize {
+				return 0, nil, fmt.Errorf("message of %d bytes is too big", len(msg))
+			}
+
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode 0x%X", fop)
+		}
+
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// dial does the client side of the handshake and returns the connection
+func dial(url string) (net.Conn, *bufio.Reader, *http.Response, error) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	req := "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	return conn, r, res, err
+}
+
+// clientFrame returns a masked frame as a client would send it
+func clientFrame(op byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range []byte(payload) {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// readServerFrame reads an unmasked frame sent by the server
+func readServerFrame(r *bufio.Reader) (byte, string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, "", err
+	}
+
+	payload := make([]byte, head[1]&0x7F)
+	_, err := io.ReadFull(r, payload)
+	return head[0] & 0x0F, string(payload), err
+}
+
+func TestAcceptKey(t *testing.T) {
+	Convey("the accept key should match the example in the RFC", t, func() {
+		So(AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), ShouldEqual, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	})
+}
+
+func TestConn(t *testing.T) {
+	Convey("given a server that echoes the messages it is sent", t, func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(w, r)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			for {
+				op, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(op, msg)
+			}
+		}))
+		defer srv.Close()
+
+		Convey("a plain HTTP request should be refused", func() {
+			res, err := http.Get(srv.URL)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, 400)
+		})
+
+		Convey("when a client connects", func() {
+			conn, r, res, err := dial(srv.URL)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			Convey("the handshake should be accepted", func() {
+				So(res.StatusCode, ShouldEqual, 101)
+				So(res.Header.Get("Sec-WebSocket-Accept"), ShouldEqual, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+			})
+
+			Convey("a text message should be echoed", func() {
+				conn.Write(clientFrame(OpText, "hello"))
+				op, msg, err := readServerFrame(r)
+				So(err, ShouldBeNil)
+				So(op, ShouldEqual, OpText)
+				So(msg, ShouldEqual, "hello")
+			})
+
+			Convey("a fragmented message should be joined", func() {
+				first := clientFrame(OpText, "hel")
+				first[0] &^= 0x80
+				conn.Write(first)
+				conn.Write(clientFrame(OpContinuation, "lo"))
+
+				_, msg, err := readServerFrame(r)
+				So(err, ShouldBeNil)
+				So(msg, ShouldEqual, "hello")
+			})
+
+			Convey("a ping should be answered with a pong", func() {
+				conn.Write(clientFrame(OpPing, "beat"))
+				op, msg, err := readServerFrame(r)
+				So(err, ShouldBeNil)
+				So(op, ShouldEqual, OpPong)
+				So(msg, ShouldEqual, "beat")
+			})
+
+			Convey("a close should be answered and the connection closed", func() {
+				conn.Write(clientFrame(OpClose, ""))
+				op, _, err := readServerFrame(r)
+				So(err, ShouldBeNil)
+				So(op, ShouldEqual, OpClose)
+
+				_, err = r.ReadByte()
+				So(err, ShouldEqual, io.EOF)
+			})
+		})
+	})
+}